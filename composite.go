@@ -0,0 +1,190 @@
+package pg
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// compositeTypes registered composite types, by the struct type
+var compositeTypes sync.Map
+
+type compositeType struct {
+	name    string
+	columns []tableColumn
+}
+
+// RegisterComposite registers the struct of example as the Go representation of the PostgreSQL composite type name,
+// allowing it to be bound and scanned with Composite. The attributes of the composite type are mapped, in order, to
+// the fields of the struct (see the `pg` tag, fields tagged `pg:"-"` are skipped). Ex.
+//
+//	// CREATE TYPE address AS (street text, number int, complement text)
+//	type Address struct {
+//		Street     string
+//		Number     int
+//		Complement *string
+//	}
+//
+//	pg.RegisterComposite("address", Address{})
+//
+// Fields are strings, numbers, booleans, time.Time, other registered composites or pointers to them (for NULL).
+// Panics if example is not a struct.
+func RegisterComposite(name string, example interface{}) {
+	t := reflect.TypeOf(example)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("pg: RegisterComposite %s requires a struct, got %T", name, example))
+	}
+	compositeTypes.Store(t, &compositeType{name: name, columns: structColumns(t)})
+}
+
+// CompositeValue binds and scans a registered composite type, see Composite
+type CompositeValue struct {
+	value interface{}
+}
+
+// Composite binds (struct or pointer to struct) and scans (pointer to struct, or pointer to pointer for NULL) the
+// value of a composite type registered with RegisterComposite. Ex.
+//
+//	db.ExecCtx(ctx, "INSERT INTO customers (name, address) VALUES ($1, $2)", name, pg.Composite(address))
+//
+//	var address Address
+//	db.QueryRowCtx(ctx, "SELECT address FROM customers WHERE id = $1", id).Scan(pg.Composite(&address))
+func Composite(value interface{}) CompositeValue {
+	return CompositeValue{value: value}
+}
+
+// Value implements the driver Valuer interface.
+func (c CompositeValue) Value() (driver.Value, error) {
+	v := reflect.ValueOf(c.value)
+	for v.IsValid() && v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil, nil
+	}
+	return formatComposite(v)
+}
+
+// Scan implements the Scanner interface.
+func (c CompositeValue) Scan(src any) error {
+	dest := reflect.ValueOf(c.value)
+	if !dest.IsValid() || dest.Kind() != reflect.Pointer || dest.IsNil() {
+		return errors.New(fmt.Sprintf("scan destination of composite must be a non-nil pointer, got %T", c.value))
+	}
+	dest = dest.Elem()
+
+	var literal string
+	switch v := src.(type) {
+	case nil:
+		dest.Set(reflect.Zero(dest.Type()))
+		return nil
+	case string:
+		literal = v
+	case []byte:
+		literal = string(v)
+	default:
+		return errors.New(fmt.Sprintf("unsupported type %T for composite %s", src, dest.Type()))
+	}
+
+	if dest.Kind() == reflect.Pointer {
+		value := reflect.New(dest.Type().Elem())
+		if err := parseComposite(value.Elem(), literal); err != nil {
+			return err
+		}
+		dest.Set(value)
+		return nil
+	}
+	return parseComposite(dest, literal)
+}
+
+func lookupComposite(t reflect.Type) (*compositeType, bool) {
+	ct, ok := compositeTypes.Load(t)
+	if !ok {
+		return nil, false
+	}
+	return ct.(*compositeType), true
+}
+
+func formatComposite(v reflect.Value) (string, error) {
+	ct, ok := lookupComposite(v.Type())
+	if !ok {
+		return "", errors.New(fmt.Sprintf("composite type not registered for %s, see RegisterComposite", v.Type()))
+	}
+
+	var s strings.Builder
+	s.WriteByte('(')
+	for i, column := range ct.columns {
+		if i > 0 {
+			s.WriteByte(',')
+		}
+		field := v.FieldByIndex(column.index)
+		if field.Kind() == reflect.Pointer {
+			if field.IsNil() {
+				continue
+			}
+			field = field.Elem()
+		}
+
+		var text string
+		var err error
+		if _, nested := lookupComposite(field.Type()); nested {
+			text, err = formatComposite(field)
+		} else {
+			text, err = formatTextValue(field)
+		}
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("invalid attribute %s of composite %s (cause: %s)", column.name, ct.name, err.Error()))
+		}
+		s.WriteString(quoteTextValue(text))
+	}
+	s.WriteByte(')')
+	return s.String(), nil
+}
+
+func parseComposite(dest reflect.Value, literal string) error {
+	ct, ok := lookupComposite(dest.Type())
+	if !ok {
+		return errors.New(fmt.Sprintf("composite type not registered for %s, see RegisterComposite", dest.Type()))
+	}
+
+	literal = strings.TrimSpace(literal)
+	if len(literal) < 2 || literal[0] != '(' || literal[len(literal)-1] != ')' {
+		return errors.New(fmt.Sprintf("invalid composite literal %q for %s", literal, ct.name))
+	}
+	elements, err := splitTextElements(literal[1 : len(literal)-1])
+	if err != nil || len(elements) != len(ct.columns) {
+		return errors.New(fmt.Sprintf("invalid composite literal %q for %s (expected %d attributes)", literal, ct.name, len(ct.columns)))
+	}
+
+	for i, column := range ct.columns {
+		field := dest.FieldByIndex(column.index)
+		if elements[i].null {
+			field.Set(reflect.Zero(field.Type()))
+			continue
+		}
+		if field.Kind() == reflect.Pointer {
+			value := reflect.New(field.Type().Elem())
+			field.Set(value)
+			field = value.Elem()
+		}
+
+		if _, nested := lookupComposite(field.Type()); nested {
+			err = parseComposite(field, elements[i].text)
+		} else {
+			err = parseTextValue(field, elements[i].text)
+		}
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid attribute %s of composite %s (cause: %s)", column.name, ct.name, err.Error()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package pg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+type compositeCity struct {
+	Name    string
+	Country string
+}
+
+type compositeAddress struct {
+	Street     string
+	Number     int
+	Complement *string
+	City       compositeCity
+	Ignored    string `pg:"-"`
+}
+
+func init() {
+	RegisterComposite("test_city", compositeCity{})
+	RegisterComposite("test_address", compositeAddress{})
+}
+
+func TestComposite(t *testing.T) {
+	complement := `apt "1"`
+	address := compositeAddress{Street: "Main St, North", Number: 10, Complement: &complement, City: compositeCity{Name: "Springfield", Country: "US"}}
+
+	value, err := Composite(address).Value()
+	want := `("Main St, North","10","apt \"1\"","(\"Springfield\",\"US\")")`
+	if err != nil || value != want {
+		t.Errorf("Value() = %v, %v", value, err)
+	}
+	if value, err = Composite((*compositeAddress)(nil)).Value(); err != nil || value != nil {
+		t.Errorf("expected NULL, got %v, %v", value, err)
+	}
+	if _, err = Composite(struct{ A int }{}).Value(); err == nil {
+		t.Error("expected error for unregistered type")
+	}
+
+	var scanned compositeAddress
+	if err = Composite(&scanned).Scan([]byte(want)); err != nil || !reflect.DeepEqual(scanned, address) {
+		t.Errorf("Scan() = %+v, %v", scanned, err)
+	}
+	if err = Composite(&scanned).Scan(`(Elm,2,,"(Shelbyville,US)")`); err != nil || scanned.Complement != nil || scanned.City.Name != "Shelbyville" {
+		t.Errorf("Scan() = %+v, %v", scanned, err)
+	}
+	var pointer *compositeAddress
+	if err = Composite(&pointer).Scan(nil); err != nil || pointer != nil {
+		t.Errorf("Scan(nil) = %+v, %v", pointer, err)
+	}
+	for _, invalid := range []interface{}{10, "(a,b)", "(a,x,,\"(b,c)\")"} {
+		if err = Composite(&scanned).Scan(invalid); err == nil {
+			t.Errorf("expected error for %v", invalid)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for non struct")
+		}
+	}()
+	RegisterComposite("invalid", 10)
+}
+
+func TestCompositeRoundTrip(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		if _, err := db.Execute(`CREATE TYPE test_city AS (name text, country text);
+			CREATE TYPE test_address AS (street text, number int, complement text, city test_city)`); err != nil {
+			t.Fatal(err)
+		}
+
+		address := compositeAddress{Street: `Main "St", North`, Number: 10, City: compositeCity{Name: "Springfield", Country: "US"}}
+		var scanned compositeAddress
+		var number int
+		err := db.QueryRowOld("SELECT $1::test_address, ($1::test_address).number", Composite(address)).Scan(Composite(&scanned), &number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(scanned, address) || number != 10 {
+			t.Errorf("unexpected composite %+v (number %d)", scanned, number)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package pg
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Cond is a condition of a WHERE clause (see BuildWhere). It appends its arguments and returns the SQL fragment,
+// with the placeholders numbered by the position of the arguments.
+type Cond func(args *[]interface{}) string
+
+// BuildWhere joins the conditions with AND, returning the SQL and the arguments (placeholders starting at $1)
+func BuildWhere(conds ...Cond) (string, []interface{}) {
+	var args []interface{}
+	var parts []string
+	for _, cond := range conds {
+		parts = append(parts, cond(&args))
+	}
+	return strings.Join(parts, " AND "), args
+}
+
+// Compare produces `expr op $n`, like Compare("age", ">=", 18) or Compare(JsonbExtract("data", "a"), "=", "b").
+// Plain column names are quoted, other expressions are used as is.
+func Compare(expr string, op string, value interface{}) Cond {
+	return func(args *[]interface{}) string {
+		return quoteColumn(expr) + " " + op + " " + bindArg(args, bindValue(value))
+	}
+}
+
+// InSubquery produces `col IN (subquery)`, like InSubquery("user_id", "SELECT id FROM users WHERE tenant = $1", "t1").
+// The placeholders of the subquery are numbered from $1 for its args, and renumbered after the args of the other
+// conditions.
+func InSubquery(col string, subquery string, subqueryArgs ...interface{}) Cond {
+	return func(args *[]interface{}) string {
+		sql := renumberPlaceholders(subquery, len(*args))
+		for _, arg := range subqueryArgs {
+			*args = append(*args, bindValue(arg))
+		}
+		return quoteColumn(col) + " IN (" + sql + ")"
+	}
+}
+
+// JsonbContains produces `col @> $n::jsonb`, true when the jsonb column contains the value
+func JsonbContains(col string, value map[string]interface{}) Cond {
+	return func(args *[]interface{}) string {
+		return quoteColumn(col) + " @> " + bindArg(args, Jsonb(value)) + "::jsonb"
+	}
+}
+
+// JsonbHasKey produces `col ? $n`, true when the key exists at the top level of the jsonb column
+func JsonbHasKey(col string, key string) Cond {
+	return func(args *[]interface{}) string {
+		return quoteColumn(col) + " ? " + bindArg(args, key)
+	}
+}
+
+// JsonbExtract produces `col #>> '{a,b}'`, the expression that extracts the value at the path as text
+func JsonbExtract(col string, path ...string) string {
+	elements := make([]string, len(path))
+	for i, element := range path {
+		if element == "" || strings.ContainsAny(element, `{},"\ `) {
+			element = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(element) + `"`
+		}
+		elements[i] = element
+	}
+	return quoteColumn(col) + " #>> " + QuoteLiteral("{"+strings.Join(elements, ",")+"}")
+}
+
+// bindArg appends the value to the arguments, returning its placeholder
+func bindArg(args *[]interface{}, value interface{}) string {
+	*args = append(*args, value)
+	return "$" + strconv.Itoa(len(*args))
+}
+
+var placeholderRegex = regexp.MustCompile(`\$(\d+)`)
+
+// renumberPlaceholders adds the offset to the placeholders of the sql ($1 becomes $<1+offset>), used to embed SQL
+// fragments with their own arguments
+func renumberPlaceholders(sql string, offset int) string {
+	if offset == 0 {
+		return sql
+	}
+	return placeholderRegex.ReplaceAllStringFunc(sql, func(placeholder string) string {
+		n, _ := strconv.Atoi(placeholder[1:])
+		return "$" + strconv.Itoa(n+offset)
+	})
+}
+
+// quoteColumn quotes plain column names, other expressions are returned as is
+func quoteColumn(expr string) string {
+	if identifierRegex.MatchString(expr) {
+		return QuoteIdentifier(expr)
+	}
+	return expr
+}
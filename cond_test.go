@@ -0,0 +1,92 @@
+package pg
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+func TestBuildWhere(t *testing.T) {
+	query, args := BuildWhere(
+		Compare("active", "=", true),
+		JsonbContains("data", map[string]interface{}{"role": "admin"}),
+		JsonbHasKey("data", "email"),
+		Compare(JsonbExtract("data", "address", "city"), "=", "Lisbon"),
+	)
+
+	want := `"active" = $1 AND "data" @> $2::jsonb AND "data" ? $3 AND "data" #>> '{address,city}' = $4`
+	if query != want {
+		t.Errorf("got  %s\nwant %s", query, want)
+	}
+	if contains, err := args[1].(driver.Valuer).Value(); err != nil || string(contains.([]byte)) != `{"role":"admin"}` {
+		t.Errorf("unexpected jsonb arg %v (%v)", contains, err)
+	}
+	args[1] = nil
+	if wantArgs := []interface{}{true, nil, "email", "Lisbon"}; !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	if got := JsonbExtract("data", "a b", "c"); got != `"data" #>> '{"a b",c}'` {
+		t.Errorf("unexpected JsonbExtract %s", got)
+	}
+}
+
+func TestInSubquery(t *testing.T) {
+	query, args := BuildWhere(
+		Compare("status", "=", "open"),
+		InSubquery("user_id", "SELECT id FROM users WHERE tenant = $1 AND age > $2", "t1", 18),
+		Compare("priority", ">", 2),
+	)
+
+	want := `"status" = $1 AND "user_id" IN (SELECT id FROM users WHERE tenant = $2 AND age > $3) AND "priority" > $4`
+	if query != want {
+		t.Errorf("got  %s\nwant %s", query, want)
+	}
+	if wantArgs := []interface{}{"open", "t1", 18, 2}; !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	// $10 must not be read as $1
+	if got := renumberPlaceholders("a = $1 AND b = $10", 2); got != "a = $3 AND b = $12" {
+		t.Errorf("unexpected renumbering %s", got)
+	}
+}
+
+func TestJsonbConditions(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		_, err := db.Execute("CREATE TABLE jsonb_cond (id INT PRIMARY KEY, data JSONB)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Execute(`INSERT INTO jsonb_cond VALUES
+			(1, '{"role": "admin", "address": {"city": "Lisbon"}}'),
+			(2, '{"role": "user", "email": "a@b.c", "address": {"city": "Porto"}}')`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			cond Cond
+			want int64
+		}{
+			{JsonbContains("data", map[string]interface{}{"role": "admin"}), 1},
+			{JsonbHasKey("data", "email"), 2},
+			{Compare(JsonbExtract("data", "address", "city"), "=", "Porto"), 2},
+		}
+		for _, tt := range tests {
+			where, args := BuildWhere(tt.cond)
+			id, err := db.QueryForInt("SELECT id FROM jsonb_cond WHERE "+where, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tt.want {
+				t.Errorf("%s: got %d, want %d", where, id, tt.want)
+			}
+		}
+	})
+}
@@ -3,21 +3,45 @@ package pg
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/lib/pq"
 	_ "github.com/lib/pq"
+	"github.com/nidorx/retry"
+)
+
+var (
+	ErrPoolTimeout = errors.New("timeout while acquiring a connection from the pool")
+	ErrReadOnly    = errors.New("write operation on a read-only database")
+	ErrConnClosed  = errors.New("connection already returned to the pool (CloseConn)")
 )
 
 type Database struct {
 	db         *sql.DB
 	tx         *sql.Tx
 	conn       *sql.Conn
+	ownsConn   bool      // conn was acquired by BeginTx and must be released when the transaction ends
+	closed     bool      // conn was returned to the pool by CloseConn
+	owner      *Database // the instance that owns tx and conn, for the copies bound to them (see clone)
+	onCommit   []func()
+	onRollback []func()
+	readOnly   bool
+	timestamps *TimestampColumns // see WithTimestamps
+	pool       *poolState
+	metrics    *metricCounters // shared by all derived instances, see Metrics
+	tracked    bool            // the connection or transaction of this instance is registered in pool
+	maxSqlLog  int             // truncates the SQL logged by debugQuery, see MigrationConfig.MaxDebugSQLLen
+	longTx     *longTxInfo     // see Config.LongTransactionThreshold
 	logger     Logger
 	config     *Config
 	migrations []*Migration
+	migOptions MigrationOptions // see SetMigrationOptions
 	id         string
 }
 
@@ -32,6 +56,48 @@ type Config struct {
 	Params   map[string][]string // Connection params
 	DebugSql bool                // debug queries
 	Logger   Logger              // Logger instance
+
+	// AcquireTimeout maximum time to wait for a connection from the pool. Zero waits indefinitely.
+	AcquireTimeout time.Duration
+
+	// ConnectTimeout maximum time to establish a new connection (libpq connect_timeout, in seconds). Zero waits
+	// indefinitely.
+	ConnectTimeout time.Duration
+
+	// TCPKeepalivesIdle time a connection remains idle before TCP starts sending keepalive probes, to detect peers
+	// that silently dropped the connection (e.g. load balancers). Zero uses the system default.
+	TCPKeepalivesIdle time.Duration
+
+	// TCPKeepalivesInterval time between keepalive probes that are not acknowledged. Zero, or systems other than
+	// linux, use TCPKeepalivesIdle.
+	TCPKeepalivesInterval time.Duration
+
+	// Role when set, every connection of the pool assumes this role (SET ROLE) when it is established, so the
+	// application connects with a login role and runs with a limited functional role. current_user is the role and
+	// session_user the login role. RESET ROLE (or DISCARD ALL) by the application reverts to the login role.
+	//
+	// Migrate runs the migrations and writes the history table on its own connections, which do not assume the role
+	// (see MigrationConfig.SessionSetup). The schema and table checks that precede them (CREATE SCHEMA, whether the
+	// history table and its extra columns exist, the max(installed_rank) of MigrationConfig.CacheFile) run on the pool
+	// of the Database, with the role, unless MigrationConfig.Username or Database open a pool for the migration. The
+	// role must be allowed to create the schemas, and to read the history table, as information_schema only lists the
+	// objects the role has privileges on.
+	Role string
+
+	// LongTransactionThreshold when set, a transaction that takes longer than this between BeginTx and
+	// Commit/Rollback logs a warning with its duration, isolation level and the location that started it. Long
+	// transactions hold locks and prevent vacuum from removing dead rows. Zero disables the instrumentation.
+	LongTransactionThreshold time.Duration
+
+	// OnConnect when set, is invoked once for each new physical connection of the pool (after Role is set), before
+	// the connection is used, e.g. to SET application_name or timezone. Connections reused from the pool do not
+	// invoke it again, but as connections are closed and replaced (see sql.DB.SetConnMaxLifetime), the callback must
+	// be fast. The db received only uses that connection and must not be retained. An error discards the
+	// connection. Only applies to Open, not OpenDB.
+	//
+	// Like Role, it is not invoked on the connections opened by Migrate, for the migrations and the history table or
+	// for a MigrationConfig.Username or Database, see MigrationConfig.SessionSetup.
+	OnConnect func(ctx context.Context, db *Database) error
 }
 
 func (c *Config) ConnString(customParams map[string]string) string {
@@ -44,6 +110,11 @@ func (c *Config) ConnString(customParams map[string]string) string {
 		params.Set("sslmode", c.SSLMode)
 	}
 
+	if c.ConnectTimeout > 0 {
+		// seconds, rounded up (zero would disable the timeout)
+		params.Set("connect_timeout", strconv.FormatInt(int64((c.ConnectTimeout+time.Second-1)/time.Second), 10))
+	}
+
 	if customParams != nil {
 		for k, v := range customParams {
 			params.Set(k, v)
@@ -62,22 +133,126 @@ func (c *Config) ConnString(customParams map[string]string) string {
 	return u.String()
 }
 
+// String returns the connection string with the password masked, safe to be logged or included in errors
+func (c *Config) String() string {
+	return c.redactedConnString(nil)
+}
+
+// redactedConnString the ConnString with the password (and any password param, e.g. sslpassword) masked
+func (c *Config) redactedConnString(customParams map[string]string) string {
+	u, err := url.Parse(c.ConnString(customParams))
+	if err != nil {
+		return "postgres://" + c.Host
+	}
+
+	params := u.Query()
+	for key := range params {
+		if strings.Contains(strings.ToLower(key), "password") {
+			params.Set(key, "xxxxx")
+		}
+	}
+	u.RawQuery = params.Encode()
+
+	return u.Redacted()
+}
+
 // Open opens a database
 func Open(config *Config) (*Database, error) {
-	connString := config.ConnString(nil)
-	db, err := sql.Open("postgres", connString)
+	db, err := openPool(config, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	return OpenDB(db, config), nil
+}
+
+// openPool opens the connection pool of the config, with the customParams added to the connection string. The
+// keepalives are set by the dialer and, when init is set, the Role and OnConnect by the connector. Also used for the
+// pools of the migrations (see newSchemaConnection), which do not assume the Role.
+func openPool(config *Config, customParams map[string]string, init bool) (*sql.DB, error) {
+	keepalives := config.TCPKeepalivesIdle > 0 || config.TCPKeepalivesInterval > 0
+	if !keepalives && (!init || (config.Role == "" && config.OnConnect == nil)) {
+		return sql.Open("postgres", config.ConnString(customParams))
+	}
+
+	connector, err := pq.NewConnector(config.ConnString(customParams))
+	if err != nil {
+		// the errors of the driver may include the connection string
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		cause := err.Error()
+		if config.Password != "" {
+			cause = strings.ReplaceAll(cause, config.Password, "xxxxx")
+		}
+		return nil, errors.New(fmt.Sprintf("invalid connection string %s (cause: %s)", config.String(), cause))
+	}
+	if keepalives {
+		// lib/pq does not support the keepalives params, they are set by the dialer
+		connector.Dialer(&keepaliveDialer{idle: config.TCPKeepalivesIdle, interval: config.TCPKeepalivesInterval})
+	}
+	// lib/pq has no connection init callback, the role and OnConnect are handled by the connector
+	var initConnector driver.Connector = connector
+	if init && config.Role != "" {
+		initConnector = &roleConnector{Connector: initConnector, role: config.Role}
+	}
+	if init && config.OnConnect != nil {
+		initConnector = &onConnectConnector{Connector: initConnector, config: config}
+	}
+	return sql.OpenDB(initConnector), nil
+}
+
+// OpenWithRetry opens a database and waits until it is reachable (Ping), retrying with exponential backoff.
+//
+// The first retry waits for backoff, doubling on each attempt up to 30 seconds. When maxAttempts is exhausted, the
+// last error is returned. A maxAttempts lower than 1 retries forever.
+func OpenWithRetry(config *Config, maxAttempts int, backoff time.Duration) (*Database, error) {
+	db, err := Open(config)
+	if err != nil {
+		return nil, err
+	}
+
+	maxBackoff := 30 * time.Second
+	if backoff > maxBackoff {
+		maxBackoff = backoff
+	}
+
+	retries := retry.New(maxAttempts-1, func(ctx context.Context, err error, attempt int, willRetry bool, nextRetry time.Duration) {
+		db.logger.Warn("Unable to connect to database (attempt %d of %d). cause: %v", attempt, maxAttempts, err)
+		if willRetry {
+			db.logger.Info("Retrying in %s", nextRetry.String())
+		}
+	})
+	retries.SetExponentialBackoff(int(backoff.Milliseconds()), int(maxBackoff.Milliseconds()), 2)
+
+	err = retries.Execute(context.Background(), func(ctx context.Context, attempt int) error {
+		return db.db.PingContext(ctx)
+	})
 	if err != nil {
+		_ = db.Close()
 		return nil, err
 	}
 
+	return db, nil
+}
+
+// OpenDB creates a Database using an existing *sql.DB.
+//
+// Allows using any database/sql driver, as sqlmock in unit tests. The connection fields of config are only used
+// for identification (see GetInstance) and by Migrate.
+func OpenDB(db *sql.DB, config *Config) *Database {
+	connString := config.ConnString(nil)
+
 	if config.Logger == nil {
 		config.Logger = defaultLogger()
 	}
 
 	instance := &Database{
-		db:     db,
-		logger: config.Logger,
-		config: config,
+		db:      db,
+		logger:  config.Logger,
+		config:  config,
+		pool:    &poolState{},
+		metrics: &metricCounters{},
 	}
 
 	instancesMu.Lock()
@@ -90,23 +265,20 @@ func Open(config *Config) (*Database, error) {
 		}
 	}
 	instance.id = id
-	if instances == nil {
-		instances = make(map[string]*Database)
-	}
 	instances[id] = instance
 	instancesMu.Unlock()
 
-	return instance, nil
+	return instance
 }
 
 // Close closes the database and prevents new queries from starting.
 func (d *Database) Close() error {
+	instancesMu.Lock()
 	if d.id != "" {
-		instancesMu.Lock()
 		delete(instances, d.id)
 		d.id = ""
-		instancesMu.Unlock()
 	}
+	instancesMu.Unlock()
 
 	if err := d.db.Close(); err != nil {
 		return err
@@ -119,17 +291,27 @@ func (d *Database) Close() error {
 //
 // Every Conn must be returned to the pool after use by calling Database.CloseConn.
 func (d *Database) Conn() (*Database, error) {
-	conn, err := d.db.Conn(context.Background())
+	return d.ConnContext(context.Background())
+}
+
+// ConnContext returns a Database with a new connection.
+//
+// When Config.AcquireTimeout is set and no connection becomes available within the deadline, ErrPoolTimeout is
+// returned. Every Conn must be returned to the pool after use by calling Database.CloseConn.
+func (d *Database) ConnContext(ctx context.Context) (*Database, error) {
+	connDb := d.clone(false)
+	if err := connDb.track(); err != nil {
+		return nil, err
+	}
+
+	conn, err := d.acquireConn(ctx)
 	if err != nil {
+		connDb.untrack()
 		return nil, err
 	}
+	connDb.conn = conn
 
-	return &Database{
-		db:     d.db,
-		conn:   conn,
-		logger: d.logger,
-		config: d.config,
-	}, nil
+	return connDb, nil
 }
 
 // Begin starts a transaction.
@@ -143,33 +325,137 @@ func (d *Database) Begin() (*Database, error) {
 
 // BeginTx starts a transaction.
 func (d *Database) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Database, error) {
+	if d.readOnly && (opts == nil || !opts.ReadOnly) {
+		return nil, ErrReadOnly
+	}
+	if d.closed {
+		return nil, ErrConnClosed
+	}
+
+	txDb := d.clone(false)
+	if err := txDb.track(); err != nil {
+		return nil, err
+	}
+
 	var tx *sql.Tx
 	var err error
-	if d.conn != nil {
-		tx, err = d.conn.BeginTx(ctx, opts)
+	conn := d.conn
+	ownsConn := false
+	if conn == nil && d.config.AcquireTimeout > 0 {
+		// acquire the connection first, so that the timeout only applies to the pool wait
+		if conn, err = d.acquireConn(ctx); err != nil {
+			txDb.untrack()
+			return nil, err
+		}
+		ownsConn = true
+	}
+
+	if conn != nil {
+		tx, err = conn.BeginTx(ctx, opts)
+		err = connClosedErr(err)
 	} else {
 		tx, err = d.db.BeginTx(ctx, opts)
 	}
 
 	if err != nil {
+		if ownsConn {
+			_ = conn.Close()
+		}
+		txDb.untrack()
 		return nil, err
 	}
 
-	return &Database{
-		tx:     tx,
-		db:     d.db,
-		conn:   d.conn,
-		logger: d.logger,
-		config: d.config,
-	}, nil
+	txDb.tx = tx
+	txDb.conn = conn
+	txDb.ownsConn = ownsConn
+	txDb.longTx = d.newLongTxInfo(opts)
+
+	return txDb, nil
+}
+
+// acquireConn gets a connection from the pool, respecting Config.AcquireTimeout
+func (d *Database) acquireConn(ctx context.Context) (*sql.Conn, error) {
+	if d.config.AcquireTimeout <= 0 {
+		return d.db.Conn(ctx)
+	}
+
+	acquireCtx, cancel := context.WithTimeout(ctx, d.config.AcquireTimeout)
+	defer cancel()
+
+	conn, err := d.db.Conn(acquireCtx)
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(acquireCtx.Err(), context.DeadlineExceeded) {
+			return nil, ErrPoolTimeout
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ReadOnly returns a Database that rejects write operations with ErrReadOnly.
+//
+// Execute (and the builders using it, as InsertInto, Update, Upsert and DeleteWhere) and Begin are rejected, queries
+// are allowed. Transactions can still be started with BeginTx using sql.TxOptions{ReadOnly: true}. Connections and
+// transactions obtained from the returned Database are also read-only.
+//
+// The read-only copy of a transaction stays in that transaction: Begin returns the copy itself (as for any
+// transaction) and the OnCommit and OnRollback callbacks are invoked when the transaction ends, by either Database.
+func (d *Database) ReadOnly() *Database {
+	readOnly := d.clone(true)
+	readOnly.readOnly = true
+	return readOnly
+}
+
+// clone copies the settings of d inherited by the derived instances (Conn, Begin, ReadOnly, WithTimestamps). When
+// bound, the copy also uses the connection or transaction of d, owned by d (or by the owner of d): Commit, Rollback,
+// CloseConn, OnCommit and OnRollback of the copy act on the owner, which releases the connection.
+func (d *Database) clone(bound bool) *Database {
+	c := &Database{
+		db:         d.db,
+		logger:     d.logger,
+		config:     d.config,
+		readOnly:   d.readOnly,
+		timestamps: d.timestamps,
+		pool:       d.pool,
+		metrics:    d.metrics,
+		maxSqlLog:  d.maxSqlLog,
+	}
+	if bound {
+		c.owner = d
+		if d.owner != nil {
+			c.owner = d.owner
+		}
+		c.tx = d.tx
+		c.conn = d.conn
+		c.closed = d.closed
+	}
+	return c
+}
+
+// Stats returns database statistics, including the pool wait count and wait duration.
+func (d *Database) Stats() sql.DBStats {
+	return d.db.Stats()
 }
 
 // Commit commits the transaction.
 func (d *Database) Commit() error {
+	if d.owner != nil {
+		return d.owner.Commit()
+	}
 	if d.tx != nil {
 		err := d.tx.Commit()
+		d.metrics.commit(err)
+		d.checkLongTx("commit")
+		// the transaction is finished even on failure, so the acquired connection can always be released
+		d.releaseOwnedConn()
+		d.untrack()
 		if err == nil {
 			d.tx = nil
+			hooks := d.onCommit
+			d.onCommit, d.onRollback = nil, nil
+			for _, fn := range hooks {
+				fn()
+			}
 		} else {
 			return errors.New("Unable to commit transaction (cause: " + err.Error() + ")")
 		}
@@ -180,10 +466,22 @@ func (d *Database) Commit() error {
 
 // Rollback aborts the transaction.
 func (d *Database) Rollback() error {
+	if d.owner != nil {
+		return d.owner.Rollback()
+	}
 	if d.tx != nil {
 		err := d.tx.Rollback()
+		d.metrics.rollback(err)
+		d.checkLongTx("rollback")
+		d.releaseOwnedConn()
+		d.untrack()
 		if err == nil {
 			d.tx = nil
+			hooks := d.onRollback
+			d.onCommit, d.onRollback = nil, nil
+			for _, fn := range hooks {
+				fn()
+			}
 		} else {
 			return errors.New("Unable to rollback transaction. (cause: " + err.Error() + ")")
 		}
@@ -192,8 +490,52 @@ func (d *Database) Rollback() error {
 	return nil
 }
 
+// OnCommit registers a callback to be invoked after the current transaction is successfully committed.
+//
+// Useful for publishing events (outbox) or invalidating caches. Outside a transaction the callback is invoked
+// immediately.
+func (d *Database) OnCommit(fn func()) {
+	if d.owner != nil {
+		d.owner.OnCommit(fn)
+		return
+	}
+	if d.tx == nil {
+		fn()
+		return
+	}
+	d.onCommit = append(d.onCommit, fn)
+}
+
+// OnRollback registers a callback to be invoked after the current transaction is rolled back.
+//
+// Outside a transaction the callback is discarded.
+func (d *Database) OnRollback(fn func()) {
+	if d.owner != nil {
+		d.owner.OnRollback(fn)
+	} else if d.tx != nil {
+		d.onRollback = append(d.onRollback, fn)
+	}
+}
+
+// releaseOwnedConn returns to the pool the connection acquired by BeginTx
+func (d *Database) releaseOwnedConn() {
+	if d.ownsConn && d.conn != nil {
+		if err := d.conn.Close(); err != nil {
+			d.logger.Error(err)
+		}
+		d.conn = nil
+		d.ownsConn = false
+	}
+}
+
 // CloseConn returns the connection to the connection pool.
+//
+// Calling it again is a no-op. Using the Database after CloseConn (or a copy of it, e.g. ReadOnly) returns an error
+// wrapping ErrConnClosed, instead of silently running on another connection of the pool.
 func (d *Database) CloseConn() error {
+	if d.owner != nil {
+		return d.owner.CloseConn()
+	}
 	err := d.Rollback()
 	if err != nil {
 		return err
@@ -201,15 +543,26 @@ func (d *Database) CloseConn() error {
 
 	if d.conn != nil {
 		err = d.conn.Close()
-		if err != nil {
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
 			return err
 		}
 		d.conn = nil
+		d.closed = true
+		d.untrack()
 	}
 
 	return nil
 }
 
+// connClosedErr wraps sql.ErrConnDone, returned when the conn was closed by another copy of the Database, with
+// ErrConnClosed
+func connClosedErr(err error) error {
+	if errors.Is(err, sql.ErrConnDone) {
+		return fmt.Errorf("%w (cause: %w)", ErrConnClosed, err)
+	}
+	return err
+}
+
 func QuoteLiteral(literal string) string {
 	return pq.QuoteLiteral(literal)
 }
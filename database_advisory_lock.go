@@ -0,0 +1,92 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// advisoryLockPollInterval interval between the attempts of AdvisoryLock
+var advisoryLockPollInterval = 100 * time.Millisecond
+
+// TryAdvisoryLock tries to acquire the session level advisory lock of the key (pg_try_advisory_lock), without waiting,
+// e.g. to elect the instance that runs a singleton job. When acquired is false, the lock is held by another session.
+//
+// A connection of the pool is pinned while the lock is held, the release function (pg_advisory_unlock) unlocks it on
+// the same connection and returns the connection to the pool. When d is a connection (Conn) or a transaction, its
+// connection is used. The lock is not released by the end of a transaction, only by release or the end of the session.
+func (d *Database) TryAdvisoryLock(key int64) (acquired bool, release func() error, err error) {
+	conn, pinned, err := d.advisoryLockConn(context.Background())
+	if err != nil {
+		return false, nil, err
+	}
+
+	if acquired, err = conn.QueryForBoolean("SELECT pg_try_advisory_lock($1)", key); err != nil || !acquired {
+		if pinned {
+			_ = conn.CloseConn()
+		}
+		return false, nil, err
+	}
+	return true, advisoryUnlock(conn, key, pinned), nil
+}
+
+// AdvisoryLock acquires the session level advisory lock of the key, waiting while it is held by another session. The
+// lock is polled with pg_try_advisory_lock, so the wait ends with the error of the context when it is canceled. See
+// TryAdvisoryLock.
+func (d *Database) AdvisoryLock(ctx context.Context, key int64) (release func() error, err error) {
+	conn, pinned, err := d.advisoryLockConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		acquired, err := conn.QueryForBoolean("SELECT pg_try_advisory_lock($1)", key)
+		if err == nil && acquired {
+			return advisoryUnlock(conn, key, pinned), nil
+		}
+		if err == nil {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-time.After(advisoryLockPollInterval):
+				continue
+			}
+		}
+		if pinned {
+			_ = conn.CloseConn()
+		}
+		return nil, err
+	}
+}
+
+// advisoryLockConn the connection that holds the advisory lock, pinned when d is the pool
+func (d *Database) advisoryLockConn(ctx context.Context) (conn *Database, pinned bool, err error) {
+	if d.tx != nil || d.conn != nil {
+		return d, false, nil
+	}
+	conn, err = d.ConnContext(ctx)
+	return conn, err == nil, err
+}
+
+// advisoryUnlock the release function of an advisory lock, releasing the pinned connection. Only the first call unlocks.
+func advisoryUnlock(conn *Database, key int64, pinned bool) func() error {
+	var once sync.Once
+	var err error
+	return func() error {
+		once.Do(func() {
+			var unlocked bool
+			unlocked, err = conn.QueryForBoolean("SELECT pg_advisory_unlock($1)", key)
+			if err == nil && !unlocked {
+				err = errors.New(fmt.Sprintf("advisory lock %d was not held by the session", key))
+			}
+			if pinned {
+				if errClose := conn.CloseConn(); errClose != nil && err == nil {
+					err = errClose
+				}
+			}
+		})
+		return err
+	}
+}
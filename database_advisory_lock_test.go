@@ -0,0 +1,85 @@
+package pg
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dhui/dktest"
+)
+
+func TestTryAdvisoryLock(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	log.result("SELECT pg_try_advisory_lock($1)", []string{"locked"}, []driver.Value{true})
+	log.result("SELECT pg_advisory_unlock($1)", []string{"unlocked"}, []driver.Value{true})
+
+	acquired, release, err := db.TryAdvisoryLock(42)
+	if err != nil || !acquired {
+		t.Fatalf("TryAdvisoryLock() = %v, %v", acquired, err)
+	}
+	if err = release(); err != nil {
+		t.Errorf("release() = %v", err)
+	}
+	if err = release(); err != nil {
+		t.Errorf("second release() = %v", err)
+	}
+	if want := "SELECT pg_try_advisory_lock($1);SELECT pg_advisory_unlock($1)"; log.String() != want {
+		t.Errorf("got  %s\nwant %s", log.String(), want)
+	}
+
+	log.result("SELECT pg_try_advisory_lock($1)", []string{"locked"}, []driver.Value{false})
+	if acquired, release, err = db.TryAdvisoryLock(42); err != nil || acquired || release != nil {
+		t.Errorf("expected lock held by another session, got %v, %v", acquired, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = db.AdvisoryLock(ctx, 42); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context error, got %v", err)
+	}
+}
+
+func TestAdvisoryLock(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		acquired, release, err := db.TryAdvisoryLock(967)
+		if err != nil || !acquired {
+			t.Fatalf("TryAdvisoryLock() = %v, %v", acquired, err)
+		}
+
+		// another session
+		if acquired, _, err = db.TryAdvisoryLock(967); err != nil || acquired {
+			t.Errorf("expected second attempt to fail, got %v, %v", acquired, err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		if _, err = db.AdvisoryLock(ctx, 967); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context error, got %v", err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			releaseWaiting, err := db.AdvisoryLock(context.Background(), 967)
+			if err == nil {
+				err = releaseWaiting()
+			}
+			done <- err
+		}()
+		time.Sleep(200 * time.Millisecond)
+		if err = release(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err = <-done:
+			if err != nil {
+				t.Errorf("AdvisoryLock() = %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("expected the lock to be acquired after the release")
+		}
+	})
+}
@@ -0,0 +1,26 @@
+package pg
+
+import (
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/lib/pq"
+)
+
+// QueryAnyArray executes a query binding arrayArg as a single PostgreSQL array in $1, the other args follow from $2.
+//
+// Use with "WHERE id = ANY($1)" to filter by large sets of values, instead of expanding one placeholder per value,
+// which hits the limit of 65535 parameters and creates a different plan for every size of the set.
+func (d *Database) QueryAnyArray(query string, arrayArg []interface{}, args ...interface{}) (*sql.Rows, error) {
+	return d.Query(query, append([]interface{}{pq.Array(arrayArg)}, args...)...)
+}
+
+// AnyInt64 binds the ids as a bigint[] parameter, to be used with "= ANY($n)"
+func AnyInt64(values []int64) driver.Valuer {
+	return pq.Int64Array(values)
+}
+
+// AnyString binds the values as a text[] parameter, to be used with "= ANY($n)"
+func AnyString(values []string) driver.Valuer {
+	return pq.StringArray(values)
+}
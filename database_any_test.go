@@ -0,0 +1,71 @@
+package pg
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+func TestAnyArray(t *testing.T) {
+	tests := []struct {
+		value driver.Valuer
+		want  string
+	}{
+		{AnyInt64([]int64{1, 2, 3}), "{1,2,3}"},
+		{AnyString([]string{"a", "b,c"}), `{"a","b,c"}`},
+		{AnyInt64([]int64{}), "{}"},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.value.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestQueryAnyArray(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		ids := make([]interface{}, 70000)
+		for i := range ids {
+			ids[i] = i
+		}
+
+		rows, err := db.QueryAnyArray(
+			"SELECT s FROM generate_series(1, 10) s WHERE s = ANY($1) AND s > $2 ORDER BY s", ids, 7,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+
+		var got []int
+		for rows.Next() {
+			var s int
+			if err = rows.Scan(&s); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, s)
+		}
+		if !reflect.DeepEqual(got, []int{8, 9, 10}) {
+			t.Errorf("unexpected rows %v", got)
+		}
+
+		var count int
+		err = db.QueryRowOld("SELECT count(*) FROM generate_series(1, 10) s WHERE s = ANY($1)", AnyInt64([]int64{1, 2})).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Errorf("expected 2 rows, got %d", count)
+		}
+	})
+}
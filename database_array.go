@@ -0,0 +1,27 @@
+package pg
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// ScanArray scans a PostgreSQL array column into the slice, e.g. rows.Scan(ScanArray(&tags)).
+//
+// []string, []int64, []float64 and []bool are scanned by the typed arrays of the driver, other element types must
+// implement sql.Scanner (see pq.GenericArray). A NULL array sets the slice to nil and an empty array to an empty
+// non-nil slice.
+func ScanArray[T any](dest *[]T) sql.Scanner {
+	switch d := any(dest).(type) {
+	case *[]string:
+		return (*pq.StringArray)(d)
+	case *[]int64:
+		return (*pq.Int64Array)(d)
+	case *[]float64:
+		return (*pq.Float64Array)(d)
+	case *[]bool:
+		return (*pq.BoolArray)(d)
+	default:
+		return pq.GenericArray{A: dest}
+	}
+}
@@ -0,0 +1,66 @@
+package pg
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+func TestScanArray(t *testing.T) {
+	var tags []string
+	if err := ScanArray(&tags).Scan([]byte(`{a,"b c"}`)); err != nil || !reflect.DeepEqual(tags, []string{"a", "b c"}) {
+		t.Errorf("Scan(text[]) = %#v, %v", tags, err)
+	}
+	if err := ScanArray(&tags).Scan(nil); err != nil || tags != nil {
+		t.Errorf("Scan(NULL) = %#v, %v", tags, err)
+	}
+	if err := ScanArray(&tags).Scan([]byte(`{}`)); err != nil || tags == nil || len(tags) != 0 {
+		t.Errorf("Scan({}) = %#v, %v", tags, err)
+	}
+
+	var ids []int64
+	if err := ScanArray(&ids).Scan([]byte(`{1,2}`)); err != nil || !reflect.DeepEqual(ids, []int64{1, 2}) {
+		t.Errorf("Scan(int8[]) = %#v, %v", ids, err)
+	}
+	var scores []float64
+	if err := ScanArray(&scores).Scan([]byte(`{1.5,2}`)); err != nil || !reflect.DeepEqual(scores, []float64{1.5, 2}) {
+		t.Errorf("Scan(float8[]) = %#v, %v", scores, err)
+	}
+	var flags []bool
+	if err := ScanArray(&flags).Scan([]byte(`{t,f}`)); err != nil || !reflect.DeepEqual(flags, []bool{true, false}) {
+		t.Errorf("Scan(bool[]) = %#v, %v", flags, err)
+	}
+	var names []sql.NullString
+	want := []sql.NullString{{String: "a", Valid: true}, {}}
+	if err := ScanArray(&names).Scan([]byte(`{a,NULL}`)); err != nil || !reflect.DeepEqual(names, want) {
+		t.Errorf("Scan(text[]) = %#v, %v", names, err)
+	}
+}
+
+func TestScanArrayRoundTrip(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		var tags, nullTags, emptyTags []string
+		var ids []int64
+		var scores []float64
+		var flags []bool
+		err := db.QueryRowOld("SELECT $1::text[], $2::bigint[], $3::float8[], ARRAY[true, false], NULL::text[], '{}'::text[]",
+			AnyString([]string{"a", "b,c", `"quoted"`}), AnyInt64([]int64{1, 2}), bindValue([]float64{0.5}),
+		).Scan(ScanArray(&tags), ScanArray(&ids), ScanArray(&scores), ScanArray(&flags), ScanArray(&nullTags), ScanArray(&emptyTags))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(tags, []string{"a", "b,c", `"quoted"`}) || !reflect.DeepEqual(ids, []int64{1, 2}) ||
+			!reflect.DeepEqual(scores, []float64{0.5}) || !reflect.DeepEqual(flags, []bool{true, false}) {
+			t.Errorf("unexpected values %v %v %v %v", tags, ids, scores, flags)
+		}
+		if nullTags != nil || emptyTags == nil || len(emptyTags) != 0 {
+			t.Errorf("unexpected NULL/empty arrays %#v %#v", nullTags, emptyTags)
+		}
+	})
+}
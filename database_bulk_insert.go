@@ -0,0 +1,157 @@
+package pg
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// BulkInsertConfig options of BulkInsert
+type BulkInsertConfig struct {
+	// RowByRowOnFailure when the COPY fails, inserts the rows one by one instead of failing the whole batch, each row
+	// in its own savepoint. The failed rows are reported in BatchResult.Failures.
+	RowByRowOnFailure bool
+}
+
+// BatchResult the result of a BulkInsert
+type BatchResult struct {
+	Inserted int64      // number of rows inserted
+	Failures []RowError // rows not inserted, only in BulkInsertConfig.RowByRowOnFailure mode
+}
+
+// RowError the error of a single row of a batch
+type RowError struct {
+	Row int   // index of the row in the batch (starting at 0)
+	Err error // the error returned by the database
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Err.Error())
+}
+
+func (e RowError) Unwrap() error {
+	return e.Err
+}
+
+// BulkInsert inserts the rows with COPY FROM STDIN, in a transaction (or in the current one). Each row has one value
+// per column, in the same order.
+//
+// By default the insert is all-or-nothing, as PostgreSQL aborts the whole COPY on the first invalid row without
+// telling which one. With BulkInsertConfig.RowByRowOnFailure, a failed COPY is retried inserting the rows one by one,
+// the valid rows are inserted and each invalid row is reported with its index, e.g. for data import tools that must
+// report the bad records.
+func (d *Database) BulkInsert(
+	schema, table string, columns []string, rows [][]interface{}, config *BulkInsertConfig,
+) (*BatchResult, error) {
+	rowByRow := config != nil && config.RowByRowOnFailure
+	result := &BatchResult{}
+
+	run := func(db *Database) error {
+		err := db.inSavepoint("pg_bulk_insert", func() error {
+			return db.copyIn(schema, table, columns, rows)
+		})
+		if err == nil {
+			result.Inserted = int64(len(rows))
+			return nil
+		}
+		if !rowByRow {
+			return err
+		}
+
+		var params []string
+		for i := range columns {
+			params = append(params, "$"+strconv.Itoa(i+1))
+		}
+		var quoted []string
+		for _, column := range columns {
+			quoted = append(quoted, QuoteIdentifier(column))
+		}
+		query := "INSERT INTO " + QuoteIdentifier(schema) + "." + QuoteIdentifier(table) +
+			" (" + strings.Join(quoted, ", ") + ") VALUES (" + strings.Join(params, ", ") + ")"
+
+		for i, row := range rows {
+			errRow := checkRowLen(row, columns)
+			if errRow == nil {
+				errRow = db.inSavepoint("pg_bulk_insert_row", func() error {
+					_, err := db.Execute(query, bindValues(row)...)
+					return err
+				})
+			}
+			if errRow != nil {
+				result.Failures = append(result.Failures, RowError{Row: i, Err: errRow})
+			} else {
+				result.Inserted++
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if d.tx != nil {
+		err = run(d)
+	} else {
+		err = d.Transaction(run)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// copyIn runs the COPY FROM STDIN of the rows, it must be called in a transaction
+func (d *Database) copyIn(schema, table string, columns []string, rows [][]interface{}) error {
+	for i, row := range rows {
+		if err := checkRowLen(row, columns); err != nil {
+			return RowError{Row: i, Err: err}
+		}
+	}
+
+	stmt, err := d.Prepare(pq.CopyInSchema(schema, table, columns...))
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if _, err = stmt.Exec(bindValues(row)...); err != nil {
+			return errors.Join(err, stmt.Close())
+		}
+	}
+	// flush
+	if _, err = stmt.Exec(); err != nil {
+		return errors.Join(err, stmt.Close())
+	}
+	return stmt.Close()
+}
+
+// inSavepoint executes the callback in a savepoint of the current transaction, rolled back to on error and released
+// on success
+func (d *Database) inSavepoint(savepoint string, callback func() error) error {
+	if _, err := d.Execute("SAVEPOINT " + savepoint); err != nil {
+		return err
+	}
+	if err := callback(); err != nil {
+		if _, errRollback := d.Execute("ROLLBACK TO SAVEPOINT " + savepoint); errRollback != nil {
+			return errors.Join(errRollback, err)
+		}
+		return err
+	}
+	_, err := d.Execute("RELEASE SAVEPOINT " + savepoint)
+	return err
+}
+
+func checkRowLen(row []interface{}, columns []string) error {
+	if len(row) != len(columns) {
+		return errors.New(fmt.Sprintf("row has %d values, expected %d", len(row), len(columns)))
+	}
+	return nil
+}
+
+func bindValues(row []interface{}) []interface{} {
+	values := make([]interface{}, len(row))
+	for i, value := range row {
+		values[i] = bindValue(value)
+	}
+	return values
+}
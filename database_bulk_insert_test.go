@@ -0,0 +1,78 @@
+package pg
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+func TestRowError(t *testing.T) {
+	cause := errors.New("invalid input")
+	err := error(RowError{Row: 3, Err: cause})
+	if err.Error() != "row 3: invalid input" || !errors.Is(err, cause) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestBulkInsert(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		_, err := db.Execute("CREATE TABLE bulk_insert (id INT PRIMARY KEY, name TEXT NOT NULL, tags TEXT[])")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		columns := []string{"id", "name", "tags"}
+		valid := [][]interface{}{
+			{1, "alice", []string{"a", "b"}},
+			{2, "bob", nil},
+		}
+		result, err := db.BulkInsert("public", "bulk_insert", columns, valid, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Inserted != 2 || len(result.Failures) != 0 {
+			t.Errorf("unexpected result %+v", result)
+		}
+
+		invalid := [][]interface{}{
+			{3, "carol", nil},
+			{1, "duplicated", nil},
+			{4, nil, nil},
+			{5, "missing tags"},
+			{6, "dave", []string{"d"}},
+		}
+
+		// all-or-nothing
+		if _, err = db.BulkInsert("public", "bulk_insert", columns, invalid, nil); err == nil {
+			t.Fatal("expected error")
+		}
+		if count, _ := db.QueryForInt("SELECT count(*) FROM bulk_insert"); count != 2 {
+			t.Errorf("expected no row inserted, got %d rows", count)
+		}
+
+		result, err = db.BulkInsert("public", "bulk_insert", columns, invalid, &BulkInsertConfig{RowByRowOnFailure: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Inserted != 2 || len(result.Failures) != 3 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+		for i, row := range []int{1, 2, 3} {
+			if result.Failures[i].Row != row {
+				t.Errorf("expected failure of row %d, got %v", row, result.Failures[i])
+			}
+		}
+
+		names, err := QueryColumn[string](db, "SELECT name FROM bulk_insert ORDER BY id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 4 || names[2] != "carol" || names[3] != "dave" {
+			t.Errorf("unexpected rows %v", names)
+		}
+	})
+}
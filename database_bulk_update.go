@@ -0,0 +1,140 @@
+package pg
+
+import (
+	"strconv"
+	"strings"
+)
+
+// bulkUpdateMaxParams the maximum number of bind parameters of a single statement in the PostgreSQL protocol
+const bulkUpdateMaxParams = 65535
+
+// UpdateSpec the values and condition of a single row of a BulkUpdate
+type UpdateSpec struct {
+	Values    map[string]interface{}
+	Condition map[string]interface{}
+}
+
+// BulkUpdate applies all updates in a single transaction (or in the current one).
+//
+// When all specs have the same Values and Condition columns, the updates are compiled into a single statement
+// `UPDATE ... FROM (VALUES ...) AS v(...) WHERE t.key = v.key`, split only when exceeding the bind parameter limit.
+// Otherwise (different shapes, empty condition or a column used in both Values and Condition), each spec is executed
+// as an individual Update.
+//
+// Like Update, a spec that does not match any row is not an error. Conditions with NULL values never match.
+func (d *Database) BulkUpdate(schema, table string, updates []UpdateSpec) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	run := func(db *Database) error {
+		if !sameUpdateShape(updates) {
+			for _, update := range updates {
+				if _, err := db.Update(schema, table, update.Values, update.Condition); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		columns := len(updates[0].Values) + len(updates[0].Condition)
+		batchSize := bulkUpdateMaxParams / columns
+		for start := 0; start < len(updates); start += batchSize {
+			end := start + batchSize
+			if end > len(updates) {
+				end = len(updates)
+			}
+			query, args := db.buildBulkUpdate(schema, table, updates[start:end])
+			if _, err := db.Execute(query, args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if d.tx != nil {
+		return run(d)
+	}
+	return d.Transaction(run)
+}
+
+// sameUpdateShape checks if all specs can be compiled into a single UPDATE ... FROM (VALUES ...)
+func sameUpdateShape(updates []UpdateSpec) bool {
+	first := updates[0]
+	if len(first.Values) == 0 || len(first.Condition) == 0 {
+		return false
+	}
+	for key := range first.Condition {
+		if _, exists := first.Values[key]; exists {
+			return false
+		}
+	}
+	for _, update := range updates[1:] {
+		if len(update.Values) != len(first.Values) || len(update.Condition) != len(first.Condition) {
+			return false
+		}
+		for key := range update.Values {
+			if _, exists := first.Values[key]; !exists {
+				return false
+			}
+		}
+		for key := range update.Condition {
+			if _, exists := first.Condition[key]; !exists {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// buildBulkUpdate returns the SQL and args of a single UPDATE ... FROM (VALUES ...) for specs of the same shape.
+//
+// The bind parameters of a VALUES list have no type, so the first row is a typed NULL of each column (taken from the
+// table row type) from which PostgreSQL infers the types of the other rows. This row never matches the condition.
+func (d *Database) buildBulkUpdate(schema, table string, updates []UpdateSpec) (string, []interface{}) {
+	valueKeys := sortedKeys(updates[0].Values)
+	conditionKeys := sortedKeys(updates[0].Condition)
+	columns := append(append([]string{}, valueKeys...), conditionKeys...)
+
+	tableName := QuoteIdentifier(schema) + "." + QuoteIdentifier(table)
+
+	var quoted, typed []string
+	for _, column := range columns {
+		quoted = append(quoted, QuoteIdentifier(column))
+		typed = append(typed, "(NULL::"+tableName+")."+QuoteIdentifier(column))
+	}
+
+	var args []interface{}
+	rows := []string{"(" + strings.Join(typed, ", ") + ")"}
+	for _, update := range updates {
+		var params []string
+		for i, column := range columns {
+			if i < len(valueKeys) {
+				args = append(args, bindValue(update.Values[column]))
+			} else {
+				args = append(args, bindValue(update.Condition[column]))
+			}
+			params = append(params, "$"+strconv.Itoa(len(args)))
+		}
+		rows = append(rows, "("+strings.Join(params, ", ")+")")
+	}
+
+	var set []string
+	for _, column := range valueKeys {
+		set = append(set, QuoteIdentifier(column)+" = v."+QuoteIdentifier(column))
+	}
+	for _, column := range d.missingTimestamps(updates[0].Values, false) {
+		set = append(set, QuoteIdentifier(column)+" = now()")
+	}
+
+	var where []string
+	for _, column := range conditionKeys {
+		where = append(where, "t."+QuoteIdentifier(column)+" = v."+QuoteIdentifier(column))
+	}
+
+	query := "UPDATE " + tableName + " AS t SET " + strings.Join(set, ", ") +
+		" FROM (VALUES " + strings.Join(rows, ", ") + ") AS v (" + strings.Join(quoted, ", ") + ")" +
+		" WHERE " + strings.Join(where, " AND ")
+
+	return query, args
+}
@@ -0,0 +1,131 @@
+package pg
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+func Test_buildBulkUpdate(t *testing.T) {
+	db := &Database{}
+	query, args := db.buildBulkUpdate("public", "users", []UpdateSpec{
+		{Values: map[string]interface{}{"name": "alice", "age": 30}, Condition: map[string]interface{}{"id": 1}},
+		{Values: map[string]interface{}{"name": "bob", "age": 40}, Condition: map[string]interface{}{"id": 2}},
+	})
+
+	want := `UPDATE "public"."users" AS t SET "age" = v."age", "name" = v."name" FROM (VALUES ` +
+		`((NULL::"public"."users")."age", (NULL::"public"."users")."name", (NULL::"public"."users")."id"), ` +
+		`($1, $2, $3), ($4, $5, $6)) AS v ("age", "name", "id") WHERE t."id" = v."id"`
+	if query != want {
+		t.Errorf("got  %s\nwant %s", query, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{30, "alice", 1, 40, "bob", 2}) {
+		t.Errorf("unexpected args %v", args)
+	}
+}
+
+func TestBulkUpdateFallback(t *testing.T) {
+	db, log := openFakeDatabase(t)
+
+	err := db.BulkUpdate("public", "users", []UpdateSpec{
+		{Values: map[string]interface{}{"name": "alice"}, Condition: map[string]interface{}{"id": 1}},
+		{Values: map[string]interface{}{"age": 40}, Condition: map[string]interface{}{"id": 2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `BEGIN;UPDATE "public"."users" SET "name" = $1 WHERE "id" = $2;` +
+		`UPDATE "public"."users" SET "age" = $1 WHERE "id" = $2;COMMIT`
+	if got := log.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestBulkUpdate(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		_, err := db.Execute(`CREATE TABLE bulk_update (id INT PRIMARY KEY, name TEXT, tags TEXT[], updated_at TIMESTAMPTZ);
+			INSERT INTO bulk_update (id, name) SELECT s, 'user ' || s FROM generate_series(1, 3) s`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = db.WithTimestamps().BulkUpdate("public", "bulk_update", []UpdateSpec{
+			{Values: map[string]interface{}{"name": "alice", "tags": []string{"a"}}, Condition: map[string]interface{}{"id": 1}},
+			{Values: map[string]interface{}{"name": "bob", "tags": nil}, Condition: map[string]interface{}{"id": 2}},
+			{Values: map[string]interface{}{"name": "nobody", "tags": nil}, Condition: map[string]interface{}{"id": 99}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := db.QueryMaps("SELECT id, name, updated_at IS NOT NULL AS touched FROM bulk_update ORDER BY id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []map[string]interface{}{
+			{"id": int64(1), "name": "alice", "touched": true},
+			{"id": int64(2), "name": "bob", "touched": true},
+			{"id": int64(3), "name": "user 3", "touched": false},
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Errorf("got %v, want %v", rows, want)
+		}
+	})
+}
+
+func BenchmarkBulkUpdate(b *testing.B) {
+	config, err := ConfigFromEnv("PG_BENCH")
+	if err != nil || config.Host == "" {
+		b.Skip("PG_BENCH_HOST not set")
+	}
+	db, err := Open(config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Execute(`CREATE TABLE bulk_update_bench (id INT PRIMARY KEY, name TEXT);
+		INSERT INTO bulk_update_bench SELECT s, '' FROM generate_series(1, 1000) s`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Execute("DROP TABLE bulk_update_bench")
+
+	var updates []UpdateSpec
+	for i := 1; i <= 1000; i++ {
+		updates = append(updates, UpdateSpec{
+			Values:    map[string]interface{}{"name": "user " + strconv.Itoa(i)},
+			Condition: map[string]interface{}{"id": i},
+		})
+	}
+
+	b.Run("single statement", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err = db.BulkUpdate("public", "bulk_update_bench", updates); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("per row", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			err = db.Transaction(func(tx *Database) error {
+				for _, update := range updates {
+					if _, err := tx.Update("public", "bulk_update_bench", update.Values, update.Condition); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package pg
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// BulkUpsertStruct upserts the rows, a slice of structs (or pointers to structs) of the same type, with multi-row
+// INSERT INTO ... ON CONFLICT (conflictCols) DO UPDATE SET col = excluded.col statements, in a single transaction (or
+// in the current one). The rows are split in statements respecting the bind parameter limit.
+//
+// The columns are mapped from the fields of the struct, see Table (`pg:"name"` tag or the snake_case field name).
+// When all columns are conflict columns, the existing rows are left unchanged (DO NOTHING). The same conflict key
+// can not appear twice in rows, PostgreSQL does not update a row twice in the same statement. An empty slice is a
+// no-op.
+func (d *Database) BulkUpsertStruct(schema, table string, rows interface{}, conflictCols []string) error {
+	value := reflect.ValueOf(rows)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return fmt.Errorf("%w: %T, expected a slice of structs", ErrUnsupportedDataType, rows)
+	}
+	if value.Len() == 0 {
+		return nil
+	}
+	if len(conflictCols) == 0 {
+		return errors.New("BulkUpsertStruct requires the conflict columns")
+	}
+
+	// the elements of []interface{} may have different types
+	var structType reflect.Type
+	elements := make([]reflect.Value, value.Len())
+	for i := range elements {
+		element := value.Index(i)
+		for element.Kind() == reflect.Interface || element.Kind() == reflect.Ptr {
+			if element.IsNil() {
+				return errors.New(fmt.Sprintf("row %d of BulkUpsertStruct is nil", i))
+			}
+			element = element.Elem()
+		}
+		if element.Kind() != reflect.Struct {
+			return fmt.Errorf("%w: row %d is %s, expected a struct", ErrUnsupportedDataType, i, element.Type().String())
+		}
+		if structType == nil {
+			structType = element.Type()
+		} else if element.Type() != structType {
+			return errors.New(fmt.Sprintf(
+				"all rows of BulkUpsertStruct must have the same type, row %d is %s, expected %s",
+				i, element.Type().String(), structType.String(),
+			))
+		}
+		elements[i] = element
+	}
+
+	columns := structColumns(structType)
+	var names []string
+	mapped := map[string]bool{}
+	for _, column := range columns {
+		names = append(names, column.name)
+		mapped[column.name] = true
+	}
+	for _, column := range conflictCols {
+		if !mapped[column] {
+			return errors.New(fmt.Sprintf("conflict column %s is not mapped to a field of %s", column, structType.String()))
+		}
+	}
+
+	values := make([][]interface{}, len(elements))
+	for i, element := range elements {
+		for _, column := range columns {
+			values[i] = append(values[i], bindValue(element.FieldByIndex(column.index).Interface()))
+		}
+	}
+
+	run := func(db *Database) error {
+		batchSize := bulkUpdateMaxParams / len(columns)
+		for start := 0; start < len(values); start += batchSize {
+			end := start + batchSize
+			if end > len(values) {
+				end = len(values)
+			}
+			query, args := buildBulkUpsert(schema, table, names, conflictCols, values[start:end])
+			if _, err := db.Execute(query, args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if d.tx != nil {
+		return run(d)
+	}
+	return d.Transaction(run)
+}
+
+// buildBulkUpsert returns the SQL and args of a multi-row INSERT INTO ... ON CONFLICT DO UPDATE
+func buildBulkUpsert(schema, table string, columns, conflictCols []string, values [][]interface{}) (string, []interface{}) {
+	isConflictCol := map[string]bool{}
+	var conflict []string
+	for _, column := range conflictCols {
+		isConflictCol[column] = true
+		conflict = append(conflict, QuoteIdentifier(column))
+	}
+
+	var quoted, updates []string
+	for _, column := range columns {
+		quoted = append(quoted, QuoteIdentifier(column))
+		if !isConflictCol[column] {
+			updates = append(updates, QuoteIdentifier(column)+" = excluded."+QuoteIdentifier(column))
+		}
+	}
+
+	var args []interface{}
+	var rows []string
+	for _, row := range values {
+		var params []string
+		for _, value := range row {
+			args = append(args, value)
+			params = append(params, "$"+strconv.Itoa(len(args)))
+		}
+		rows = append(rows, "("+strings.Join(params, ", ")+")")
+	}
+
+	query := "INSERT INTO " + QuoteIdentifier(schema) + "." + QuoteIdentifier(table) +
+		" (" + strings.Join(quoted, ", ") + ") VALUES " + strings.Join(rows, ", ") +
+		" ON CONFLICT (" + strings.Join(conflict, ", ") + ")"
+	if len(updates) == 0 {
+		return query + " DO NOTHING", args
+	}
+	return query + " DO UPDATE SET " + strings.Join(updates, ", "), args
+}
@@ -0,0 +1,106 @@
+package pg
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+type bulkUpsertProduct struct {
+	Sku   string `pg:"sku"`
+	Name  string
+	Price int64
+	Tags  []string
+	Notes string `pg:"-"`
+}
+
+func TestBulkUpsertStructStatements(t *testing.T) {
+	db, log := openFakeDatabase(t)
+
+	err := db.BulkUpsertStruct("public", "products", []*bulkUpsertProduct{
+		{Sku: "a", Name: "Apple", Price: 10},
+		{Sku: "b", Name: "Banana", Price: 5},
+	}, []string{"sku"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `BEGIN;INSERT INTO "public"."products" ("sku", "name", "price", "tags") VALUES ($1, $2, $3, $4), ($5, $6, $7, $8)` +
+		` ON CONFLICT ("sku") DO UPDATE SET "name" = excluded."name", "price" = excluded."price", "tags" = excluded."tags";COMMIT`
+	if got := log.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestBulkUpsertStructInvalid(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	conflict := []string{"sku"}
+
+	if err := db.BulkUpsertStruct("public", "products", []bulkUpsertProduct{}, conflict); err != nil {
+		t.Errorf("expected empty input to be a no-op, got %v", err)
+	}
+	if err := db.BulkUpsertStruct("public", "products", bulkUpsertProduct{}, conflict); !errors.Is(err, ErrUnsupportedDataType) {
+		t.Errorf("expected ErrUnsupportedDataType, got %v", err)
+	}
+	mixed := []interface{}{bulkUpsertProduct{Sku: "a"}, &structMember{}}
+	if err := db.BulkUpsertStruct("public", "products", mixed, conflict); err == nil || !strings.Contains(err.Error(), "same type") {
+		t.Errorf("expected type mismatch error, got %v", err)
+	}
+	if err := db.BulkUpsertStruct("public", "products", []*bulkUpsertProduct{nil}, conflict); err == nil {
+		t.Error("expected error for a nil row")
+	}
+	if err := db.BulkUpsertStruct("public", "products", []bulkUpsertProduct{{}}, []string{"id"}); err == nil {
+		t.Error("expected error for an unmapped conflict column")
+	}
+	if got := log.String(); got != "" {
+		t.Errorf("unexpected statements %s", got)
+	}
+}
+
+func Test_buildBulkUpsertDoNothing(t *testing.T) {
+	query, args := buildBulkUpsert("public", "tags", []string{"name"}, []string{"name"}, [][]interface{}{{"a"}, {"b"}})
+	want := `INSERT INTO "public"."tags" ("name") VALUES ($1), ($2) ON CONFLICT ("name") DO NOTHING`
+	if query != want {
+		t.Errorf("got  %s\nwant %s", query, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"a", "b"}) {
+		t.Errorf("unexpected args %v", args)
+	}
+}
+
+func TestBulkUpsertStruct(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		_, err := db.Execute(`CREATE TABLE products (sku TEXT PRIMARY KEY, name TEXT, price BIGINT, tags TEXT[]);
+			INSERT INTO products VALUES ('a', 'Apple', 10, NULL), ('b', 'Banana', 5, NULL)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = db.BulkUpsertStruct("public", "products", []bulkUpsertProduct{
+			{Sku: "a", Name: "Apple", Price: 12, Tags: []string{"fruit"}},
+			{Sku: "c", Name: "Cherry", Price: 30},
+		}, []string{"sku"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := db.QueryMaps("SELECT sku, name, price FROM products ORDER BY sku")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []map[string]interface{}{
+			{"sku": "a", "name": "Apple", "price": int64(12)},
+			{"sku": "b", "name": "Banana", "price": int64(5)},
+			{"sku": "c", "name": "Cherry", "price": int64(30)},
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Errorf("got %v, want %v", rows, want)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package pg
+
+// QueryColumn executes a query and scans the first column of every row into a slice, like
+// QueryColumn[string](db, "SELECT id FROM users WHERE active").
+//
+// When the query returns no rows, an empty (non-nil) slice is returned.
+func QueryColumn[T any](db *Database, query string, args ...interface{}) ([]T, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	// extra columns are discarded
+	dest := make([]interface{}, len(columns))
+	for i := 1; i < len(columns); i++ {
+		dest[i] = new(interface{})
+	}
+
+	result := []T{}
+	for rows.Next() {
+		var value T
+		dest[0] = &value
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
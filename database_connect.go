@@ -0,0 +1,76 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+)
+
+// onConnectConnector invokes Config.OnConnect once on each new connection of the pool
+type onConnectConnector struct {
+	driver.Connector
+	config *Config
+}
+
+func (c *onConnectConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// a pool of this single connection, so that the callback can use the Database API
+	single := sql.OpenDB(&singleConnector{conn: &initConn{Conn: conn}, driver: c.Driver()})
+	single.SetMaxOpenConns(1)
+	defer single.Close()
+
+	db := &Database{
+		db:      single,
+		logger:  c.config.Logger,
+		config:  c.config,
+		pool:    &poolState{},
+		metrics: &metricCounters{},
+	}
+	if err = c.config.OnConnect(ctx, db); err != nil {
+		_ = conn.Close()
+		return nil, errors.New("unable to initialize connection (cause: " + err.Error() + ")")
+	}
+	return conn, nil
+}
+
+// singleConnector a connector that always returns the same connection
+type singleConnector struct {
+	conn   driver.Conn
+	driver driver.Driver
+}
+
+func (c *singleConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.conn, nil
+}
+
+func (c *singleConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// initConn a connection being initialized by Config.OnConnect, it is not closed by the temporary pool
+type initConn struct {
+	driver.Conn
+}
+
+func (c *initConn) Close() error {
+	return nil
+}
+
+func (c *initConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if execer, isExecer := c.Conn.(driver.ExecerContext); isExecer {
+		return execer.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *initConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if queryer, isQueryer := c.Conn.(driver.QueryerContext); isQueryer {
+		return queryer.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
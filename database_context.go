@@ -0,0 +1,23 @@
+package pg
+
+import "context"
+
+type databaseContextKey struct{}
+
+// WithContext returns a copy of ctx carrying this Database.
+//
+// Inside a Transaction callback, helpers that receive the context can pick up the transaction-bound Database with
+// FromContext (see Table.UsingContext and Query.WithContext), instead of resolving one through GetInstance and
+// running outside the transaction.
+func (d *Database) WithContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, databaseContextKey{}, d)
+}
+
+// FromContext returns the Database carried by ctx (see Database.WithContext)
+func FromContext(ctx context.Context) (*Database, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	db, ok := ctx.Value(databaseContextKey{}).(*Database)
+	return db, ok && db != nil
+}
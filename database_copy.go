@@ -0,0 +1,126 @@
+package pg
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	CopyFormatText = "text" // tab delimited, \N for NULL (COPY default)
+	CopyFormatCsv  = "csv"  // comma delimited, NULL as an unquoted empty value
+)
+
+// CopyTo exports the result of the query to the writer, in the COPY text or csv format, returning the number of rows
+// written.
+//
+// lib/pq does not support COPY TO STDOUT, so the rows are streamed through a regular query and encoded on the client
+// side, in a format accepted by COPY FROM. Values are written in their textual representation (timestamps as RFC3339,
+// bytea in the hex format "\x..."). If writing to w fails, the error is returned with a count of 0, as the rows may
+// not have reached the writer.
+func (d *Database) CopyTo(w io.Writer, query string, format string) (int64, error) {
+	var encode func(value []byte, isNull bool) string
+	delimiter := ""
+	switch strings.ToLower(format) {
+	case "", CopyFormatText:
+		encode, delimiter = copyEncodeText, "\t"
+	case CopyFormatCsv:
+		encode, delimiter = copyEncodeCsv, ","
+	default:
+		return 0, errors.New(fmt.Sprintf("unsupported copy format (%s)", format))
+	}
+
+	rows, err := d.Query(query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, err
+	}
+	bytea := make([]bool, len(columns))
+	for i, column := range columns {
+		bytea[i] = column.DatabaseTypeName() == "BYTEA"
+	}
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	buffer := bufio.NewWriter(w)
+	fields := make([]string, len(columns))
+
+	var count int64
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return count, err
+		}
+
+		for i, value := range values {
+			fields[i] = encode(copyValueText(value, bytea[i]), value == nil)
+		}
+
+		if _, err = buffer.WriteString(strings.Join(fields, delimiter) + "\n"); err != nil {
+			return 0, err
+		}
+		count++
+	}
+
+	if err = rows.Err(); err != nil {
+		return count, err
+	}
+
+	if err = buffer.Flush(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// copyValueText returns the textual representation of a value returned by the driver. lib/pq returns the decoded
+// bytes of bytea columns, which are encoded in the hex format.
+func copyValueText(value interface{}, bytea bool) []byte {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		if bytea {
+			return []byte(`\x` + hex.EncodeToString(v))
+		}
+		return v
+	case string:
+		return []byte(v)
+	case time.Time:
+		return []byte(v.Format(time.RFC3339Nano))
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
+
+var copyTextReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+func copyEncodeText(value []byte, isNull bool) string {
+	if isNull {
+		return `\N`
+	}
+	return copyTextReplacer.Replace(string(value))
+}
+
+func copyEncodeCsv(value []byte, isNull bool) string {
+	if isNull {
+		return ""
+	}
+	text := string(value)
+	if text == "" || strings.ContainsAny(text, ",\"\r\n") {
+		// empty strings are quoted to distinguish them from NULL
+		return `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+	}
+	return text
+}
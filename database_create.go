@@ -0,0 +1,19 @@
+package pg
+
+import "errors"
+
+// CreateDatabase creates a new database (CREATE DATABASE), e.g. to provision the database of a tenant, that can then
+// be migrated with MigrationConfig.Database.
+//
+// CREATE DATABASE can not run inside a transaction block, it is executed on its own (auto-commit) on a connection of
+// the current database, as any database of the cluster can issue it. The user needs the CREATEDB privilege. Returns
+// ErrInTransaction when called within a transaction.
+func (d *Database) CreateDatabase(name string) error {
+	if d.tx != nil {
+		return ErrInTransaction
+	}
+	if _, err := d.Execute("CREATE DATABASE " + QuoteIdentifier(name)); err != nil {
+		return errors.New("unable to create database " + name + " (cause: " + err.Error() + ")")
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package pg
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+func TestCreateDatabaseInTransaction(t *testing.T) {
+	db, log := openFakeDatabase(t)
+
+	err := db.Transaction(func(tx *Database) error {
+		return tx.CreateDatabase("tenant_1")
+	})
+	if !errors.Is(err, ErrInTransaction) {
+		t.Errorf("expected ErrInTransaction, got %v", err)
+	}
+
+	if err = db.CreateDatabase("tenant_1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := log.String(); got != `BEGIN;ROLLBACK;CREATE DATABASE "tenant_1"` {
+		t.Errorf("unexpected statements %s", got)
+	}
+}
+
+func TestCreateAndMigrateDatabase(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		if err := db.CreateDatabase("tenant_1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.CreateDatabase("tenant_1"); err == nil {
+			t.Error("expected error for an existing database")
+		}
+
+		err := db.AddMigration("1.0.0", "create orders", func(migration *Migration) {
+			migration.ExecSql("CREATE TABLE orders (id INT)")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = db.Migrate(&MigrationConfig{Database: "tenant_1"}); err != nil {
+			t.Fatal(err)
+		}
+
+		config := *db.config
+		config.Database = "tenant_1"
+		tenantDb, err := Open(&config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tenantDb.Close()
+
+		if exists, err := tenantDb.TableExists("public", "orders"); err != nil || !exists {
+			t.Errorf("expected the table in the new database, got %v, %v", exists, err)
+		}
+		if version, err := tenantDb.QueryForInt("SELECT count(*) FROM pg_schema_history WHERE success"); err != nil || version != 1 {
+			t.Errorf("expected the history in the new database, got %d, %v", version, err)
+		}
+		if exists, err := db.TableExists("public", "orders"); err != nil || exists {
+			t.Errorf("expected the default database to be untouched, got %v, %v", exists, err)
+		}
+	})
+}
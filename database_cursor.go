@@ -0,0 +1,147 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+)
+
+// Cursor iterates over the result of a query using a server-side cursor, fetching batchSize rows at a time.
+//
+// lib/pq reads the whole result of a query into memory, the cursor keeps the memory constant on huge results.
+// Every Cursor must be closed, which closes the server-side cursor and ends the transaction.
+type Cursor struct {
+	ctx       context.Context
+	db        *Database
+	ownsTx    bool // the transaction was started by the cursor, and must be committed on Close
+	name      string
+	batchSize int
+	rows      *sql.Rows
+	count     int // rows read from the current batch
+	done      bool
+	err       error
+}
+
+// Cursor opens a server-side cursor (DECLARE CURSOR) for the query.
+//
+// Cursors only exist inside a transaction, when d is not a transaction a new one is started and committed on Close.
+// Iterate with Next and Scan, like sql.Rows, or by batches with Fetch.
+func (d *Database) Cursor(ctx context.Context, name, query string, batchSize int, args ...interface{}) (*Cursor, error) {
+	if batchSize < 1 {
+		return nil, errors.New("invalid cursor batch size (" + strconv.Itoa(batchSize) + ")")
+	}
+
+	db := d
+	ownsTx := false
+	if d.tx == nil {
+		var err error
+		if db, err = d.BeginTx(ctx, &sql.TxOptions{ReadOnly: d.readOnly}); err != nil {
+			return nil, err
+		}
+		ownsTx = true
+	}
+
+	declare := "DECLARE " + QuoteIdentifier(name) + " NO SCROLL CURSOR FOR " + query
+	db.debugQuery(declare, args...)
+	if _, err := db.tx.ExecContext(ctx, declare, args...); err != nil {
+		if ownsTx {
+			_ = db.Rollback()
+		}
+		return nil, err
+	}
+
+	return &Cursor{
+		ctx:       ctx,
+		db:        db,
+		ownsTx:    ownsTx,
+		name:      name,
+		batchSize: batchSize,
+	}, nil
+}
+
+// Fetch returns the next batch of up to batchSize rows (FETCH). The previous batch is closed, an empty batch means
+// the end of the result.
+func (c *Cursor) Fetch() (*sql.Rows, error) {
+	if c.rows != nil {
+		_ = c.rows.Close()
+		c.rows = nil
+	}
+
+	query := "FETCH " + strconv.Itoa(c.batchSize) + " FROM " + QuoteIdentifier(c.name)
+	c.db.debugQuery(query)
+	rows, err := c.db.tx.QueryContext(c.ctx, query)
+	c.db.metrics.query(err)
+	if err != nil {
+		return nil, err
+	}
+	c.rows = rows
+	c.count = 0
+	return rows, nil
+}
+
+// Next prepares the next row for reading with Scan, fetching a new batch when the current one is exhausted. Returns
+// false at the end of the result or on error, see Err.
+func (c *Cursor) Next() bool {
+	for !c.done && c.err == nil {
+		if c.rows != nil {
+			if c.rows.Next() {
+				c.count++
+				return true
+			}
+			if c.err = c.rows.Err(); c.err != nil {
+				return false
+			}
+			if c.count < c.batchSize {
+				// short batch, there are no more rows
+				c.done = true
+				return false
+			}
+		}
+		if _, c.err = c.Fetch(); c.err != nil {
+			return false
+		}
+	}
+	return false
+}
+
+// Scan copies the columns of the current row into the values pointed at by dest, see sql.Rows.Scan
+func (c *Cursor) Scan(dest ...interface{}) error {
+	if c.rows == nil {
+		return errors.New("sql: Scan called without calling Next")
+	}
+	return c.rows.Scan(dest...)
+}
+
+// Err returns the error, if any, that was encountered during iteration
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// Close closes the server-side cursor and commits the transaction started by Cursor. When the iteration failed, the
+// transaction is rolled back.
+func (c *Cursor) Close() error {
+	if c.db == nil {
+		return nil
+	}
+	db := c.db
+	c.db = nil
+
+	if c.rows != nil {
+		_ = c.rows.Close()
+		c.rows = nil
+	}
+
+	query := "CLOSE " + QuoteIdentifier(c.name)
+	db.debugQuery(query)
+	_, err := db.tx.ExecContext(c.ctx, query)
+
+	if !c.ownsTx {
+		return err
+	}
+	if err != nil || c.err != nil {
+		_ = db.Rollback()
+		return err
+	}
+	return db.Commit()
+}
@@ -0,0 +1,80 @@
+package pg
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+func TestCursorStatements(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	log.result(`FETCH 3 FROM "export"`, []string{"id"}, []driver.Value{int64(1)}, []driver.Value{int64(2)})
+
+	cursor, err := db.Cursor(context.Background(), "export", "SELECT id FROM users WHERE active = $1", 3, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []int64
+	for cursor.Next() {
+		var id int64
+		if err = cursor.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err = cursor.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err = cursor.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 2 {
+		t.Errorf("unexpected ids %v", ids)
+	}
+
+	want := `BEGIN;DECLARE "export" NO SCROLL CURSOR FOR SELECT id FROM users WHERE active = $1;` +
+		`FETCH 3 FROM "export";CLOSE "export";COMMIT`
+	if got := log.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	if _, err = db.Cursor(context.Background(), "export", "SELECT 1", 0); err == nil {
+		t.Error("expected error for invalid batch size")
+	}
+}
+
+func TestCursor(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		cursor, err := db.Cursor(context.Background(), "series", "SELECT s FROM generate_series(1, $1) s", 10, 25)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var sum, count int
+		for cursor.Next() {
+			var s int
+			if err = cursor.Scan(&s); err != nil {
+				t.Fatal(err)
+			}
+			sum += s
+			count++
+		}
+		if err = cursor.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if err = cursor.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if count != 25 || sum != 325 {
+			t.Errorf("unexpected count %d and sum %d", count, sum)
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package pg
+
+import (
+	"errors"
+	"strconv"
+)
+
+// DeleteInBatches deletes the rows matching the condition in batches of at most batchSize rows, each batch in its own
+// transaction, and returns the total of deleted rows. Useful for online cleanup jobs: a single DELETE of millions of
+// rows holds the row locks and produces a burst of WAL until it finishes.
+//
+// Each batch selects the ctid (physical location) of the rows to delete, until a batch deletes nothing (a batch may
+// delete less than batchSize rows when rows change concurrently). The table may be qualified by the schema
+// ("schema.table"). The condition is required, use TRUNCATE to delete all rows. Returns ErrInTransaction when called within a transaction, as the batches could not be committed.
+// On error, the batches already deleted remain deleted.
+func (d *Database) DeleteInBatches(table string, condition map[string]interface{}, batchSize int) (int64, error) {
+	if d.tx != nil {
+		return 0, ErrInTransaction
+	}
+	if len(condition) == 0 {
+		return 0, errors.New("DeleteInBatches requires a condition, use TRUNCATE to delete all rows of " + table)
+	}
+	if batchSize <= 0 {
+		return 0, errors.New("invalid batch size " + strconv.Itoa(batchSize))
+	}
+
+	query, args := d.BuildDeleteBatch(table, condition, batchSize)
+
+	var total int64
+	for {
+		deleted, err := d.ExecuteRows(query, args...)
+		if err != nil {
+			return total, err
+		}
+		total += deleted
+		if deleted == 0 {
+			return total, nil
+		}
+	}
+}
+
+// BuildDeleteBatch returns the SQL and args of a single batch of DeleteInBatches, without executing it. The condition
+// is repeated in the outer DELETE, so a row updated concurrently after being selected is only deleted if it still
+// matches.
+func (d *Database) BuildDeleteBatch(table string, condition map[string]interface{}, batchSize int) (string, []interface{}) {
+	var args []interface{}
+	where := whereEquals(condition, &args)
+	name := quoteQualifiedName(table)
+	query := "DELETE FROM " + name + where +
+		" AND ctid = ANY(ARRAY(SELECT ctid FROM " + name + where + " LIMIT " + strconv.Itoa(batchSize) + "))"
+	return query, args
+}
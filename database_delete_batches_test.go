@@ -0,0 +1,82 @@
+package pg
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+func TestBuildDeleteBatch(t *testing.T) {
+	db := &Database{}
+	query, args := db.BuildDeleteBatch("events", map[string]interface{}{"tenant": "t1", "expired": true}, 500)
+
+	want := `DELETE FROM "events" WHERE "expired" = $1 AND "tenant" = $2 AND ctid = ANY(ARRAY(` +
+		`SELECT ctid FROM "events" WHERE "expired" = $1 AND "tenant" = $2 LIMIT 500))`
+	if query != want {
+		t.Errorf("got  %s\nwant %s", query, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{true, "t1"}) {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	query, _ = db.BuildDeleteBatch("audit.events", map[string]interface{}{"expired": true}, 10)
+	want = `DELETE FROM "audit"."events" WHERE "expired" = $1 AND ctid = ANY(ARRAY(` +
+		`SELECT ctid FROM "audit"."events" WHERE "expired" = $1 LIMIT 10))`
+	if query != want {
+		t.Errorf("got  %s\nwant %s", query, want)
+	}
+}
+
+func TestDeleteInBatchesInvalid(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	condition := map[string]interface{}{"expired": true}
+
+	if _, err := db.DeleteInBatches("events", nil, 100); err == nil {
+		t.Error("expected error without condition")
+	}
+	if _, err := db.DeleteInBatches("events", condition, 0); err == nil {
+		t.Error("expected error for an invalid batch size")
+	}
+	err := db.Transaction(func(tx *Database) error {
+		_, err := tx.DeleteInBatches("events", condition, 100)
+		return err
+	})
+	if !errors.Is(err, ErrInTransaction) {
+		t.Errorf("expected ErrInTransaction, got %v", err)
+	}
+	if got := log.String(); got != "BEGIN;ROLLBACK" {
+		t.Errorf("unexpected statements %s", got)
+	}
+}
+
+func TestDeleteInBatches(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		_, err := db.Execute(`CREATE TABLE delete_batches (id INT, expired BOOLEAN);
+			INSERT INTO delete_batches SELECT s, s % 4 <> 0 FROM generate_series(1, 1000) s`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deleted, err := db.DeleteInBatches("delete_batches", map[string]interface{}{"expired": true}, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != 750 {
+			t.Errorf("expected 750 deleted rows, got %d", deleted)
+		}
+		if count, err := db.QueryForInt("SELECT count(*) FROM delete_batches WHERE NOT expired"); err != nil || count != 250 {
+			t.Errorf("expected the other rows to be kept, got %d, %v", count, err)
+		}
+
+		// exact multiple of the batch size, the last batch deletes nothing
+		deleted, err = db.DeleteInBatches("delete_batches", map[string]interface{}{"expired": false}, 50)
+		if err != nil || deleted != 250 {
+			t.Errorf("expected 250 deleted rows, got %d, %v", deleted, err)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package pg
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// keepaliveDialer is the pq.Dialer used when Config.TCPKeepalivesIdle or Config.TCPKeepalivesInterval are set
+type keepaliveDialer struct {
+	idle     time.Duration
+	interval time.Duration
+}
+
+func (d *keepaliveDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *keepaliveDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, address)
+}
+
+func (d *keepaliveDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	idle := d.idle
+	if idle <= 0 {
+		idle = d.interval
+	}
+
+	// sets both the idle time and the interval between probes
+	dialer := net.Dialer{KeepAlive: idle}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpConn, isTcp := conn.(*net.TCPConn); isTcp && d.interval > 0 && d.interval != idle {
+		if err = setKeepaliveInterval(tcpConn, d.interval); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,25 @@
+package pg
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// setKeepaliveInterval sets TCP_KEEPINTVL, net.Dialer uses the same value for the idle time and the interval
+func setKeepaliveInterval(conn *net.TCPConn, interval time.Duration) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	seconds := int((interval + time.Second - 1) / time.Second)
+	var errSet error
+	err = raw.Control(func(fd uintptr) {
+		errSet = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, seconds)
+	})
+	if err != nil {
+		return err
+	}
+	return errSet
+}
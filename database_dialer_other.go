@@ -0,0 +1,13 @@
+//go:build !linux
+
+package pg
+
+import (
+	"net"
+	"time"
+)
+
+// setKeepaliveInterval is only supported on linux, on other systems the interval is the same as the idle time
+func setKeepaliveInterval(conn *net.TCPConn, interval time.Duration) error {
+	return nil
+}
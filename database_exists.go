@@ -0,0 +1,61 @@
+package pg
+
+import (
+	"errors"
+	"strings"
+)
+
+// TableExists checks whether the table exists in the schema. Useful for conditional, idempotent migrations (see
+// Migration.ExecFn).
+func (d *Database) TableExists(schema, table string) (bool, error) {
+	exist, err := d.QueryForBoolean(strings.Join([]string{
+		"SELECT EXISTS (",
+		"    SELECT 1 FROM pg_catalog.pg_class c",
+		"    JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace",
+		"    WHERE n.nspname = $1",
+		"    AND c.relname = $2",
+		"    AND c.relkind IN ('r', 'p')",
+		")",
+	}, "\n"), schema, table)
+	if err != nil {
+		return false, errors.New("unable to check whether table " + table + " exists (cause: " + err.Error() + ")")
+	}
+	return exist, nil
+}
+
+// ColumnExists checks whether the column exists in the table. Dropped columns are ignored.
+func (d *Database) ColumnExists(schema, table, column string) (bool, error) {
+	exist, err := d.QueryForBoolean(strings.Join([]string{
+		"SELECT EXISTS (",
+		"    SELECT 1 FROM pg_catalog.pg_attribute a",
+		"    JOIN pg_catalog.pg_class c ON c.oid = a.attrelid",
+		"    JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace",
+		"    WHERE n.nspname = $1",
+		"    AND c.relname = $2",
+		"    AND a.attname = $3",
+		"    AND a.attnum > 0",
+		"    AND NOT a.attisdropped",
+		")",
+	}, "\n"), schema, table, column)
+	if err != nil {
+		return false, errors.New("unable to check whether column " + table + "." + column + " exists (cause: " + err.Error() + ")")
+	}
+	return exist, nil
+}
+
+// IndexExists checks whether the index exists in the schema. Index names are unique per schema.
+func (d *Database) IndexExists(schema, index string) (bool, error) {
+	exist, err := d.QueryForBoolean(strings.Join([]string{
+		"SELECT EXISTS (",
+		"    SELECT 1 FROM pg_catalog.pg_class c",
+		"    JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace",
+		"    WHERE n.nspname = $1",
+		"    AND c.relname = $2",
+		"    AND c.relkind = 'i'",
+		")",
+	}, "\n"), schema, index)
+	if err != nil {
+		return false, errors.New("unable to check whether index " + index + " exists (cause: " + err.Error() + ")")
+	}
+	return exist, nil
+}
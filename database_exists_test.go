@@ -0,0 +1,48 @@
+package pg
+
+import (
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+func TestExists(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		_, err := db.Execute(`CREATE TABLE exists_users (id INT, email TEXT, legacy TEXT);
+			ALTER TABLE exists_users DROP COLUMN legacy;
+			CREATE INDEX exists_users_email_idx ON exists_users (email);
+			CREATE VIEW exists_view AS SELECT id FROM exists_users`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name  string
+			check func() (bool, error)
+			want  bool
+		}{
+			{"table", func() (bool, error) { return db.TableExists("public", "exists_users") }, true},
+			{"table in other schema", func() (bool, error) { return db.TableExists("other", "exists_users") }, false},
+			{"view", func() (bool, error) { return db.TableExists("public", "exists_view") }, false},
+			{"index as table", func() (bool, error) { return db.TableExists("public", "exists_users_email_idx") }, false},
+			{"column", func() (bool, error) { return db.ColumnExists("public", "exists_users", "email") }, true},
+			{"missing column", func() (bool, error) { return db.ColumnExists("public", "exists_users", "name") }, false},
+			{"dropped column", func() (bool, error) { return db.ColumnExists("public", "exists_users", "legacy") }, false},
+			{"system column", func() (bool, error) { return db.ColumnExists("public", "exists_users", "ctid") }, false},
+			{"index", func() (bool, error) { return db.IndexExists("public", "exists_users_email_idx") }, true},
+			{"table as index", func() (bool, error) { return db.IndexExists("public", "exists_users") }, false},
+		}
+		for _, tt := range tests {
+			got, err := tt.check()
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if got != tt.want {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	})
+}
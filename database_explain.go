@@ -0,0 +1,96 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+var ErrNotExplainable = errors.New("statement cannot be explained")
+
+// explainableStatements first keywords of the statements accepted by EXPLAIN
+var explainableStatements = map[string]bool{
+	"SELECT":  true,
+	"WITH":    true,
+	"VALUES":  true,
+	"TABLE":   true,
+	"INSERT":  true,
+	"UPDATE":  true,
+	"DELETE":  true,
+	"MERGE":   true,
+	"EXECUTE": true,
+	"DECLARE": true,
+	"CREATE":  true, // CREATE TABLE AS and CREATE MATERIALIZED VIEW, the server rejects the others
+}
+
+// Explain returns the execution plan of the query (EXPLAIN (FORMAT TEXT)), one node per line. The query is not
+// executed.
+//
+// Utility statements (e.g. VACUUM, ALTER TABLE) are rejected with ErrNotExplainable.
+func (d *Database) Explain(query string, args ...interface{}) (string, error) {
+	if !isExplainable(query) {
+		return "", ErrNotExplainable
+	}
+	return d.explain(d, "EXPLAIN (FORMAT TEXT) "+query, args...)
+}
+
+// ExplainAnalyze executes the query and returns the execution plan with the actual times and buffer usage, as JSON
+// (EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON)).
+//
+// The query is executed inside a transaction that is rolled back, so the changes of INSERT, UPDATE and DELETE are
+// discarded. When d is already a transaction, the query runs in it, in a savepoint that is rolled back.
+func (d *Database) ExplainAnalyze(query string, args ...interface{}) (string, error) {
+	if !isExplainable(query) {
+		return "", ErrNotExplainable
+	}
+	query = "EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) " + query
+
+	if d.tx != nil {
+		if _, err := d.tx.Exec("SAVEPOINT pg_explain_analyze"); err != nil {
+			return "", err
+		}
+		plan, err := d.explain(d, query, args...)
+		if _, errR := d.tx.Exec("ROLLBACK TO SAVEPOINT pg_explain_analyze"); errR != nil && err == nil {
+			err = errR
+		}
+		return plan, err
+	}
+
+	tx, err := d.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: d.readOnly})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	return d.explain(tx, query, args...)
+}
+
+func (d *Database) explain(db *Database, query string, args ...interface{}) (string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err = rows.Scan(&line); err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func isExplainable(query string) bool {
+	fields := strings.Fields(strings.TrimLeft(query, "( \t\r\n"))
+	if len(fields) == 0 {
+		return false
+	}
+	return explainableStatements[strings.ToUpper(fields[0])]
+}
@@ -0,0 +1,77 @@
+package pg
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+func TestExplainStatements(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	log.result("EXPLAIN (FORMAT TEXT) SELECT * FROM users", []string{"QUERY PLAN"},
+		[]driver.Value{"Seq Scan on users"}, []driver.Value{"  Filter: active"},
+	)
+
+	plan, err := db.Explain("SELECT * FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan != "Seq Scan on users\n  Filter: active" {
+		t.Errorf("unexpected plan %s", plan)
+	}
+
+	if _, err = db.ExplainAnalyze("DELETE FROM users"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, utility := range []string{"VACUUM users", "ALTER TABLE users ADD x INT", "  "} {
+		if _, err = db.Explain(utility); err != ErrNotExplainable {
+			t.Errorf("Explain(%s) expected ErrNotExplainable, got %v", utility, err)
+		}
+	}
+
+	want := "EXPLAIN (FORMAT TEXT) SELECT * FROM users;" +
+		"BEGIN;EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) DELETE FROM users;ROLLBACK"
+	if got := log.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		if _, err := db.Execute("CREATE TABLE explain_items AS SELECT s AS id FROM generate_series(1, 10000) s"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Execute("CREATE INDEX explain_items_id ON explain_items (id); ANALYZE explain_items"); err != nil {
+			t.Fatal(err)
+		}
+
+		plan, err := db.Explain("SELECT * FROM explain_items WHERE id = $1", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(plan, "explain_items_id") {
+			t.Errorf("expected index scan, got %s", plan)
+		}
+
+		plan, err = db.ExplainAnalyze("DELETE FROM explain_items")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parsed []map[string]interface{}
+		if err = json.Unmarshal([]byte(plan), &parsed); err != nil || len(parsed) != 1 {
+			t.Errorf("expected json plan, got %s (%v)", plan, err)
+		}
+
+		// rolled back
+		if count, _ := db.QueryForInt("SELECT count(*) FROM explain_items"); count != 10000 {
+			t.Errorf("expected the delete to be rolled back, got %d rows", count)
+		}
+	})
+}
@@ -6,7 +6,7 @@ import (
 )
 
 var (
-	instances        map[string]*Database
+	instances        = map[string]*Database{}
 	instancesMu      sync.RWMutex
 	ErrNoInstance    = errors.New("there is no active database instance")
 	ErrManyInstances = errors.New("there is more than one active database instance")
@@ -33,3 +33,21 @@ func GetInstance() (*Database, error) {
 
 	return instance, nil
 }
+
+// CloseAll closes every open database instance, returning the first error found
+func CloseAll() error {
+	instancesMu.RLock()
+	open := make([]*Database, 0, len(instances))
+	for _, d := range instances {
+		open = append(open, d)
+	}
+	instancesMu.RUnlock()
+
+	var first error
+	for _, d := range open {
+		if err := d.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
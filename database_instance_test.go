@@ -0,0 +1,60 @@
+package pg
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentOpen(t *testing.T) {
+	const count = 20
+
+	var wg sync.WaitGroup
+	dbs := make([]*Database, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sqlDb, err := sql.Open("pgfake", t.Name())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// same config, ids must still be unique
+			dbs[i] = OpenDB(sqlDb, &Config{Database: t.Name()})
+		}(i)
+	}
+	wg.Wait()
+
+	ids := map[string]bool{}
+	for _, db := range dbs {
+		if db == nil {
+			t.FailNow()
+		}
+		if ids[db.id] {
+			t.Errorf("duplicated instance id %s", db.id)
+		}
+		ids[db.id] = true
+	}
+
+	if _, err := GetInstance(); err != ErrManyInstances {
+		t.Errorf("expected ErrManyInstances, got %v", err)
+	}
+
+	// Close and CloseAll racing on the same instances
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, db := range dbs[:count/2] {
+			_ = db.Close()
+		}
+	}()
+	if err := CloseAll(); err != nil {
+		t.Error(err)
+	}
+	wg.Wait()
+
+	if _, err := GetInstance(); err != ErrNoInstance {
+		t.Errorf("expected ErrNoInstance, got %v", err)
+	}
+}
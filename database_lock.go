@@ -0,0 +1,76 @@
+package pg
+
+import (
+	"database/sql"
+	"strconv"
+)
+
+// RowLockStrength the lock acquired on the selected rows, see SelectLocked
+type RowLockStrength string
+
+const (
+	LockForUpdate RowLockStrength = "FOR UPDATE" // exclusive, blocks updates, deletes and other locks
+	LockForShare  RowLockStrength = "FOR SHARE"  // shared, blocks updates and deletes
+)
+
+// RowLockWait what to do when a selected row is already locked by another transaction, see SelectLocked
+type RowLockWait int
+
+const (
+	LockWait       RowLockWait = 0 // waits for the lock to be released (default)
+	LockNoWait     RowLockWait = 1 // fails immediately (NOWAIT)
+	LockSkipLocked RowLockWait = 2 // the locked rows are skipped (SKIP LOCKED)
+)
+
+// SelectForUpdate executes a SELECT columns FROM table WHERE condition FOR UPDATE, locking the rows until the end of
+// the current transaction.
+//
+// Returns ErrNotInTransaction when called outside a transaction, as the lock would be released immediately.
+func (d *Database) SelectForUpdate(table string, columns []string, condition map[string]interface{}) (*sql.Rows, error) {
+	return d.SelectLocked(table, columns, condition, LockForUpdate, LockWait, 0)
+}
+
+// SelectForShare executes a SELECT columns FROM table WHERE condition FOR SHARE, see SelectForUpdate
+func (d *Database) SelectForShare(table string, columns []string, condition map[string]interface{}) (*sql.Rows, error) {
+	return d.SelectLocked(table, columns, condition, LockForShare, LockWait, 0)
+}
+
+// SelectLocked executes a SELECT columns FROM table WHERE condition with the row lock strength and wait policy,
+// returning at most limit rows (zero is unlimited).
+//
+// LockSkipLocked with a limit allows concurrent workers to each grab different rows of a job queue. Ex.
+//
+//	err := db.Transaction(func(tx *Database) error {
+//		rows, err := tx.SelectLocked("jobs", []string{"id", "payload"}, map[string]interface{}{"status": "pending"},
+//			LockForUpdate, LockSkipLocked, 10)
+//		...
+//	})
+//
+// Returns ErrNotInTransaction when called outside a transaction, as the lock would be released immediately.
+func (d *Database) SelectLocked(
+	table string, columns []string, condition map[string]interface{}, strength RowLockStrength, wait RowLockWait, limit int,
+) (*sql.Rows, error) {
+	if d.tx == nil {
+		return nil, ErrNotInTransaction
+	}
+	query, args := d.BuildSelectLocked(table, columns, condition, strength, wait, limit)
+	return d.Query(query, args...)
+}
+
+// BuildSelectLocked returns the SQL and args of SelectLocked, without executing it
+func (d *Database) BuildSelectLocked(
+	table string, columns []string, condition map[string]interface{}, strength RowLockStrength, wait RowLockWait, limit int,
+) (string, []interface{}) {
+	query, args := d.BuildSelect(table, columns, condition)
+	if limit > 0 {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+	query += " " + string(strength)
+	switch wait {
+	case LockNoWait:
+		query += " NOWAIT"
+	case LockSkipLocked:
+		query += " SKIP LOCKED"
+	}
+	return query, args
+}
@@ -0,0 +1,104 @@
+package pg
+
+import (
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+func TestBuildSelectLocked(t *testing.T) {
+	db := &Database{}
+	condition := map[string]interface{}{"status": "pending"}
+
+	tests := []struct {
+		strength RowLockStrength
+		wait     RowLockWait
+		limit    int
+		want     string
+	}{
+		{LockForUpdate, LockWait, 0, `SELECT "id" FROM "jobs" WHERE "status" = $1 FOR UPDATE`},
+		{LockForShare, LockNoWait, 0, `SELECT "id" FROM "jobs" WHERE "status" = $1 FOR SHARE NOWAIT`},
+		{LockForUpdate, LockSkipLocked, 1, `SELECT "id" FROM "jobs" WHERE "status" = $1 LIMIT 1 FOR UPDATE SKIP LOCKED`},
+	}
+	for _, tt := range tests {
+		if got, _ := db.BuildSelectLocked("jobs", []string{"id"}, condition, tt.strength, tt.wait, tt.limit); got != tt.want {
+			t.Errorf("got  %s\nwant %s", got, tt.want)
+		}
+	}
+}
+
+func TestSelectForUpdateOutsideTransaction(t *testing.T) {
+	db, log := openFakeDatabase(t)
+
+	if _, err := db.SelectForUpdate("jobs", []string{"id"}, nil); err != ErrNotInTransaction {
+		t.Errorf("expected ErrNotInTransaction, got %v", err)
+	}
+	if _, err := db.SelectForShare("jobs", []string{"id"}, nil); err != ErrNotInTransaction {
+		t.Errorf("expected ErrNotInTransaction, got %v", err)
+	}
+	if got := log.String(); got != "" {
+		t.Errorf("unexpected statements %s", got)
+	}
+}
+
+func TestSelectSkipLocked(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		if _, err := db.Execute("CREATE TABLE jobs (id INT PRIMARY KEY, status TEXT); INSERT INTO jobs VALUES (1, 'pending'), (2, 'pending')"); err != nil {
+			t.Fatal(err)
+		}
+
+		grab := func(tx *Database, wait RowLockWait) (int64, error) {
+			rows, err := tx.SelectLocked("jobs", []string{"id"}, map[string]interface{}{"status": "pending"}, LockForUpdate, wait, 1)
+			if err != nil {
+				return 0, err
+			}
+			defer rows.Close()
+			var id int64
+			if rows.Next() {
+				err = rows.Scan(&id)
+			}
+			if err == nil {
+				err = rows.Err()
+			}
+			return id, err
+		}
+
+		first, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer first.Rollback()
+		second, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer second.Rollback()
+
+		firstId, err := grab(first, LockSkipLocked)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secondId, err := grab(second, LockSkipLocked)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if firstId == 0 || secondId == 0 || firstId == secondId {
+			t.Errorf("expected different rows, got %d and %d", firstId, secondId)
+		}
+
+		third, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer third.Rollback()
+		if id, err := grab(third, LockSkipLocked); err != nil || id != 0 {
+			t.Errorf("expected all rows to be skipped, got %d, %v", id, err)
+		}
+		if _, err := grab(third, LockNoWait); err == nil {
+			t.Error("expected NOWAIT to fail on a locked row")
+		}
+	})
+}
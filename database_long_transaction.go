@@ -0,0 +1,62 @@
+package pg
+
+import (
+	"database/sql"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// longTxInfo the isolation level, start and caller of a transaction, see Config.LongTransactionThreshold
+type longTxInfo struct {
+	level  sql.IsolationLevel
+	start  time.Time
+	caller string
+}
+
+// newLongTxInfo records a transaction being started, nil when Config.LongTransactionThreshold is disabled
+func (d *Database) newLongTxInfo(opts *sql.TxOptions) *longTxInfo {
+	if d.config == nil || d.config.LongTransactionThreshold <= 0 {
+		return nil
+	}
+	info := &longTxInfo{level: sql.LevelDefault, start: time.Now(), caller: callerLocation()}
+	if opts != nil {
+		info.level = opts.Isolation
+	}
+	return info
+}
+
+// checkLongTx logs a warning when the transaction ended by the action (commit or rollback) exceeded
+// Config.LongTransactionThreshold
+func (d *Database) checkLongTx(action string) {
+	info := d.longTx
+	if info == nil {
+		return
+	}
+	d.longTx = nil
+
+	elapsed := time.Since(info.start)
+	if elapsed < d.config.LongTransactionThreshold || d.logger == nil {
+		return
+	}
+	d.logger.Warn(
+		"Long transaction: %s after %s (threshold %s), isolation level %s, started at %s",
+		action, elapsed.String(), d.config.LongTransactionThreshold.String(), info.level.String(), info.caller,
+	)
+}
+
+// callerLocation returns the file:line of the first caller outside this package (test files are reported)
+func callerLocation() string {
+	for skip := 2; ; skip++ {
+		pc, file, line, ok := runtime.Caller(skip)
+		if !ok {
+			return "unknown"
+		}
+		fn := runtime.FuncForPC(pc)
+		if fn != nil && strings.HasPrefix(fn.Name(), "github.com/nidorx/pg.") && !strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		return file + ":" + strconv.Itoa(line)
+	}
+}
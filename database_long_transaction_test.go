@@ -0,0 +1,78 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLongTransactionThreshold(t *testing.T) {
+	db, _ := openFakeDatabase(t)
+	logger := &recordingLogger{}
+	db.SetLogger(logger)
+
+	// disabled by default
+	err := db.Transaction(func(tx *Database) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.warnings) != 0 {
+		t.Fatalf("unexpected warnings %v", logger.warnings)
+	}
+
+	db.config.LongTransactionThreshold = 10 * time.Millisecond
+
+	if err = db.Transaction(func(tx *Database) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.warnings) != 0 {
+		t.Fatalf("unexpected warnings for a short transaction %v", logger.warnings)
+	}
+
+	err = db.Transaction(func(tx *Database) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Transaction(func(tx *Database) error {
+		time.Sleep(20 * time.Millisecond)
+		return errors.New("failure")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if len(logger.warnings) != 3 {
+		t.Fatalf("expected 3 warnings, got %v", logger.warnings)
+	}
+	for i, want := range []string{"commit after", "rollback after", "rollback after"} {
+		warning := logger.warnings[i]
+		if !strings.Contains(warning, want) || !strings.Contains(warning, "database_long_transaction_test.go:") {
+			t.Errorf("unexpected warning %s", warning)
+		}
+	}
+	if !strings.Contains(logger.warnings[1], "isolation level Serializable") {
+		t.Errorf("expected isolation level in %s", logger.warnings[1])
+	}
+	if !strings.Contains(logger.warnings[0], "isolation level Default") {
+		t.Errorf("expected default isolation level in %s", logger.warnings[0])
+	}
+}
@@ -0,0 +1,90 @@
+package pg
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// QueryMaps executes a query and returns each row as a map keyed by column name.
+//
+// Useful when the result shape is unknown at compile time. Values are decoded into driver-appropriate Go types
+// (JSON/JSONB into nested maps, numerics into int64/float64, timestamps into time.Time). NULLs are kept as nil entries.
+func (d *Database) QueryMaps(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []map[string]interface{}
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			value, errConvert := mapValue(columnTypes[i].DatabaseTypeName(), values[i])
+			if errConvert != nil {
+				return nil, errConvert
+			}
+			row[column] = value
+		}
+		result = append(result, row)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// mapValue converts a raw value returned by the driver into the Go type used by QueryMaps
+func mapValue(databaseTypeName string, value interface{}) (interface{}, error) {
+	raw, isBytes := value.([]byte)
+	if !isBytes {
+		return value, nil
+	}
+
+	switch databaseTypeName {
+	case "BYTEA":
+		// copy, the driver may reuse the buffer on next scan
+		return append([]byte(nil), raw...), nil
+	case "JSON", "JSONB":
+		var decoded interface{}
+		if err := json.Unmarshal(raw, &decoded); err != nil {
+			return nil, err
+		}
+		return decoded, nil
+	case "NUMERIC":
+		text := string(raw)
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	default:
+		return string(raw), nil
+	}
+}
@@ -0,0 +1,41 @@
+package pg
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_mapValue(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		typeName string
+		value    interface{}
+		want     interface{}
+	}{
+		{"INT8", int64(10), int64(10)},
+		{"TEXT", nil, nil},
+		{"TIMESTAMPTZ", now, now},
+		{"VARCHAR", []byte("abc"), "abc"},
+		{"UUID", []byte("a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"), "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"},
+		{"BYTEA", []byte{1, 2}, []byte{1, 2}},
+		{"NUMERIC", []byte("42"), int64(42)},
+		{"NUMERIC", []byte("42.5"), 42.5},
+		{"JSONB", []byte(`{"a":[1,"b"]}`), map[string]interface{}{"a": []interface{}{float64(1), "b"}}},
+	}
+
+	for _, tt := range tests {
+		got, err := mapValue(tt.typeName, tt.value)
+		if err != nil {
+			t.Fatalf("mapValue(%s, %v) error = %v", tt.typeName, tt.value, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mapValue(%s, %v) = %#v, want %#v", tt.typeName, tt.value, got, tt.want)
+		}
+	}
+
+	if _, err := mapValue("JSONB", []byte("{")); err == nil {
+		t.Error("mapValue(JSONB) expected error for invalid json")
+	}
+}
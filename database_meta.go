@@ -0,0 +1,43 @@
+package pg
+
+import (
+	"database/sql"
+	"reflect"
+)
+
+// ColumnMeta metadata of a column of a query result, see QueryWithMeta
+type ColumnMeta struct {
+	Name          string       // The column name
+	DatabaseType  string       // The database type name in uppercase, like "INT4", "TEXT" or "TIMESTAMPTZ"
+	Nullable      bool         // Whether the column may be NULL, only meaningful when NullableKnown
+	NullableKnown bool         // Whether the driver reports the nullability (lib/pq does not)
+	ScanType      reflect.Type // The Go type suitable for scanning the column, like int64 or time.Time
+}
+
+// QueryWithMeta executes a query and returns the metadata of the result columns with the rows, for generic data tools
+// that need the types to render or format the values. The rows must be closed by the caller.
+func (d *Database) QueryWithMeta(query string, args ...interface{}) ([]ColumnMeta, *sql.Rows, error) {
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		_ = rows.Close()
+		return nil, nil, err
+	}
+
+	columns := make([]ColumnMeta, len(columnTypes))
+	for i, columnType := range columnTypes {
+		nullable, ok := columnType.Nullable()
+		columns[i] = ColumnMeta{
+			Name:          columnType.Name(),
+			DatabaseType:  columnType.DatabaseTypeName(),
+			Nullable:      nullable,
+			NullableKnown: ok,
+			ScanType:      columnType.ScanType(),
+		}
+	}
+	return columns, rows, nil
+}
@@ -0,0 +1,71 @@
+package pg
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dhui/dktest"
+)
+
+func TestQueryWithMeta(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	log.result("SELECT meta", []string{"id", "name"}, []driver.Value{int64(1), "alice"})
+
+	columns, rows, err := db.QueryWithMeta("SELECT meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	if len(columns) != 2 || columns[0].Name != "id" || columns[1].Name != "name" || columns[0].NullableKnown {
+		t.Errorf("unexpected columns %+v", columns)
+	}
+	var id int64
+	var name string
+	if !rows.Next() || rows.Scan(&id, &name) != nil || id != 1 || name != "alice" {
+		t.Errorf("unexpected row %d %s", id, name)
+	}
+
+	log.fail("SELECT broken", errors.New("broken"))
+	if _, _, err = db.QueryWithMeta("SELECT broken"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestQueryWithMetaTypes(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		columns, rows, err := db.QueryWithMeta("SELECT 1::int4 AS id, 'a'::text AS name, now() AS created_at, true AS active, 2::int8 AS total")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+
+		want := []struct {
+			name, databaseType string
+			scanType           reflect.Type
+		}{
+			{"id", "INT4", reflect.TypeOf(int32(0))},
+			{"name", "TEXT", reflect.TypeOf("")},
+			{"created_at", "TIMESTAMPTZ", reflect.TypeOf(time.Time{})},
+			{"active", "BOOL", reflect.TypeOf(true)},
+			{"total", "INT8", reflect.TypeOf(int64(0))},
+		}
+		if len(columns) != len(want) {
+			t.Fatalf("unexpected columns %+v", columns)
+		}
+		for i, w := range want {
+			if columns[i].Name != w.name || columns[i].DatabaseType != w.databaseType || columns[i].ScanType != w.scanType {
+				t.Errorf("column %d = %+v, want %s %s %s", i, columns[i], w.name, w.databaseType, w.scanType)
+			}
+		}
+		if !rows.Next() {
+			t.Errorf("expected a row, got %v", rows.Err())
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package pg
+
+import (
+	"database/sql"
+	"sync/atomic"
+)
+
+// Metrics counters of the operations executed by a Database, including its connections and transactions.
+//
+// The counters are cumulative since Open, to be exposed by the application (e.g. as Prometheus counters).
+type Metrics struct {
+	Queries           int64 // queries executed (Query, QueryRow, QueryFor...)
+	Execs             int64 // statements executed with Execute
+	Errors            int64 // queries, statements, commits and rollbacks that failed
+	TxCommits         int64 // transactions committed
+	TxRollbacks       int64 // transactions rolled back
+	MigrationsApplied int64 // migrations applied successfully
+}
+
+// metricCounters are shared by all instances derived from the same Database. Nil counters are ignored.
+type metricCounters struct {
+	queries           int64
+	execs             int64
+	errors            int64
+	txCommits         int64
+	txRollbacks       int64
+	migrationsApplied int64
+}
+
+// Metrics returns a snapshot of the operation counters of the database
+func (d *Database) Metrics() Metrics {
+	m := d.metrics
+	if m == nil {
+		return Metrics{}
+	}
+	return Metrics{
+		Queries:           atomic.LoadInt64(&m.queries),
+		Execs:             atomic.LoadInt64(&m.execs),
+		Errors:            atomic.LoadInt64(&m.errors),
+		TxCommits:         atomic.LoadInt64(&m.txCommits),
+		TxRollbacks:       atomic.LoadInt64(&m.txRollbacks),
+		MigrationsApplied: atomic.LoadInt64(&m.migrationsApplied),
+	}
+}
+
+// count counts the operation, and the error when it failed (sql.ErrNoRows is not an error)
+func (m *metricCounters) count(counter *int64, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		atomic.AddInt64(&m.errors, 1)
+	}
+	atomic.AddInt64(counter, 1)
+}
+
+func (m *metricCounters) query(err error) {
+	if m != nil {
+		m.count(&m.queries, err)
+	}
+}
+
+func (m *metricCounters) exec(err error) {
+	if m != nil {
+		m.count(&m.execs, err)
+	}
+}
+
+// commit counts the transaction as committed, or as an error when the commit failed
+func (m *metricCounters) commit(err error) {
+	if m != nil {
+		m.finish(&m.txCommits, err)
+	}
+}
+
+// rollback counts the transaction as rolled back, or as an error when the rollback failed
+func (m *metricCounters) rollback(err error) {
+	if m != nil {
+		m.finish(&m.txRollbacks, err)
+	}
+}
+
+func (m *metricCounters) finish(counter *int64, err error) {
+	if err != nil {
+		atomic.AddInt64(&m.errors, 1)
+	} else {
+		atomic.AddInt64(counter, 1)
+	}
+}
+
+func (m *metricCounters) migrationApplied() {
+	if m != nil {
+		atomic.AddInt64(&m.migrationsApplied, 1)
+	}
+}
@@ -0,0 +1,58 @@
+package pg
+
+import (
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	db, _ := openFakeDatabase(t)
+
+	if _, err := db.Query("SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.QueryForInt("SELECT none"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Execute("UPDATE users SET active = true"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Execute("FAIL"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// transactions and connections share the counters
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Execute("DELETE FROM users"); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := db.Conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err = conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.CloseConn(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Metrics{Queries: 2, Execs: 3, Errors: 1, TxCommits: 1, TxRollbacks: 1}
+	if got := db.Metrics(); got != want {
+		t.Errorf("Metrics() = %+v, want %+v", got, want)
+	}
+
+	if got := (&Database{}).Metrics(); got != (Metrics{}) {
+		t.Errorf("expected empty metrics, got %+v", got)
+	}
+}
@@ -1,13 +1,23 @@
 package pg
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"os"
 	"path"
 	"strings"
+)
+
+// MissingLocalPolicy what to do with applied migrations that are not present locally, see MigrationConfig.OnMissingLocal
+type MissingLocalPolicy int
 
-	"golang.org/x/mod/semver"
+const (
+	MissingLocalFail   MissingLocalPolicy = 0 // Migrate fails (default)
+	MissingLocalWarn   MissingLocalPolicy = 1 // a warning is logged and the migration continues
+	MissingLocalIgnore MissingLocalPolicy = 2 // the migration continues silently
 )
 
 // MigrationConfig database config
@@ -16,73 +26,299 @@ type MigrationConfig struct {
 	Password string // The password to connect with.
 	Schema   string // migrationHistory schema name (defaults public)
 	Table    string // migrationHistory table name (defaults pg_schema_history)
+
+	// Database the database to migrate (defaults Config.Database), e.g. a database just created with CreateDatabase.
+	// Like a different Username, a new connection pool is opened for the migration.
+	Database string
+
+	// HistorySchema schema of the history table, when it should not be the same where the migrations run (defaults
+	// Schema)
+	HistorySchema string
+
+	// MigrationSearchPath search_path used to run the migrations, a schema or a comma separated list of schemas
+	// (defaults Schema). The first schema is created when missing.
+	MigrationSearchPath string
+
+	// OutOfOrder allows pending migrations with a version lower than the current schema version to be applied
+	OutOfOrder bool
+
+	// KeepFailures keeps the rows of failed attempts in the history table for audit, instead of deleting them on retry
+	KeepFailures bool
+
+	// SessionSetup statements executed at the start of the session of each migration, after the search_path is set,
+	// e.g. "SET ROLE ddl_admin" or "SET lock_timeout = '5s'". The session is reset when the migration ends.
+	SessionSetup []string
+
+	// NotifyChannel when set, a NOTIFY with the new schema version as payload is sent to this channel after Migrate
+	// applies at least one migration, so other services can react to schema changes
+	NotifyChannel string
+
+	// RejectEmpty fails when a migration (except repeatable ones) has no command, usually a prepare function that
+	// forgot to schedule its SQL, instead of recording it as applied without doing anything
+	RejectEmpty bool
+
+	// IgnoreCommentsInChecksum computes the checksum of the SQL of the migrations (see Migration.ExecSql) without the
+	// "--" and "/* */" comments and with the whitespace collapsed, so editing only the comments of an applied migration
+	// does not fail with a checksum mismatch. The executed SQL is unchanged. The checksums recorded before enabling it
+	// still match while the migrations are unchanged.
+	IgnoreCommentsInChecksum bool
+
+	// OnMissingLocal policy for applied migrations that are not present locally, e.g. old migration files pruned from
+	// the codebase of long-lived databases (defaults MissingLocalFail)
+	OnMissingLocal MissingLocalPolicy
+
+	// IncludeTags the tags of the migrations to apply, see Migration.Tag. Untagged migrations are always applied.
+	IncludeTags []string
+
+	// ExcludeTags the tags of the migrations to skip, even when another tag of the migration is included
+	ExcludeTags []string
+
+	// DeadlockRetries number of times a migration that failed with a deadlock (40P01) or serialization failure
+	// (40001), e.g. concurrent DDL or autovacuum, is retried by Migrate in a new lock acquisition before the failure is
+	// recorded. Other errors are never retried.
+	DeadlockRetries int
+
+	// MaxDebugSQLLen maximum length of the migration SQL logged in debug messages (e.g. large data seeds), longer SQL
+	// is truncated with a "... (truncated)" marker. Zero does not truncate.
+	MaxDebugSQLLen int
+
+	// AllowNewerSchema skips the migration (with a warning) instead of failing when the schema was already migrated
+	// to a version newer than the available migrations. Useful during rolling deploys, where old instances coexist
+	// with the new version that ran the migrations.
+	AllowNewerSchema bool
+
+	// CacheFile path of a file used to cache the state of the last successful Migrate. When the local migrations and
+	// the history table are unchanged, Migrate returns after a single SELECT max(installed_rank).
+	CacheFile string
+
+	// VersionComparator compares two migration versions (defaults MigrationOptions.VersionComparator). See
+	// NumericComparator for timestamp (20240115123000) or sequential (001, 002) versions.
+	VersionComparator func(a, b string) int
+
+	// VersionValidator checks whether a migration version is valid (defaults MigrationOptions.VersionValidator)
+	VersionValidator func(version string) bool
+
+	// UpgradeInstalledOn converts the installed_on column of an existing history table from TIMESTAMP (tables created by
+	// older versions) to TIMESTAMPTZ. The ALTER rewrites the table and changes the type read by reports, so it is only
+	// done when set.
+	UpgradeInstalledOn bool
+
+	// ExtraColumns additional bookkeeping columns of the history table (e.g. git SHA, CI build number). Missing
+	// columns are added to existing tables.
+	ExtraColumns []ColumnDef
+
+	// CreateTableSQL returns the DDL that creates the history table and its index (may be empty), replacing the
+	// default DDL, e.g. to set a tablespace, fillfactor or owner. The table must have the columns of the default DDL
+	// (and the ExtraColumns), which is validated after the creation.
+	CreateTableSQL func(schema, table string) (createSQL, indexSQL string)
+
+	// ExtraValues returns the values of the ExtraColumns for the migration being recorded
+	ExtraValues func(info *MigrationInfo) map[string]interface{}
 }
 
 // Migrate run all migrations
 func (d *Database) Migrate(config *MigrationConfig) error {
+	return d.MigrateContext(context.Background(), config)
+}
+
+// MigrateContext run all migrations. The context is propagated to the migration commands (see Migration.ExecFnCtx)
+func (d *Database) MigrateContext(ctx context.Context, config *MigrationConfig) error {
 
 	if d.migrations != nil {
-		if config == nil {
-			config = &MigrationConfig{}
+		history, release, err := d.newMigrationHistory(config)
+		if err != nil {
+			return err
 		}
+		defer release()
 
-		if config.Username == "" {
-			config.Username = d.config.Username
+		if err = history.Migrate(ctx); err != nil {
+			return err
 		}
 
-		if config.Password == "" {
-			config.Password = d.config.Password
-		}
+		d.migrations = nil
+		history.db.migrations = nil
+	}
 
-		if config.Schema == "" {
-			config.Schema = "public"
-		}
+	return nil
+}
 
-		if config.Table == "" {
-			config.Table = "pg_schema_history"
-		}
+// ApplyMigration applies a single registered migration out of band (hotfix path).
+//
+// The migration must be pending. Applying a version lower than the current schema version, or while older
+// migrations are still pending, is refused unless MigrationConfig.OutOfOrder is set.
+func (d *Database) ApplyMigration(config *MigrationConfig, version string) error {
+	history, release, err := d.newMigrationHistory(config)
+	if err != nil {
+		return err
+	}
+	defer release()
 
-		db := d
-		if config.Username != d.config.Username {
-			var err error
-			db, err = Open(&Config{
-				Username: config.Username,
-				Password: config.Password,
-				Host:     d.config.Host,
-				Port:     d.config.Port,
-				Database: d.config.Database,
-				SSLMode:  d.config.SSLMode,
-				Params:   d.config.Params,
-				DebugSql: d.config.DebugSql,
-				Logger:   d.config.Logger,
-			})
-			if err != nil {
-				return err
-			}
-			db.migrations = d.migrations
-			defer db.Close()
-		}
+	return history.ApplyMigration(context.Background(), version)
+}
 
-		history := &migrationHistory{
-			db:         db,
-			logger:     d.logger,
-			schemaName: config.Schema,
-			tableName:  config.Table,
-		}
+// newMigrationHistory fills the config defaults and creates the migrationHistory used to run the migrations
+func (d *Database) newMigrationHistory(config *MigrationConfig) (*migrationHistory, func(), error) {
+	if config == nil {
+		config = &MigrationConfig{}
+	}
 
-		if err := history.Migrate(); err != nil {
-			return err
+	if config.Username == "" {
+		config.Username = d.config.Username
+	}
+
+	if config.Password == "" {
+		config.Password = d.config.Password
+	}
+
+	if config.Database == "" {
+		config.Database = d.config.Database
+	}
+
+	if config.Schema == "" {
+		config.Schema = "public"
+	}
+
+	if config.Table == "" {
+		config.Table = "pg_schema_history"
+	}
+
+	if config.HistorySchema == "" {
+		config.HistorySchema = config.Schema
+	}
+
+	if config.MigrationSearchPath == "" {
+		config.MigrationSearchPath = config.Schema
+	}
+
+	db := d
+	release := func() {}
+	if config.Username != d.config.Username || config.Database != d.config.Database {
+		var err error
+		db, err = Open(&Config{
+			Username:       config.Username,
+			Password:       config.Password,
+			Host:           d.config.Host,
+			Port:           d.config.Port,
+			Database:       config.Database,
+			SSLMode:        d.config.SSLMode,
+			Params:         d.config.Params,
+			DebugSql:       d.config.DebugSql,
+			Logger:         d.config.Logger,
+			AcquireTimeout: d.config.AcquireTimeout,
+
+			ConnectTimeout:        d.config.ConnectTimeout,
+			TCPKeepalivesIdle:     d.config.TCPKeepalivesIdle,
+			TCPKeepalivesInterval: d.config.TCPKeepalivesInterval,
+		})
+		if err != nil {
+			return nil, nil, err
 		}
+		db.migrations = d.migrations
+		db.metrics = d.metrics
+		release = func() {
+			if err := db.Close(); err != nil {
+				d.logger.Error(err)
+			}
+		}
+	}
 
-		d.migrations = nil
-		db.migrations = nil
+	history := &migrationHistory{
+		db:                db,
+		logger:            d.logger,
+		schemaName:        config.HistorySchema,
+		searchPath:        config.MigrationSearchPath,
+		tableName:         config.Table,
+		outOfOrder:        config.OutOfOrder,
+		keepFailures:      config.KeepFailures,
+		allowNewerSchema:  config.AllowNewerSchema,
+		onMissingLocal:    config.OnMissingLocal,
+		maxSqlLog:         config.MaxDebugSQLLen,
+		sessionSetup:      config.SessionSetup,
+		notifyChannel:     config.NotifyChannel,
+		rejectEmpty:       config.RejectEmpty,
+		installedOnTz:     config.UpgradeInstalledOn,
+		ignoreComments:    config.IgnoreCommentsInChecksum,
+		createTableSql:    config.CreateTableSQL,
+		deadlockRetries:   config.DeadlockRetries,
+		includeTags:       config.IncludeTags,
+		excludeTags:       config.ExcludeTags,
+		cacheFile:         config.CacheFile,
+		versionComparator: config.VersionComparator,
+		versionValidator:  config.VersionValidator,
+		extraColumns:      config.ExtraColumns,
+		extraValues:       config.ExtraValues,
+		open:              openPool,
+	}
+	if history.versionComparator == nil {
+		history.versionComparator = d.migOptions.VersionComparator
+	}
+	if history.versionValidator == nil {
+		history.versionValidator = d.migOptions.VersionValidator
 	}
 
-	return nil
+	return history, release, nil
 }
 
 // AddMigrations automatically registers all migration files in a directory.
+//
+// Files already registered with the same version, description and content are ignored, so scanning the same
+// directory twice (e.g. double initialization) is safe. A registered migration with the same version and a different
+// content is still an error.
 func (d *Database) AddMigrations(dir fs.FS) error {
+	files, err := readMigrationFiles(dir)
+	if err != nil {
+		return err
+	}
+	return d.addMigrationFiles(files)
+}
+
+// AddMigrationsMany registers the migration files of multiple sources, e.g. the embed.FS of each module of the
+// application. The versions must be unique across all sources, duplicates are reported with the source (position in
+// dirs, starting at 1) and the file of each offender, before registering any migration.
+func (d *Database) AddMigrationsMany(dirs ...fs.FS) error {
+	var files []migrationFile
+	for i, dir := range dirs {
+		sourceFiles, err := readMigrationFiles(dir)
+		if err != nil {
+			return errors.New(fmt.Sprintf("unable to read migrations of source %d (cause: %s)", i+1, err.Error()))
+		}
+		for _, file := range sourceFiles {
+			file.source = i + 1
+			files = append(files, file)
+		}
+	}
+
+	byKey := map[string]migrationFile{}
+	for _, file := range files {
+		key := file.version
+		if key == "R" {
+			// repeatable migrations are identified by description
+			key = "R " + file.description
+		}
+		if other, exists := byKey[key]; exists {
+			return errors.New(fmt.Sprintf(
+				"found more than one migration with version %s\nOffenders:\n-> source %d: %s\n-> source %d: %s",
+				file.version, other.source, other.path, file.source, file.path,
+			))
+		}
+		byKey[key] = file
+	}
+
+	return d.addMigrationFiles(files)
+}
+
+// migrationFile a migration file read from a fs.FS, see readMigrationFiles
+type migrationFile struct {
+	source      int // position of the fs.FS in AddMigrationsMany
+	path        string
+	version     string
+	description string
+	content     string
+}
+
+// readMigrationFiles reads all migration files (v1.0.0_Analytics_Schema.sql) in a directory
+func readMigrationFiles(dir fs.FS) ([]migrationFile, error) {
+	var files []migrationFile
 	err := fs.WalkDir(dir, ".", func(filepath string, entry fs.DirEntry, err error) error {
 		if entry.IsDir() || !strings.HasSuffix(filepath, ".sql") {
 			return nil
@@ -93,35 +329,188 @@ func (d *Database) AddMigrations(dir fs.FS) error {
 			return err
 		}
 
-		parts := strings.Split(strings.TrimSpace(strings.TrimSuffix(path.Base(filepath), ".sql")), "_")
-		if len(parts) < 2 {
-			// v1.0.0_Analytics_Schema.sql
-			return errors.New("invalid migration name:" + filepath)
+		version, description, err := parseMigrationFileName(filepath)
+		if err != nil {
+			return err
 		}
-		version := strings.TrimPrefix(parts[0], "v")
-		description := strings.Join(parts[1:], " ")
 
-		return d.AddMigration(version, description, func(migration *Migration) {
-			migration.ExecSql(string(content))
+		files = append(files, migrationFile{
+			path:        filepath,
+			version:     version,
+			description: description,
+			content:     string(content),
 		})
+		return nil
 	})
 
-	return err
+	return files, err
 }
 
+// parseMigrationFileName extracts the version and the description of a migration file name
+// (v1.0.0_Analytics_Schema.sql)
+func parseMigrationFileName(filepath string) (version, description string, err error) {
+	parts := strings.Split(strings.TrimSpace(strings.TrimSuffix(path.Base(filepath), ".sql")), "_")
+	if len(parts) < 2 {
+		return "", "", errors.New("invalid migration name:" + filepath)
+	}
+	return strings.TrimPrefix(parts[0], "v"), strings.Join(parts[1:], " "), nil
+}
+
+// addMigrationFiles registers the files, nothing is registered when any of them is invalid
+func (d *Database) addMigrationFiles(files []migrationFile) error {
+	// registers in a copy, so a duplicated version does not leave the files before it registered
+	staging := &Database{
+		logger:     d.logger,
+		migOptions: d.migOptions,
+		migrations: append([]*Migration(nil), d.migrations...),
+	}
+	for _, file := range files {
+		content := file.content
+		prepare := func(migration *Migration) {
+			migration.ExecSql(content)
+		}
+		if staging.isMigrationRegistered(file.version, file.description, prepare) {
+			continue
+		}
+		if err := staging.AddMigration(file.version, file.description, prepare); err != nil {
+			return err
+		}
+	}
+	d.migrations = staging.migrations
+	return nil
+}
+
+// isMigrationRegistered checks if a migration with the same version (or description, for repeatable migrations),
+// description and checksum is already registered
+func (d *Database) isMigrationRegistered(version, description string, prepare MigrationPrepare) bool {
+	candidate := &Migration{
+		Prepare: prepare,
+		Repeat:  version == "R",
+		Info:    &MigrationInfo{Version: version, Description: description},
+	}
+	for _, m := range d.migrations {
+		if m.Repeat != candidate.Repeat || m.Info.Version != version || m.Info.Description != description {
+			continue
+		}
+		m.prepare()
+		candidate.prepare()
+		return m.checksumMatches(candidate.Info.Checksum)
+	}
+	return false
+}
+
+// MigrationSource is a migration defined in memory, see AddMigrationsFromSlice
+type MigrationSource struct {
+	Version     string
+	Description string
+	SQL         string
+}
+
+// AddMigrationsFromDir automatically registers all migration files in a directory path.
+func (d *Database) AddMigrationsFromDir(dir string) error {
+	return d.AddMigrations(os.DirFS(dir))
+}
+
+// AddMigrationsFromSlice registers the migrations defined in memory.
+func (d *Database) AddMigrationsFromSlice(sources []MigrationSource) error {
+	for _, source := range sources {
+		content := source.SQL
+		err := d.AddMigration(source.Version, source.Description, func(migration *Migration) {
+			migration.ExecSql(content)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddMigrationReader registers a migration whose SQL is read from r, e.g. the body of an HTTP response or of an object
+// storage download. The full SQL is read before registering, the checksum is the same of an equivalent migration file.
+func (d *Database) AddMigrationReader(version, description string, r io.Reader) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to read migration v%s (cause: %s)", version, err.Error()))
+	}
+	if strings.TrimSpace(string(content)) == "" {
+		return errors.New(fmt.Sprintf("migration v%s has an empty body", version))
+	}
+
+	return d.AddMigration(version, description, func(migration *Migration) {
+		migration.ExecSql(string(content))
+	})
+}
+
+// MigrationOptions options of the registration of the migrations, see Database.SetMigrationOptions
+type MigrationOptions struct {
+	// VersionComparator compares two migration versions (defaults SemverComparator). Registering a version equal to
+	// a registered one is an error. Also used by Migrate when MigrationConfig.VersionComparator is not set.
+	VersionComparator func(a, b string) int
+
+	// VersionValidator checks whether a migration version is valid (defaults SemverValidator), when registering the
+	// migration. Also used by Migrate when MigrationConfig.VersionValidator is not set.
+	VersionValidator func(version string) bool
+
+	// StrictDescription AddMigration fails when a description exceeds the 200 characters of the history table,
+	// instead of truncating it with a warning
+	StrictDescription bool
+}
+
+// SetMigrationOptions sets the options of the registration of the migrations (AddMigration, AddMigrations, ...), it
+// must be called before registering them. Ex.
+//
+//	db.SetMigrationOptions(pg.MigrationOptions{VersionComparator: pg.NumericComparator, VersionValidator: pg.NumericValidator})
+func (d *Database) SetMigrationOptions(options MigrationOptions) {
+	d.migOptions = options
+}
+
+// compareVersions compares two versions with MigrationOptions.VersionComparator
+func (d *Database) compareVersions(a, b string) int {
+	if d.migOptions.VersionComparator == nil {
+		return SemverComparator(a, b)
+	}
+	return d.migOptions.VersionComparator(a, b)
+}
+
+// validVersion checks the version with MigrationOptions.VersionValidator, repeatable migrations have the version R
+func (d *Database) validVersion(version string) bool {
+	if version == "R" {
+		return true
+	}
+	if d.migOptions.VersionValidator == nil {
+		return SemverValidator(version)
+	}
+	return d.migOptions.VersionValidator(version)
+}
+
+// maxDescriptionLen the length of the description column of the history table
+const maxDescriptionLen = 200
+
 // AddMigration register a new migration
+//
+// The version format is validated with MigrationOptions.VersionValidator (see SetMigrationOptions). Descriptions
+// longer than 200 characters are truncated, with a warning, before checking for duplicates (see
+// MigrationOptions.StrictDescription).
 func (d *Database) AddMigration(version, description string, prepare MigrationPrepare) error {
 
-	if version != "R" {
-		if valid := semver.IsValid("v" + version); !valid {
-			return errors.New(fmt.Sprintf("migration has a invalid semantic version (%s)", version))
-		}
+	if !d.validVersion(version) {
+		return errors.New(fmt.Sprintf("migration has a invalid version (%s)", version))
 	}
 
-	if dl := len(description); dl == 0 {
+	if len(description) == 0 {
 		return errors.New(fmt.Sprintf("migration description is required (v%s)", version))
-	} else if dl > 200 {
-		description = string([]rune(description)[:200])
+	} else if runes := []rune(description); len(runes) > maxDescriptionLen {
+		if d.migOptions.StrictDescription {
+			return errors.New(fmt.Sprintf(
+				"migration description exceeds %d characters (v%s): %s", maxDescriptionLen, version, description,
+			))
+		}
+		// VARCHAR(200) counts code points, as runes. The cut may split a grapheme cluster (e.g. an emoji with skin
+		// tone), but the same cut is always used for the stored and the compared description.
+		description = string(runes[:maxDescriptionLen])
+		if d.logger != nil {
+			d.logger.Warn("Migration description truncated to %d characters (v%s): %s", maxDescriptionLen, version, description)
+		}
 	}
 
 	migration := &Migration{
@@ -136,9 +525,17 @@ func (d *Database) AddMigration(version, description string, prepare MigrationPr
 		},
 	}
 
-	// check for duplicated version
-	for _, m := range d.migrations {
-		if m.Info.Version == version {
+	for i, m := range d.migrations {
+		if migration.Repeat {
+			// repeatable migrations are identified by description, the offenders differ only by registration order
+			if m.Repeat && m.Info.Description == migration.Info.Description {
+				return errors.New(fmt.Sprintf(
+					"found more than one repeatable migration with description %s\nOffenders:\n-> registration %d\n-> registration %d",
+					description, i+1, len(d.migrations)+1,
+				))
+			}
+		} else if !m.Repeat && d.compareVersions(m.Info.Version, version) == 0 {
+			// check for duplicated version
 			return errors.New(fmt.Sprintf(
 				"found more than one migration with version %s\nOffenders:\n-> %s\n-> %s",
 				version, m.Info.Description, migration.Info.Description,
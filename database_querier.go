@@ -0,0 +1,22 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Querier is the set of statement-running operations implemented by Database.
+//
+// Business logic that depends on Querier instead of *Database can be unit tested with a fake, without a PostgreSQL
+// server. To test code that depends on *Database, see OpenDB.
+type Querier interface {
+	Execute(query string, args ...interface{}) (sql.Result, error)
+	ExecuteContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) (*sql.Row, error)
+	QueryForBoolean(query string, args ...interface{}) (bool, error)
+	QueryForInt(query string, args ...interface{}) (int64, error)
+	QueryMaps(query string, args ...interface{}) ([]map[string]interface{}, error)
+}
+
+var _ Querier = (*Database)(nil)
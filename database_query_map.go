@@ -0,0 +1,116 @@
+package pg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// QueryMap executes a query and returns the rows indexed by the value of the keyCol column, e.g. an id -> row lookup
+// table.
+//
+//	users, err := pg.QueryMap[int64, User](db, "id", "SELECT id, name, email FROM users WHERE active")
+//
+// When V is a struct, each column is scanned into the field with the same column name, as QueryRowStruct (the key
+// column is also set in its field, when mapped). Otherwise the query must return the key column and one value column.
+// A duplicated key is an error, see QueryMapLastWins. When the query returns no rows, an empty (non-nil) map is
+// returned.
+func QueryMap[K comparable, V any](db *Database, keyCol string, query string, args ...interface{}) (map[K]V, error) {
+	return queryMap[K, V](db, keyCol, false, query, args...)
+}
+
+// QueryMapLastWins works like QueryMap, but a duplicated key is replaced by the last row with the key (use ORDER BY to
+// choose which one wins)
+func QueryMapLastWins[K comparable, V any](db *Database, keyCol string, query string, args ...interface{}) (map[K]V, error) {
+	return queryMap[K, V](db, keyCol, true, query, args...)
+}
+
+func queryMap[K comparable, V any](db *Database, keyCol string, lastWins bool, query string, args ...interface{}) (map[K]V, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var key K
+	var value V
+	valueType := reflect.TypeOf(&value).Elem()
+	_, scanner := any(&value).(sql.Scanner)
+	mapStruct := valueType.Kind() == reflect.Struct && valueType != timeType && !scanner
+
+	keyIndex := -1
+	var keyField []int
+	scan := make([]interface{}, len(names))
+	if mapStruct {
+		fields := map[string][]int{}
+		for _, column := range structColumns(valueType) {
+			fields[column.name] = column.index
+		}
+		target := reflect.ValueOf(&value).Elem()
+		for i, name := range names {
+			index, exists := fields[name]
+			if name == keyCol {
+				keyIndex = i
+				scan[i] = &key
+				if exists {
+					keyField = index
+					if !reflect.TypeOf(&key).Elem().AssignableTo(target.FieldByIndex(index).Type()) {
+						return nil, errors.New(fmt.Sprintf(
+							"key column %s (%T) is not assignable to the field of %s", keyCol, key, valueType.String(),
+						))
+					}
+				}
+				continue
+			}
+			if !exists {
+				return nil, errors.New(fmt.Sprintf("column %s of the query is not mapped to a field of %s", name, valueType.String()))
+			}
+			scan[i] = target.FieldByIndex(index).Addr().Interface()
+		}
+	} else {
+		if len(names) != 2 {
+			return nil, errors.New(fmt.Sprintf(
+				"query must return the key column %s and one value column for %s, got %d columns", keyCol, valueType.String(), len(names),
+			))
+		}
+		for i, name := range names {
+			if name == keyCol && keyIndex < 0 {
+				keyIndex = i
+				scan[i] = &key
+			} else {
+				scan[i] = &value
+			}
+		}
+	}
+	if keyIndex < 0 {
+		return nil, errors.New(fmt.Sprintf("key column %s is not returned by the query", keyCol))
+	}
+
+	result := map[K]V{}
+	for rows.Next() {
+		var zero V
+		value = zero
+		if err = rows.Scan(scan...); err != nil {
+			return nil, err
+		}
+		if keyField != nil {
+			reflect.ValueOf(&value).Elem().FieldByIndex(keyField).Set(reflect.ValueOf(&key).Elem())
+		}
+		if _, exists := result[key]; exists && !lastWins {
+			return nil, errors.New(fmt.Sprintf("duplicated key %v in column %s, see QueryMapLastWins", key, keyCol))
+		}
+		result[key] = value
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
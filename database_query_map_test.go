@@ -0,0 +1,69 @@
+package pg
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type queryMapUser struct {
+	Id    int64
+	Name  string `pg:"full_name"`
+	Email string
+}
+
+func TestQueryMap(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	log.result("SELECT users", []string{"id", "full_name", "email"},
+		[]driver.Value{int64(1), "alice", "alice@example.com"},
+		[]driver.Value{int64(2), "bob", "bob@example.com"},
+	)
+	log.result("SELECT duplicated", []string{"id", "full_name"},
+		[]driver.Value{int64(1), "alice"},
+		[]driver.Value{int64(1), "alice smith"},
+	)
+	log.result("SELECT names", []string{"full_name", "id"}, []driver.Value{"alice", int64(1)}, []driver.Value{"bob", int64(2)})
+	log.result("SELECT none", []string{"id", "full_name"})
+
+	users, err := QueryMap[int64, queryMapUser](db, "id", "SELECT users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]queryMapUser{
+		1: {Id: 1, Name: "alice", Email: "alice@example.com"},
+		2: {Id: 2, Name: "bob", Email: "bob@example.com"},
+	}
+	if !reflect.DeepEqual(users, want) {
+		t.Errorf("QueryMap() = %+v, want %+v", users, want)
+	}
+
+	if _, err = QueryMap[int64, queryMapUser](db, "id", "SELECT duplicated"); err == nil || !strings.Contains(err.Error(), "duplicated key 1") {
+		t.Errorf("expected duplicated key error, got %v", err)
+	}
+	users, err = QueryMapLastWins[int64, queryMapUser](db, "id", "SELECT duplicated")
+	if err != nil || !reflect.DeepEqual(users, map[int64]queryMapUser{1: {Id: 1, Name: "alice smith"}}) {
+		t.Errorf("QueryMapLastWins() = %+v, %v", users, err)
+	}
+
+	// single column value
+	ids, err := QueryMap[string, int64](db, "full_name", "SELECT names")
+	if err != nil || !reflect.DeepEqual(ids, map[string]int64{"alice": 1, "bob": 2}) {
+		t.Errorf("QueryMap() = %v, %v", ids, err)
+	}
+
+	if empty, err := QueryMap[int64, queryMapUser](db, "id", "SELECT none"); err != nil || empty == nil || len(empty) != 0 {
+		t.Errorf("expected empty map, got %v, %v", empty, err)
+	}
+
+	for _, invalid := range []func() error{
+		func() error { _, err := QueryMap[int64, queryMapUser](db, "user_id", "SELECT users"); return err },
+		func() error { _, err := QueryMap[string, queryMapUser](db, "id", "SELECT users"); return err },
+		func() error { _, err := QueryMap[int64, string](db, "id", "SELECT users"); return err },
+		func() error { _, err := QueryMap[int64, struct{ Id int64 }](db, "id", "SELECT users"); return err },
+	} {
+		if err = invalid(); err == nil {
+			t.Error("expected error")
+		}
+	}
+}
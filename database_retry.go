@@ -0,0 +1,34 @@
+package pg
+
+import (
+	"database/sql/driver"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// connectionLostCodes PostgreSQL errors raised when the backend of a pooled connection is gone
+var connectionLostCodes = map[pq.ErrorCode]bool{
+	"57P01": true, // admin_shutdown, the server was restarted or the backend terminated
+	"57P02": true, // crash_shutdown
+	"26000": true, // invalid_sql_statement_name, prepared statement does not exist after a failover
+}
+
+// retryConnectionLost reports whether the operation that failed with err can be executed again, once, on a fresh
+// connection.
+//
+// database/sql already retries driver.ErrBadConn before the statement is sent, but after a server restart or a
+// failover the first use of a stale connection fails with the errors above. Only operations on the pool are retried,
+// a transaction or a pinned connection (Conn) cannot be recovered.
+func (d *Database) retryConnectionLost(err error) bool {
+	if err == nil || d.tx != nil || d.conn != nil {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.Is(err, driver.ErrBadConn) || (errors.As(err, &pqErr) && connectionLostCodes[pqErr.Code]) {
+		d.logger.Warn("Connection lost, retrying on a new connection. cause: %v", err)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package pg
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+)
+
+// roleConnector runs SET ROLE once on each new connection of the pool, see Config.Role
+type roleConnector struct {
+	driver.Connector
+	role string
+}
+
+func (c *roleConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	execer, isExecer := conn.(driver.ExecerContext)
+	if !isExecer {
+		_ = conn.Close()
+		return nil, errors.New("the driver connection does not support SET ROLE")
+	}
+	if _, err = execer.ExecContext(ctx, "SET ROLE "+QuoteIdentifier(c.role), nil); err != nil {
+		_ = conn.Close()
+		return nil, errors.New("unable to set role " + c.role + " (cause: " + err.Error() + ")")
+	}
+	return conn, nil
+}
@@ -0,0 +1,47 @@
+package pg
+
+// runOnceTable table recording the keys executed by RunOnce, created on demand
+const runOnceTable = "pg_run_once"
+
+// RunOnce runs a one-off SQL script (e.g. an operational maintenance script) exactly once per database.
+//
+// The executed keys are recorded in the pg_run_once table, created on demand. When the key was already executed the
+// SQL is skipped and ran is false. Otherwise the SQL is executed and the key recorded in the same transaction, so a
+// failed script can be run again, and concurrent calls with the same key wait for each other (only one runs).
+func (d *Database) RunOnce(key, sql string) (ran bool, err error) {
+	_, err = d.Execute("CREATE TABLE IF NOT EXISTS " + QuoteIdentifier(runOnceTable) + " (" +
+		"key TEXT NOT NULL PRIMARY KEY, " +
+		"executed_on TIMESTAMP NOT NULL DEFAULT now()" +
+		")")
+	if err != nil {
+		return false, err
+	}
+
+	run := func(db *Database) error {
+		result, err := db.Execute(
+			"INSERT INTO "+QuoteIdentifier(runOnceTable)+" (key) VALUES ($1) ON CONFLICT DO NOTHING", key,
+		)
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+			// already executed
+			return err
+		}
+		if _, err = db.Execute(sql); err != nil {
+			return err
+		}
+		ran = true
+		return nil
+	}
+
+	if d.tx != nil {
+		err = run(d)
+	} else {
+		err = d.Transaction(run)
+	}
+	if err != nil {
+		return false, err
+	}
+	return ran, nil
+}
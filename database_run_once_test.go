@@ -0,0 +1,40 @@
+package pg
+
+import (
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+func TestRunOnce(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		if _, err := db.Execute("CREATE TABLE run_once_counter (value INT); INSERT INTO run_once_counter VALUES (0)"); err != nil {
+			t.Fatal(err)
+		}
+
+		for i, want := range []bool{true, false} {
+			ran, err := db.RunOnce("2024-01-fix-counter", "UPDATE run_once_counter SET value = value + 1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ran != want {
+				t.Errorf("call %d: ran = %v, want %v", i+1, ran, want)
+			}
+		}
+
+		// a failed script is not recorded
+		if ran, err := db.RunOnce("broken", "UPDATE missing_table SET value = 1"); err == nil || ran {
+			t.Errorf("expected error, got %v, %v", ran, err)
+		}
+		if ran, err := db.RunOnce("broken", "UPDATE run_once_counter SET value = value + 10"); err != nil || !ran {
+			t.Errorf("expected failed key to run again, got %v, %v", ran, err)
+		}
+
+		if value, err := db.QueryForInt("SELECT value FROM run_once_counter"); err != nil || value != 11 {
+			t.Errorf("expected counter 11, got %d, %v", value, err)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrShuttingDown = errors.New("database is shutting down")
+
+// poolState tracks the connections and transactions handed out by a Database, shared by all derived instances
+type poolState struct {
+	mu           sync.Mutex
+	shuttingDown bool
+	inFlight     int
+	drained      chan struct{} // closed when inFlight reaches zero during shutdown
+	version      int           // server_version_num, cached by ServerVersion
+}
+
+// acquire registers a new connection or transaction, failing when the database is shutting down
+func (p *poolState) acquire() error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.shuttingDown {
+		return ErrShuttingDown
+	}
+	p.inFlight++
+	return nil
+}
+
+// release unregisters a connection or transaction
+func (p *poolState) release() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight--
+	if p.inFlight == 0 && p.drained != nil {
+		close(p.drained)
+		p.drained = nil
+	}
+}
+
+// track registers a new connection or transaction for this instance
+func (d *Database) track() error {
+	if err := d.pool.acquire(); err != nil {
+		return err
+	}
+	d.tracked = d.pool != nil
+	return nil
+}
+
+// untrack unregisters the connection or transaction of this instance, only once
+func (d *Database) untrack() {
+	if d.tracked {
+		d.tracked = false
+		d.pool.release()
+	}
+}
+
+// Shutdown gracefully closes the database.
+//
+// New connections and transactions (Conn, Begin) are rejected with ErrShuttingDown. Shutdown waits for the
+// connections and transactions already handed out to be released (CloseConn, Commit, Rollback), up to the ctx
+// deadline, then closes the pool. When the deadline is reached, the pool is closed anyway and ctx.Err() is returned.
+func (d *Database) Shutdown(ctx context.Context) error {
+	var drained chan struct{}
+
+	if p := d.pool; p != nil {
+		p.mu.Lock()
+		p.shuttingDown = true
+		if p.inFlight > 0 {
+			if p.drained == nil {
+				p.drained = make(chan struct{})
+			}
+			drained = p.drained
+		}
+		p.mu.Unlock()
+	}
+
+	var err error
+	if drained != nil {
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			err = ctx.Err()
+			d.logger.Warn("Shutdown deadline reached with connections or transactions still in use")
+		}
+	}
+
+	return errors.Join(err, d.Close())
+}
@@ -0,0 +1,45 @@
+package pg
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// WatchStats reports the statistics of the connection pool (see Stats) to fn every interval, in a background
+// goroutine, e.g. to log or export the open, in use and idle connections and the wait count when diagnosing
+// "too many connections" or connection churn (compare MaxIdleClosed and MaxLifetimeClosed between reports).
+//
+// The returned stop function stops the watcher, it waits for a running fn to return and can be called more than once.
+// As it waits for fn, calling stop from fn deadlocks, signal another goroutine to call it instead. Panics if interval
+// is not positive.
+func (d *Database) WatchStats(interval time.Duration, fn func(stats sql.DBStats)) (stop func()) {
+	if interval <= 0 {
+		panic("pg: non-positive interval for WatchStats")
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				fn(d.db.Stats())
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+		})
+		<-stopped
+	}
+}
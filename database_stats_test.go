@@ -0,0 +1,62 @@
+package pg
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestWatchStats(t *testing.T) {
+	db, _ := openFakeDatabase(t)
+
+	reports := make(chan sql.DBStats, 100)
+	stop := db.WatchStats(5*time.Millisecond, func(stats sql.DBStats) {
+		select {
+		case reports <- stats:
+		default:
+		}
+	})
+
+	waitFor := func(inUse int) {
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case stats := <-reports:
+				if stats.InUse == inUse {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no report with %d connections in use", inUse)
+			}
+		}
+	}
+
+	conn, err := db.Conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(1)
+	if err = conn.CloseConn(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(0)
+
+	stop()
+	stop()
+	for len(reports) > 0 {
+		<-reports
+	}
+	time.Sleep(20 * time.Millisecond)
+	if len(reports) != 0 {
+		t.Error("expected no report after stop")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic for a non-positive interval")
+			}
+		}()
+		db.WatchStats(0, func(stats sql.DBStats) {})
+	}()
+}
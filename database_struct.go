@@ -0,0 +1,52 @@
+package pg
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// QueryRowStruct executes a query that returns at most one row and scans it into dest, a pointer to a struct.
+//
+// Each column of the result is scanned into the field with the same column name, see Table for the mapping of the
+// fields (`pg:"name"` tag or the snake_case field name). Fields without a column keep their value, a column without a
+// field is an error. Returns found=false, without error, when the query returns no rows.
+func (d *Database) QueryRowStruct(dest interface{}, query string, args ...interface{}) (found bool, err error) {
+	value := reflect.ValueOf(dest)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return false, fmt.Errorf("%w: %T, expected a pointer to a struct", ErrUnsupportedDataType, dest)
+	}
+	value = value.Elem()
+
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return false, err
+	}
+
+	fields := map[string][]int{}
+	for _, column := range structColumns(value.Type()) {
+		fields[column.name] = column.index
+	}
+	scan := make([]interface{}, len(names))
+	for i, name := range names {
+		index, exists := fields[name]
+		if !exists {
+			return false, errors.New(fmt.Sprintf("column %s of the query is not mapped to a field of %s", name, value.Type().String()))
+		}
+		scan[i] = value.FieldByIndex(index).Addr().Interface()
+	}
+
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+	if err = rows.Scan(scan...); err != nil {
+		return false, err
+	}
+	return true, rows.Err()
+}
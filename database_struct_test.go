@@ -0,0 +1,52 @@
+package pg
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type structMember struct {
+	TenantId string `pg:"tenant_id"`
+	Id       int64
+	Name     string `pg:"full_name"`
+	Note     string `pg:"-"`
+}
+
+func TestQueryRowStruct(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	log.result("SELECT member", []string{"tenant_id", "id", "full_name"}, []driver.Value{"t1", int64(7), "alice"})
+	log.result("SELECT partial", []string{"id"}, []driver.Value{int64(8)})
+	log.result("SELECT none", []string{"id"})
+	log.result("SELECT extra", []string{"id", "note"}, []driver.Value{int64(9), "unmapped"})
+
+	var member structMember
+	found, err := db.QueryRowStruct(&member, "SELECT member")
+	if err != nil || !found {
+		t.Fatalf("expected a row, got %v, %v", found, err)
+	}
+	if member != (structMember{TenantId: "t1", Id: 7, Name: "alice"}) {
+		t.Errorf("unexpected member %+v", member)
+	}
+
+	// fields without column keep their value
+	found, err = db.QueryRowStruct(&member, "SELECT partial")
+	if err != nil || !found || member != (structMember{TenantId: "t1", Id: 8, Name: "alice"}) {
+		t.Errorf("unexpected result %v, %v, %+v", found, err, member)
+	}
+
+	found, err = db.QueryRowStruct(&member, "SELECT none")
+	if err != nil || found {
+		t.Errorf("expected not found without error, got %v, %v", found, err)
+	}
+
+	_, err = db.QueryRowStruct(&member, "SELECT extra")
+	if err == nil || !strings.Contains(err.Error(), "column note of the query is not mapped to a field of pg.structMember") {
+		t.Errorf("expected unmapped column error, got %v", err)
+	}
+
+	if _, err = db.QueryRowStruct(member, "SELECT member"); !errors.Is(err, ErrUnsupportedDataType) {
+		t.Errorf("expected ErrUnsupportedDataType, got %v", err)
+	}
+}
@@ -0,0 +1,784 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func init() {
+	sql.Register("pgfake", &fakeDriver{})
+}
+
+// fakeDriver is a minimal driver that records the executed statements, used for tests that do not need a server
+type fakeDriver struct{}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	return &fakeConn{log: fakeLogs.get(name)}, nil
+}
+
+type fakeLog struct {
+	mu         sync.Mutex
+	statements []string
+	results    map[string]*fakeRows
+	failures   map[string]error
+	once       map[string]bool // failures returned only once
+}
+
+// result configures the rows returned by the query
+func (l *fakeLog) result(query string, columns []string, rows ...[]driver.Value) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.results == nil {
+		l.results = map[string]*fakeRows{}
+	}
+	l.results[query] = &fakeRows{columns: columns, rows: rows}
+}
+
+// resultTypes configures the database type names of the columns of the result of the query
+func (l *fakeLog) resultTypes(query string, types ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.results[query].types = types
+}
+
+// fail configures the error returned when executing the statement
+func (l *fakeLog) fail(query string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failures == nil {
+		l.failures = map[string]error{}
+	}
+	l.failures[query] = err
+}
+
+// failOnce configures the error returned by the next execution of the statement
+func (l *fakeLog) failOnce(query string, err error) {
+	l.fail(query, err)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.once == nil {
+		l.once = map[string]bool{}
+	}
+	l.once[query] = true
+}
+
+func (l *fakeLog) failure(query string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.failures[query]
+	if l.once[query] {
+		delete(l.failures, query)
+		delete(l.once, query)
+	}
+	return err
+}
+
+func (l *fakeLog) rows(query string) *fakeRows {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if result, ok := l.results[query]; ok {
+		return &fakeRows{columns: result.columns, types: result.types, rows: result.rows}
+	}
+	return &fakeRows{}
+}
+
+func (l *fakeLog) add(statement string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.statements = append(l.statements, statement)
+}
+
+func (l *fakeLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.statements, ";")
+}
+
+type fakeLogRegistry struct {
+	mu   sync.Mutex
+	logs map[string]*fakeLog
+}
+
+var fakeLogs = &fakeLogRegistry{logs: map[string]*fakeLog{}}
+
+func (r *fakeLogRegistry) get(name string) *fakeLog {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.logs[name] == nil {
+		r.logs[name] = &fakeLog{}
+	}
+	return r.logs[name]
+}
+
+type fakeConn struct {
+	log *fakeLog
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.ReadOnly {
+		c.log.add("BEGIN READ ONLY")
+	} else {
+		c.log.add("BEGIN")
+	}
+	return &fakeTx{conn: c}, nil
+}
+
+type fakeTx struct {
+	conn *fakeConn
+}
+
+func (t *fakeTx) Commit() error {
+	t.conn.log.add("COMMIT")
+	return nil
+}
+
+func (t *fakeTx) Rollback() error {
+	t.conn.log.add("ROLLBACK")
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.conn.log.add(s.query)
+	if strings.HasPrefix(s.query, "FAIL") {
+		return nil, errors.New("fake failure")
+	}
+	if err := s.conn.log.failure(s.query); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.conn.log.add(s.query)
+	if err := s.conn.log.failure(s.query); err != nil {
+		return nil, err
+	}
+	return s.conn.log.rows(s.query), nil
+}
+
+type fakeRows struct {
+	columns []string
+	types   []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(index int) string {
+	if index < len(r.types) {
+		return r.types[index]
+	}
+	return ""
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// openFakeDatabase opens a Database backed by the fakeDriver. The returned log records the executed statements.
+func openFakeDatabase(t *testing.T) (*Database, *fakeLog) {
+	sqlDb, err := sql.Open("pgfake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := OpenDB(sqlDb, &Config{Database: t.Name()})
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db, fakeLogs.get(t.Name())
+}
+
+func TestTransactionHooks(t *testing.T) {
+	db, _ := openFakeDatabase(t)
+
+	var calls []string
+
+	err := db.Transaction(func(tx *Database) error {
+		tx.OnCommit(func() { calls = append(calls, "commit") })
+		tx.OnRollback(func() { calls = append(calls, "rollback") })
+		return tx.Savepoint("sp", func() error {
+			return errors.New("savepoint failure")
+		})
+	})
+	if err == nil {
+		t.Fatal("expected transaction error")
+	}
+	if strings.Join(calls, ",") != "rollback" {
+		t.Errorf("unexpected hook calls %v", calls)
+	}
+
+	calls = nil
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.OnCommit(func() { calls = append(calls, "commit") })
+	tx.OnRollback(func() { calls = append(calls, "rollback") })
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(calls, ",") != "commit" {
+		t.Errorf("unexpected hook calls %v", calls)
+	}
+
+	calls = nil
+	db.OnCommit(func() { calls = append(calls, "immediate") })
+	db.OnRollback(func() { calls = append(calls, "discarded") })
+	if strings.Join(calls, ",") != "immediate" {
+		t.Errorf("unexpected hook calls %v", calls)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	ro := db.ReadOnly()
+
+	writes := map[string]func() error{
+		"Execute": func() error {
+			_, err := ro.Execute("INSERT INTO t VALUES (1)")
+			return err
+		},
+		"InsertInto": func() error {
+			_, err := ro.InsertInto("public", "t", map[string]interface{}{"id": 1})
+			return err
+		},
+		"Update": func() error {
+			_, err := ro.Update("public", "t", map[string]interface{}{"id": 1}, map[string]interface{}{"id": 2})
+			return err
+		},
+		"DeleteWhere": func() error {
+			_, err := ro.DeleteWhere("t", map[string]interface{}{"id": 1})
+			return err
+		},
+		"Upsert": func() error {
+			_, err := ro.Upsert("t", map[string]interface{}{"id": 1}, "id")
+			return err
+		},
+		"Begin": func() error {
+			_, err := ro.Begin()
+			return err
+		},
+		"Conn.Execute": func() error {
+			conn, err := ro.Conn()
+			if err != nil {
+				return err
+			}
+			defer conn.CloseConn()
+			_, err = conn.Execute("INSERT INTO t VALUES (1)")
+			return err
+		},
+	}
+
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s: expected ErrReadOnly, got %v", name, err)
+		}
+	}
+
+	if log.String() != "" {
+		t.Errorf("no statement should be executed, got %s", log.String())
+	}
+
+	if _, err := ro.Query("SELECT 1"); err != nil {
+		t.Errorf("Query: unexpected error %v", err)
+	}
+
+	tx, err := ro.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("BeginTx read-only: unexpected error %v", err)
+	}
+	_ = tx.Rollback()
+}
+
+func TestReadOnlyTransaction(t *testing.T) {
+	db, _ := openFakeDatabase(t)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ro := tx.ReadOnly()
+	if same, err := ro.Begin(); err != nil || same != ro {
+		t.Errorf("Begin: expected the read-only transaction, got %v, %v", same, err)
+	}
+
+	var events []string
+	ro.OnCommit(func() { events = append(events, "ro commit") })
+	tx.OnCommit(func() { events = append(events, "tx commit") })
+	ro.OnRollback(func() { events = append(events, "ro rollback") })
+	if len(events) != 0 {
+		t.Errorf("expected the callbacks deferred to the end of the transaction, got %v", events)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "ro commit,tx commit"; strings.Join(events, ",") != want {
+		t.Errorf("got %v, want %s", events, want)
+	}
+
+	events = nil
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.ReadOnly().OnRollback(func() { events = append(events, "ro rollback") })
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "ro rollback"; strings.Join(events, ",") != want {
+		t.Errorf("got %v, want %s", events, want)
+	}
+
+	// ending the transaction through the copy releases the connection acquired by BeginTx
+	db.config.AcquireTimeout = time.Second
+	if tx, err = db.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	if inUse := db.Stats().InUse; inUse != 1 {
+		t.Fatalf("expected the connection of the transaction in use, got %d", inUse)
+	}
+	if err = tx.ReadOnly().Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if inUse := db.Stats().InUse; inUse != 0 {
+		t.Errorf("expected the connection released, got %d in use", inUse)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Errorf("expected Rollback after the commit to be a no-op, got %v", err)
+	}
+
+	conn, err := db.Conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.WithTimestamps().CloseConn(); err != nil {
+		t.Fatal(err)
+	}
+	if inUse := db.Stats().InUse; inUse != 0 {
+		t.Errorf("expected the connection released, got %d in use", inUse)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = db.Shutdown(ctx); err != nil {
+		t.Errorf("expected no transaction or connection in flight, got %v", err)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	db, _ := openFakeDatabase(t)
+
+	if _, ok := FromContext(context.Background()); ok {
+		t.Error("expected no database in an empty context")
+	}
+
+	err := db.Transaction(func(tx *Database) error {
+		ctx := tx.WithContext(context.Background())
+		if fromCtx, ok := FromContext(ctx); !ok || fromCtx != tx {
+			t.Error("expected the transaction-bound database in the context")
+		}
+
+		table, err := NewTable("public", "users", UserModel{})
+		if err != nil {
+			return err
+		}
+		if got, _ := table.UsingContext(ctx).getDb(); got != tx {
+			t.Error("expected Table to use the transaction-bound database")
+		}
+		if query := NewQuery("SELECT 1", nil).WithContext(ctx); query.db != tx {
+			t.Error("expected Query to use the transaction-bound database")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryForFound(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	log.result("SELECT zero", []string{"value"}, []driver.Value{int64(0)})
+	log.result("SELECT false", []string{"value"}, []driver.Value{false})
+	log.result("SELECT none", []string{"value"})
+
+	if value, found, err := db.QueryForIntFound("SELECT zero"); err != nil || !found || value != 0 {
+		t.Errorf("QueryForIntFound(zero) = %d, %v, %v", value, found, err)
+	}
+	if value, found, err := db.QueryForIntFound("SELECT none"); err != nil || found || value != 0 {
+		t.Errorf("QueryForIntFound(none) = %d, %v, %v", value, found, err)
+	}
+	if value, found, err := db.QueryForBooleanFound("SELECT false"); err != nil || !found || value {
+		t.Errorf("QueryForBooleanFound(false) = %v, %v, %v", value, found, err)
+	}
+	if value, found, err := db.QueryForBooleanFound("SELECT none"); err != nil || found || value {
+		t.Errorf("QueryForBooleanFound(none) = %v, %v, %v", value, found, err)
+	}
+
+	// original behavior
+	if value, err := db.QueryForInt("SELECT none"); err != nil || value != 0 {
+		t.Errorf("QueryForInt(none) = %d, %v", value, err)
+	}
+	if _, err := db.QueryForBoolean("SELECT none"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("QueryForBoolean(none) expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestSelectRowWhereContext(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	log.result(`SELECT "name" FROM "users" WHERE "id" = $1`, []string{"name"}, []driver.Value{"alice"})
+
+	var name string
+	err := db.SelectRowWhereContext(context.Background(), "users", map[string]interface{}{"name": &name}, map[string]interface{}{"id": 1})
+	if err != nil || name != "alice" {
+		t.Errorf("SelectRowWhereContext = %s, %v", name, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.SelectRowWhereContext(ctx, "users", map[string]interface{}{"name": &name}, map[string]interface{}{"id": 1})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if err = db.QueryRowCtx(ctx, "SELECT 1").Scan(&name); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestInTransaction(t *testing.T) {
+	db, log := openFakeDatabase(t)
+
+	id, err := InTransaction(db, func(tx *Database) (int64, error) {
+		if _, err := tx.Execute("INSERT INTO users DEFAULT VALUES"); err != nil {
+			return 0, err
+		}
+		return 42, nil
+	})
+	if err != nil || id != 42 {
+		t.Errorf("InTransaction() = %d, %v", id, err)
+	}
+
+	name, err := InTransaction(db, func(tx *Database) (string, error) {
+		_, err := tx.Execute("FAIL")
+		return "partial", err
+	})
+	if err == nil || name != "" {
+		t.Errorf("expected error and zero value, got %q, %v", name, err)
+	}
+
+	if _, err = InTransaction(db, func(tx *Database) (int, error) {
+		panic("boom")
+	}); err == nil {
+		t.Error("expected error on panic")
+	}
+
+	want := "BEGIN;INSERT INTO users DEFAULT VALUES;COMMIT;BEGIN;FAIL;ROLLBACK;BEGIN;ROLLBACK"
+	if got := log.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestDeferConstraints(t *testing.T) {
+	db, log := openFakeDatabase(t)
+
+	if err := db.DeferConstraints(); !errors.Is(err, ErrNotInTransaction) {
+		t.Errorf("expected ErrNotInTransaction, got %v", err)
+	}
+
+	err := db.TransactionDeferred(func(tx *Database) error {
+		_, err := tx.Execute("INSERT INTO a DEFAULT VALUES")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := log.String(), "BEGIN;SET CONSTRAINTS ALL DEFERRED;INSERT INTO a DEFAULT VALUES;COMMIT"; got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestRetryConnectionLost(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	dropped := &pq.Error{Code: "57P01", Message: "terminating connection due to administrator command"}
+
+	log.failOnce("SELECT 1", dropped)
+	rows, err := db.Query("SELECT 1")
+	if err != nil {
+		t.Fatalf("expected query to be retried, got %v", err)
+	}
+	_ = rows.Close()
+
+	log.failOnce("UPDATE users SET active = true", &pq.Error{Code: "26000"})
+	if _, err = db.Execute("UPDATE users SET active = true"); err != nil {
+		t.Fatalf("expected exec to be retried, got %v", err)
+	}
+
+	// a single retry
+	log.fail("SELECT 2", dropped)
+	if _, err = db.Query("SELECT 2"); !errors.Is(err, dropped) {
+		t.Errorf("expected error after the retry, got %v", err)
+	}
+
+	// other errors and transactions are not retried
+	log.failOnce("SELECT 3", &pq.Error{Code: "42P01"})
+	if _, err = db.Query("SELECT 3"); err == nil {
+		t.Error("expected error")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.failOnce("SELECT 4", dropped)
+	if _, err = tx.Query("SELECT 4"); err == nil {
+		t.Error("expected error in transaction")
+	}
+	_ = tx.Rollback()
+
+	want := "SELECT 1;SELECT 1;UPDATE users SET active = true;UPDATE users SET active = true;SELECT 2;SELECT 2;" +
+		"SELECT 3;BEGIN;SELECT 4;ROLLBACK"
+	if got := log.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestCopyTo(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	log.result("SELECT * FROM users", []string{"id", "name", "bio"},
+		[]driver.Value{int64(1), "Alice", nil},
+		[]driver.Value{int64(2), "Bob, Jr.", "line\ttab\n\"quoted\""},
+		[]driver.Value{int64(3), "", `back\slash`},
+	)
+
+	var text strings.Builder
+	count, err := db.CopyTo(&text, "SELECT * FROM users", CopyFormatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1\tAlice\t\\N\n2\tBob, Jr.\tline\\ttab\\n\"quoted\"\n3\t\tback\\\\slash\n"
+	if count != 3 || text.String() != want {
+		t.Errorf("CopyTo(text) = %d %q, want %q", count, text.String(), want)
+	}
+
+	var csv strings.Builder
+	if _, err = db.CopyTo(&csv, "SELECT * FROM users", CopyFormatCsv); err != nil {
+		t.Fatal(err)
+	}
+	want = "1,Alice,\n2,\"Bob, Jr.\",\"line\ttab\n\"\"quoted\"\"\"\n3,\"\",back\\slash\n"
+	if csv.String() != want {
+		t.Errorf("CopyTo(csv) = %q, want %q", csv.String(), want)
+	}
+
+	if _, err = db.CopyTo(&csv, "SELECT * FROM users", "binary"); err == nil {
+		t.Error("expected error for unsupported format")
+	}
+
+	log.result("SELECT * FROM files", []string{"name", "content"}, []driver.Value{[]byte("a.txt"), []byte{0xde, 0xad, '\t'}})
+	log.resultTypes("SELECT * FROM files", "TEXT", "BYTEA")
+	text.Reset()
+	if _, err = db.CopyTo(&text, "SELECT * FROM files", CopyFormatText); err != nil {
+		t.Fatal(err)
+	}
+	if want = "a.txt\t\\\\xdead09\n"; text.String() != want {
+		t.Errorf("CopyTo(bytea) = %q, want %q", text.String(), want)
+	}
+
+	count, err = db.CopyTo(failingWriter{}, "SELECT * FROM users", CopyFormatText)
+	if count != 0 || err == nil || err.Error() != "write failed" {
+		t.Errorf("CopyTo(failing writer) = %d %v, want the write error", count, err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestShutdown(t *testing.T) {
+	db, _ := openFakeDatabase(t)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- db.Shutdown(context.Background())
+	}()
+
+	// wait for shutdown to start
+	for {
+		var other *Database
+		if other, err = db.Conn(); errors.Is(err, ErrShuttingDown) {
+			break
+		} else if err == nil {
+			_ = other.CloseConn()
+		}
+	}
+	if _, err = db.Begin(); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Begin: expected ErrShuttingDown, got %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-done:
+		t.Fatalf("shutdown finished with a connection in use (%v)", err)
+	default:
+	}
+
+	if err = conn.CloseConn(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Errorf("Shutdown: unexpected error %v", err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	db, _ := openFakeDatabase(t)
+
+	if _, err := db.Begin(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.Shutdown(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestQueryColumn(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	log.result("SELECT id FROM users", []string{"id", "name"},
+		[]driver.Value{"a", "Alice"},
+		[]driver.Value{"b", "Bob"},
+	)
+	log.result("SELECT age FROM users", []string{"age"}, []driver.Value{int64(30)}, []driver.Value{int64(40)})
+
+	ids, err := QueryColumn[string](db, "SELECT id FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ids, ",") != "a,b" {
+		t.Errorf("unexpected ids %v", ids)
+	}
+
+	ages, err := QueryColumn[int64](db, "SELECT age FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ages) != 2 || ages[0] != 30 || ages[1] != 40 {
+		t.Errorf("unexpected ages %v", ages)
+	}
+
+	empty, err := QueryColumn[string](db, "SELECT id FROM none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty == nil || len(empty) != 0 {
+		t.Errorf("expected empty non-nil slice, got %#v", empty)
+	}
+}
+
+func TestCloseConn(t *testing.T) {
+	db, log := openFakeDatabase(t)
+
+	conn, err := db.Conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnly := conn.ReadOnly()
+	timestamps := conn.WithTimestamps()
+
+	if _, err = conn.Execute("SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.CloseConn(); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.CloseConn(); err != nil {
+		t.Errorf("expected double close to be a no-op, got %v", err)
+	}
+	// a copy sharing the closed connection
+	if err = timestamps.CloseConn(); err != nil {
+		t.Errorf("expected closing a copy to be a no-op, got %v", err)
+	}
+
+	if _, err = conn.Execute("SELECT 2"); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("Execute: expected ErrConnClosed, got %v", err)
+	}
+	if _, err = conn.Query("SELECT 3"); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("Query: expected ErrConnClosed, got %v", err)
+	}
+	if _, err = conn.Begin(); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("Begin: expected ErrConnClosed, got %v", err)
+	}
+	if _, err = readOnly.Query("SELECT 4"); !errors.Is(err, ErrConnClosed) || !errors.Is(err, sql.ErrConnDone) {
+		t.Errorf("copy: expected ErrConnClosed wrapping sql.ErrConnDone, got %v", err)
+	}
+	if _, err = conn.ReadOnly().Query("SELECT 5"); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("copy after close: expected ErrConnClosed, got %v", err)
+	}
+
+	if got := log.String(); got != "SELECT 1" {
+		t.Errorf("expected no statement after close, got %s", got)
+	}
+}
@@ -0,0 +1,55 @@
+package pg
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// ExecuteWithTimeout executes the query with a statement_timeout enforced by the server, even when the client side
+// cancellation of a context deadline (see ExecuteContext) lags or does not reach the server. The query is canceled by
+// the server with the SQLSTATE 57014 (query_canceled) when it runs longer than timeout. A timeout <= 0 executes the
+// query without timeout.
+//
+// Outside a transaction, a connection of the pool is pinned to run SET statement_timeout, the query and RESET
+// statement_timeout. When d is a connection (Conn), its connection is used: the RESET restores the default of the
+// session, not a previous SET. In a transaction, SET LOCAL is used, the timeout applies to the following statements
+// of the transaction too and the commit or rollback restores the previous setting.
+func (d *Database) ExecuteWithTimeout(timeout time.Duration, query string, args ...interface{}) (sql.Result, error) {
+	if d.readOnly {
+		return nil, ErrReadOnly
+	}
+	if timeout <= 0 {
+		return d.Execute(query, args...)
+	}
+
+	// milliseconds, rounded up (zero would disable the timeout)
+	ms := strconv.FormatInt(int64((timeout+time.Millisecond-1)/time.Millisecond), 10)
+
+	if d.tx != nil {
+		if _, err := d.Execute("SET LOCAL statement_timeout = " + ms); err != nil {
+			return nil, err
+		}
+		return d.Execute(query, args...)
+	}
+
+	conn := d
+	if d.conn == nil {
+		var err error
+		if conn, err = d.Conn(); err != nil {
+			return nil, err
+		}
+		defer conn.CloseConn()
+	}
+
+	if _, err := conn.Execute("SET statement_timeout = " + ms); err != nil {
+		return nil, err
+	}
+
+	result, err := conn.Execute(query, args...)
+
+	if _, errReset := conn.Execute("RESET statement_timeout"); errReset != nil && err == nil {
+		return nil, errReset
+	}
+	return result, err
+}
@@ -0,0 +1,86 @@
+package pg
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dhui/dktest"
+	"github.com/lib/pq"
+)
+
+func TestExecuteWithTimeoutStatements(t *testing.T) {
+	db, log := openFakeDatabase(t)
+
+	if _, err := db.ExecuteWithTimeout(1500*time.Millisecond, "UPDATE users SET active = false"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecuteWithTimeout(time.Microsecond, "FAIL"); err == nil {
+		t.Error("expected error")
+	}
+	err := db.Transaction(func(tx *Database) error {
+		_, err := tx.ExecuteWithTimeout(time.Second, "FAIL")
+		return err
+	})
+	if err == nil {
+		t.Error("expected error")
+	}
+	err = db.Transaction(func(tx *Database) error {
+		_, err := tx.ExecuteWithTimeout(time.Second, "UPDATE users SET active = true")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.ExecuteWithTimeout(0, "DELETE FROM users"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "SET statement_timeout = 1500;UPDATE users SET active = false;RESET statement_timeout;" +
+		"SET statement_timeout = 1;FAIL;RESET statement_timeout;" +
+		"BEGIN;SET LOCAL statement_timeout = 1000;FAIL;ROLLBACK;" +
+		"BEGIN;SET LOCAL statement_timeout = 1000;UPDATE users SET active = true;COMMIT;" +
+		"DELETE FROM users"
+	if got := log.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	if _, err = db.ReadOnly().ExecuteWithTimeout(time.Second, "UPDATE users SET active = false"); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+}
+
+func TestExecuteWithTimeout(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+		db.db.SetMaxOpenConns(1)
+
+		_, err := db.ExecuteWithTimeout(100*time.Millisecond, "SELECT pg_sleep(2)")
+		var pqErr *pq.Error
+		if !errors.As(err, &pqErr) || pqErr.Code != "57014" {
+			t.Fatalf("expected query_canceled, got %v", err)
+		}
+
+		if _, err = db.ExecuteWithTimeout(time.Second, "SELECT pg_sleep(0.1)"); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+
+		// the pooled connection is reset
+		var timeout string
+		if err = db.QueryRowOld("SHOW statement_timeout").Scan(&timeout); err != nil || timeout != "0" {
+			t.Errorf("expected the timeout to be reset, got %s, %v", timeout, err)
+		}
+
+		err = db.Transaction(func(tx *Database) error {
+			_, err := tx.ExecuteWithTimeout(100*time.Millisecond, "SELECT pg_sleep(2)")
+			return err
+		})
+		if !errors.As(err, &pqErr) || pqErr.Code != "57014" {
+			t.Fatalf("expected query_canceled in the transaction, got %v", err)
+		}
+		if err = db.QueryRowOld("SHOW statement_timeout").Scan(&timeout); err != nil || timeout != "0" {
+			t.Errorf("expected the timeout restored by the rollback, got %s, %v", timeout, err)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package pg
+
+// TimestampColumns names of the audit columns filled by a Database returned by WithTimestamps. An empty name disables
+// the column.
+type TimestampColumns struct {
+	CreatedAt string // set on InsertInto
+	UpdatedAt string // set on InsertInto, Update and BulkUpdate
+}
+
+// DefaultTimestampColumns columns used by WithTimestamps when none are informed
+var DefaultTimestampColumns = TimestampColumns{CreatedAt: "created_at", UpdatedAt: "updated_at"}
+
+// WithTimestamps returns a Database that fills the audit timestamps on InsertInto (created_at and updated_at), Update
+// and BulkUpdate (updated_at), when the values do not contain them.
+//
+// The other statements do not fill them, in particular Upsert, UpsertConditional, BulkInsert and BulkUpsertStruct:
+// inform the columns in the values (or in the SQL of Execute).
+//
+// The columns are set with the SQL now(), so they follow the database clock (and are the same for the whole
+// transaction). Connections and transactions obtained from the returned Database also fill the timestamps.
+func (d *Database) WithTimestamps(columns ...TimestampColumns) *Database {
+	timestamps := DefaultTimestampColumns
+	if len(columns) > 0 {
+		timestamps = columns[0]
+	}
+	db := d.clone(true)
+	db.timestamps = &timestamps
+	return db
+}
+
+// missingTimestamps returns the timestamp columns that must be set with now(), the ones not present in values
+func (d *Database) missingTimestamps(values map[string]interface{}, insert bool) []string {
+	if d.timestamps == nil {
+		return nil
+	}
+
+	var columns []string
+	if insert && d.timestamps.CreatedAt != "" {
+		if _, exist := values[d.timestamps.CreatedAt]; !exist {
+			columns = append(columns, d.timestamps.CreatedAt)
+		}
+	}
+	if d.timestamps.UpdatedAt != "" {
+		if _, exist := values[d.timestamps.UpdatedAt]; !exist {
+			columns = append(columns, d.timestamps.UpdatedAt)
+		}
+	}
+	return columns
+}
@@ -0,0 +1,74 @@
+package pg
+
+import (
+	"testing"
+)
+
+func TestWithTimestamps(t *testing.T) {
+	db, log := openFakeDatabase(t)
+
+	tsDb := db.WithTimestamps()
+	if _, err := tsDb.InsertInto("public", "users", map[string]interface{}{"name": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tsDb.Update("public", "users", map[string]interface{}{"name": "b"}, map[string]interface{}{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	// informed by the caller, kept
+	if _, err := tsDb.Update("public", "users", map[string]interface{}{"updated_at": nil}, map[string]interface{}{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	custom := db.WithTimestamps(TimestampColumns{UpdatedAt: "modified"})
+	if _, err := custom.InsertInto("public", "users", map[string]interface{}{"name": "c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// derived from a transaction
+	tx, err := tsDb.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Update("public", "users", map[string]interface{}{"name": "d"}, map[string]interface{}{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// without timestamps
+	if _, err = db.InsertInto("public", "users", map[string]interface{}{"name": "e"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `INSERT INTO "public"."users" ("name", "created_at", "updated_at") VALUES ($1, now(), now());` +
+		`UPDATE "public"."users" SET "name" = $1, "updated_at" = now() WHERE "id" = $2;` +
+		`UPDATE "public"."users" SET "updated_at" = $1 WHERE "id" = $2;` +
+		`INSERT INTO "public"."users" ("name", "modified") VALUES ($1, now());` +
+		`BEGIN;` +
+		`UPDATE "public"."users" SET "name" = $1, "updated_at" = now() WHERE "id" = $2;` +
+		`COMMIT;` +
+		`INSERT INTO "public"."users" ("name") VALUES ($1)`
+	if got := log.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestWithTimestampsUpsert(t *testing.T) {
+	db, log := openFakeDatabase(t)
+
+	// not filled by the upserts, see WithTimestamps
+	tsDb := db.WithTimestamps()
+	if _, err := tsDb.Upsert("users", map[string]interface{}{"id": 1, "name": "a"}, "id"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tsDb.UpsertConditional("public", "users", map[string]interface{}{"id": 1, "name": "b"}, []string{"id"}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `INSERT INTO "users" ("id", "name") VALUES ($1, $2) ON CONFLICT ("id") DO UPDATE SET "name" = $2;` +
+		`INSERT INTO "public"."users" ("id", "name") VALUES ($1, $2) ON CONFLICT ("id") DO UPDATE SET "name" = excluded."name"`
+	if got := log.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,51 @@
+package pg
+
+import "errors"
+
+// ErrNotInTransaction is returned by operations that are only valid inside a transaction
+var ErrNotInTransaction = errors.New("operation requires a transaction")
+
+// ErrInTransaction is returned by operations that can not run inside a transaction
+var ErrInTransaction = errors.New("operation can not run inside a transaction")
+
+// InTransaction executes the callback within a transaction (see Database.Transaction), returning the value computed
+// by the callback. The transaction is committed when the callback returns a nil error, and rolled back otherwise.
+//
+// On error, the zero value of T is returned.
+func InTransaction[T any](db *Database, callback func(db *Database) (T, error)) (T, error) {
+	var result T
+	err := db.Transaction(func(tx *Database) error {
+		var err error
+		result, err = callback(tx)
+		return err
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return result, nil
+}
+
+// DeferConstraints defers the checking of all deferrable constraints (SET CONSTRAINTS ALL DEFERRED) to the commit of
+// the current transaction, e.g. to load rows with circular foreign keys in any order. Constraints must be declared
+// DEFERRABLE, others are still checked immediately.
+//
+// Returns ErrNotInTransaction when called outside a transaction.
+func (d *Database) DeferConstraints() error {
+	if d.tx == nil {
+		return ErrNotInTransaction
+	}
+	_, err := d.Execute("SET CONSTRAINTS ALL DEFERRED")
+	return err
+}
+
+// TransactionDeferred executes the callback within a transaction (see Transaction) with all deferrable constraints
+// deferred to the commit (see DeferConstraints).
+func (d *Database) TransactionDeferred(callback func(db *Database) error) error {
+	return d.Transaction(func(db *Database) error {
+		if err := db.DeferConstraints(); err != nil {
+			return err
+		}
+		return callback(db)
+	})
+}
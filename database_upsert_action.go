@@ -0,0 +1,73 @@
+package pg
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// UpsertAction what an upsert did with a row, see UpsertReturningAction
+type UpsertAction int
+
+const (
+	UpsertInserted UpsertAction = 0 // the row did not exist and was inserted
+	UpsertUpdated  UpsertAction = 1 // the row conflicted and was updated
+	UpsertSkipped  UpsertAction = 2 // the row conflicted and was left unchanged (all columns are conflict columns)
+)
+
+func (a UpsertAction) String() string {
+	switch a {
+	case UpsertInserted:
+		return "inserted"
+	case UpsertUpdated:
+		return "updated"
+	default:
+		return "skipped"
+	}
+}
+
+// UpsertReturningAction upserts each row (INSERT INTO ON CONFLICT (conflictCols) DO UPDATE SET, see UpsertConditional)
+// and reports, in the order of rows, whether it was inserted or updated, e.g. for "N new, M updated" metrics of an
+// ingestion pipeline. The rows are upserted in a single transaction (or in the current one).
+//
+// The action is taken from RETURNING (xmax = 0): a freshly inserted row version has no deleting transaction, while the
+// update of a conflicting row sets it. xmax is a system column, not a documented API, the result may be wrong in rare
+// cases, e.g. concurrent row locks (multixact), freezing by vacuum around a transaction ID wraparound or changes in
+// future PostgreSQL versions. Use it for metrics, not for business logic.
+func (d *Database) UpsertReturningAction(
+	schema, table string, rows []map[string]interface{}, conflictCols []string,
+) ([]UpsertAction, error) {
+	if d.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	actions := make([]UpsertAction, len(rows))
+	run := func(db *Database) error {
+		for i, values := range rows {
+			query, args := buildUpsertConditional(schema, table, values, conflictCols, "")
+			var inserted bool
+			err := db.QueryRowOld(query+" RETURNING (xmax = 0) AS inserted", args...).Scan(&inserted)
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				actions[i] = UpsertSkipped
+			case err != nil:
+				return err
+			case inserted:
+				actions[i] = UpsertInserted
+			default:
+				actions[i] = UpsertUpdated
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if d.tx != nil {
+		err = run(d)
+	} else {
+		err = d.Transaction(run)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
@@ -0,0 +1,74 @@
+package pg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+func TestUpsertReturningAction(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		_, err := db.Execute(`CREATE TABLE upsert_action (tenant TEXT, id INT, name TEXT, PRIMARY KEY (tenant, id));
+			CREATE TABLE upsert_action_keys (id INT PRIMARY KEY)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		row := func(tenant string, id int, name string) map[string]interface{} {
+			return map[string]interface{}{"tenant": tenant, "id": id, "name": name}
+		}
+
+		actions, err := db.UpsertReturningAction("public", "upsert_action", []map[string]interface{}{
+			row("t1", 1, "alice"),
+			row("t1", 2, "bob"),
+		}, []string{"tenant", "id"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []UpsertAction{UpsertInserted, UpsertInserted}; !reflect.DeepEqual(actions, want) {
+			t.Errorf("got %v, want %v", actions, want)
+		}
+
+		actions, err = db.UpsertReturningAction("public", "upsert_action", []map[string]interface{}{
+			row("t1", 1, "alice updated"),
+			row("t2", 1, "carol"),
+			row("t1", 2, "bob"),
+		}, []string{"tenant", "id"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []UpsertAction{UpsertUpdated, UpsertInserted, UpsertUpdated}; !reflect.DeepEqual(actions, want) {
+			t.Errorf("got %v, want %v", actions, want)
+		}
+
+		var name string
+		if err = db.QueryRowOld("SELECT name FROM upsert_action WHERE tenant = 't1' AND id = 1").Scan(&name); err != nil || name != "alice updated" {
+			t.Errorf("unexpected name %s, %v", name, err)
+		}
+
+		// only conflict columns, DO NOTHING
+		keys := []map[string]interface{}{{"id": 1}, {"id": 1}}
+		actions, err = db.UpsertReturningAction("public", "upsert_action_keys", keys, []string{"id"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []UpsertAction{UpsertInserted, UpsertSkipped}; !reflect.DeepEqual(actions, want) {
+			t.Errorf("got %v, want %v", actions, want)
+		}
+	})
+}
+
+func TestUpsertReturningActionReadOnly(t *testing.T) {
+	db, log := openFakeDatabase(t)
+
+	if _, err := db.ReadOnly().UpsertReturningAction("public", "users", []map[string]interface{}{{"id": 1}}, []string{"id"}); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+	if got := log.String(); got != "" {
+		t.Errorf("unexpected statements %s", got)
+	}
+}
@@ -0,0 +1,50 @@
+package pg
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ServerVersion returns the version of the PostgreSQL server as a number (server_version_num), e.g. 150004 for 15.4
+// and 90524 for 9.5.24, to branch on features available only in newer versions:
+//
+//	if version, err := db.ServerVersion(); err == nil && version >= 100000 {
+//		// GENERATED ALWAYS AS IDENTITY
+//	}
+//
+// The version is queried once and cached for the database (shared by its connections and transactions).
+func (d *Database) ServerVersion() (int, error) {
+	if version := d.pool.serverVersion(); version > 0 {
+		return version, nil
+	}
+
+	var text string
+	if err := d.QueryRowOld("SHOW server_version_num").Scan(&text); err != nil {
+		return 0, errors.New("unable to get the server version (cause: " + err.Error() + ")")
+	}
+	version, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, errors.New("invalid server version " + text)
+	}
+
+	d.pool.setServerVersion(version)
+	return version, nil
+}
+
+func (p *poolState) serverVersion() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.version
+}
+
+func (p *poolState) setServerVersion(version int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.version = version
+}
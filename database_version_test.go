@@ -0,0 +1,46 @@
+package pg
+
+import (
+	"database/sql/driver"
+	"testing"
+
+	"github.com/dhui/dktest"
+)
+
+func TestServerVersionCache(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	log.result("SHOW server_version_num", []string{"server_version_num"}, []driver.Value{"150004"})
+
+	for i := 0; i < 2; i++ {
+		if version, err := db.ServerVersion(); err != nil || version != 150004 {
+			t.Errorf("ServerVersion() = %d, %v", version, err)
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, err := tx.ServerVersion(); err != nil || version != 150004 {
+		t.Errorf("ServerVersion() in transaction = %d, %v", version, err)
+	}
+	_ = tx.Rollback()
+
+	if got := log.String(); got != "SHOW server_version_num;BEGIN;ROLLBACK" {
+		t.Errorf("expected a single query, got %s", got)
+	}
+}
+
+func TestServerVersion(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		version, err := db.ServerVersion()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version < 90500 || version >= 1000000 {
+			t.Errorf("implausible server version %d", version)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package pg
+
+import (
+	"regexp"
+	"strings"
+)
+
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*$`)
+
+// ColumnDef declares a column of a table.
+type ColumnDef struct {
+	Name string // The column name
+	Type string // The column type and constraints, like "VARCHAR(40) NOT NULL DEFAULT ''"
+}
+
+func (c ColumnDef) sql() string {
+	return QuoteIdentifier(c.Name) + " " + c.Type
+}
+
+// TableIndex declares an index of a table.
+type TableIndex struct {
+	Name    string   // The index name
+	Columns []string // Column names or expressions, like "lower(email)"
+	Unique  bool     // Creates a UNIQUE index
+	Where   string   // Predicate of a partial index, like "deleted_at IS NULL"
+}
+
+// buildCreateIndex builds the CREATE INDEX statement. The table must be already quoted (see QuoteIdentifier).
+// Columns that are not plain identifiers are treated as expressions.
+func buildCreateIndex(table, name string, unique bool, cols []string, where string) string {
+	var columns []string
+	for _, col := range cols {
+		if identifierRegex.MatchString(col) {
+			columns = append(columns, QuoteIdentifier(col))
+		} else {
+			columns = append(columns, "("+col+")")
+		}
+	}
+
+	query := "CREATE "
+	if unique {
+		query += "UNIQUE "
+	}
+	query += "INDEX IF NOT EXISTS " + QuoteIdentifier(name) + " ON " + table + " (" + strings.Join(columns, ", ") + ")"
+	if where != "" {
+		query += " WHERE " + where
+	}
+	return query
+}
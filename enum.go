@@ -0,0 +1,85 @@
+package pg
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// EnumValues is implemented by the string types used with Enum, listing the values allowed by the PostgreSQL enum (or
+// domain) type. Ex.
+//
+//	type Status string
+//
+//	func (Status) Values() []Status { return []Status{"active", "inactive"} }
+type EnumValues[T any] interface {
+	~string
+	Values() []T
+}
+
+// Enum binds and scans PostgreSQL enum and domain types, validating the value against the allowed values of T, so
+// invalid values are detected before reaching the database. The zero value ("") is NULL.
+//
+//	db.InsertInto("public", "users", map[string]interface{}{"status": pg.EnumOf(Status("active"))})
+//
+//	var status pg.Enum[Status]
+//	db.QueryRowCtx(ctx, "SELECT status FROM users WHERE id = $1", id).Scan(&status)
+type Enum[T EnumValues[T]] struct {
+	Val T
+}
+
+// EnumOf creates an Enum for the value
+func EnumOf[T EnumValues[T]](value T) Enum[T] {
+	return Enum[T]{Val: value}
+}
+
+// Valid reports whether the value is one of the allowed values (or NULL)
+func (e Enum[T]) Valid() bool {
+	if e.Val == "" {
+		return true
+	}
+	for _, allowed := range e.Val.Values() {
+		if allowed == e.Val {
+			return true
+		}
+	}
+	return false
+}
+
+// Value implements the driver Valuer interface.
+func (e Enum[T]) Value() (driver.Value, error) {
+	if !e.Valid() {
+		return nil, e.invalid(string(e.Val))
+	}
+	if e.Val == "" {
+		return nil, nil
+	}
+	return string(e.Val), nil
+}
+
+// Scan implements the Scanner interface.
+func (e *Enum[T]) Scan(src any) error {
+	var value T
+	switch v := src.(type) {
+	case nil:
+		e.Val = ""
+		return nil
+	case string:
+		value = T(v)
+	case []byte:
+		value = T(v)
+	default:
+		return errors.New(fmt.Sprintf("unsupported type %T for enum %T", src, value))
+	}
+
+	scanned := Enum[T]{Val: value}
+	if !scanned.Valid() {
+		return e.invalid(string(value))
+	}
+	e.Val = value
+	return nil
+}
+
+func (e Enum[T]) invalid(value string) error {
+	return errors.New(fmt.Sprintf("invalid value %q for enum %T (allowed %v)", value, e.Val, e.Val.Values()))
+}
@@ -0,0 +1,47 @@
+package pg
+
+import (
+	"testing"
+)
+
+type testStatus string
+
+func (testStatus) Values() []testStatus {
+	return []testStatus{"active", "inactive"}
+}
+
+func TestEnum(t *testing.T) {
+	value, err := EnumOf(testStatus("active")).Value()
+	if err != nil || value != "active" {
+		t.Errorf("Value() = %v, %v", value, err)
+	}
+	if value, err = (Enum[testStatus]{}).Value(); err != nil || value != nil {
+		t.Errorf("expected NULL, got %v, %v", value, err)
+	}
+	if _, err = EnumOf(testStatus("deleted")).Value(); err == nil {
+		t.Error("expected error for invalid value")
+	}
+
+	var status Enum[testStatus]
+	if err = status.Scan([]byte("inactive")); err != nil || status.Val != "inactive" {
+		t.Errorf("Scan() = %v, %v", status.Val, err)
+	}
+	if err = status.Scan("deleted"); err == nil || status.Val != "inactive" {
+		t.Errorf("expected error for invalid value, got %v (value %s)", err, status.Val)
+	}
+	if err = status.Scan(nil); err != nil || status.Val != "" {
+		t.Errorf("Scan(nil) = %v, %v", status.Val, err)
+	}
+	if err = status.Scan(10); err == nil {
+		t.Error("expected error for unsupported type")
+	}
+
+	// binding in values maps
+	db, log := openFakeDatabase(t)
+	if _, err = db.InsertInto("public", "users", map[string]interface{}{"status": EnumOf(testStatus("deleted"))}); err == nil {
+		t.Error("expected error for invalid value")
+	}
+	if log.String() != "" {
+		t.Errorf("expected no statement executed, got %s", log.String())
+	}
+}
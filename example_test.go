@@ -0,0 +1,43 @@
+package pg_test
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/nidorx/pg"
+)
+
+// fakeQuerier replaces the Database in unit tests
+type fakeQuerier struct {
+	pg.Querier
+	counts map[string]int64
+}
+
+func (f *fakeQuerier) QueryForInt(query string, args ...interface{}) (int64, error) {
+	return f.counts[query], nil
+}
+
+func (f *fakeQuerier) Execute(query string, args ...interface{}) (sql.Result, error) {
+	f.counts["SELECT COUNT(*) FROM users"]++
+	return nil, nil
+}
+
+// business logic depending on pg.Querier
+func registerUser(db pg.Querier, name string) (int64, error) {
+	if _, err := db.Execute("INSERT INTO users (name) VALUES ($1)", name); err != nil {
+		return 0, err
+	}
+	return db.QueryForInt("SELECT COUNT(*) FROM users")
+}
+
+func ExampleQuerier() {
+	db := &fakeQuerier{counts: map[string]int64{}}
+
+	total, _ := registerUser(db, "Alice")
+	fmt.Println(total)
+	total, _ = registerUser(db, "Bob")
+	fmt.Println(total)
+	// Output:
+	// 1
+	// 2
+}
@@ -1,10 +1,14 @@
 package pg
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"runtime"
+
+	"github.com/lib/pq"
 )
 
 type MigrationState int
@@ -28,44 +32,107 @@ type MigrationInfo struct {
 	Description   string         // The description of the migration
 	InstalledRank int            // The rank of this installed migration.
 	Checksum      string         // Computed checksum of the migration.
+	Tags          []string       // The tags of the local migration, see Migration.Tag
 }
 
 func (i *MigrationInfo) Identifier() string {
 	return fmt.Sprintf("version %s", i.Version)
 }
 
+// MigrationError is returned when a command of a migration fails. Use errors.As to retrieve it.
+type MigrationError struct {
+	Version     string // The version of the failed migration
+	Description string // The description of the failed migration
+	Command     int    // The position (starting at 1) of the failed command in the migration
+	SQL         string // The SQL of the failed command (empty for golang commands)
+	Cause       error  // The error returned by the command
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("Migration failed !\n    Caused by: %s", e.Cause.Error())
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Cause
+}
+
 type MigrationPrepare func(context *Migration)
 
 type Migration struct {
-	Repeat   bool
-	Info     *MigrationInfo
-	commands []migrationCommand
-	Prepare  MigrationPrepare
+	Repeat       bool
+	Info         *MigrationInfo
+	commands     []migrationCommand
+	prepared     bool
+	sqlChecksum  string // checksum of the executable SQL, without comments (see addSqlChecksum)
+	fullChecksum string // checksum with comments, when replaced by sqlChecksum (see ignoreComments)
+	Prepare      MigrationPrepare
+}
+
+// prepare schedules the migration commands, only once
+func (m *Migration) prepare() {
+	if !m.prepared {
+		m.prepared = true
+		if m.Prepare != nil {
+			m.Prepare(m)
+		}
+	}
 }
 
 // ExecSql Schedule the execution of an SQL command in this migration
+//
+// Only the SQL is part of the checksum, the args are not: they are often environment specific values (e.g. a tenant
+// name or a setting read from the environment), which would make the checksum differ between environments, and
+// including them would change the checksum of the migrations already applied. Changing the args of an applied
+// migration is therefore not detected, put values that define the schema in the SQL itself.
 func (m *Migration) ExecSql(sql string, args ...interface{}) {
 	m.commands = append(m.commands, &migrationCommandSql{
 		Sql:  sql,
 		Args: args,
 	})
-	m.Info.Checksum = hash(m.Info.Checksum + hash(sql))
+	m.addSqlChecksum(sql)
+}
+
+// EnsureExtension Schedule the creation of an extension (CREATE EXTENSION IF NOT EXISTS) in this migration, e.g.
+// pgcrypto or uuid-ossp.
+//
+// Creating extensions usually requires a superuser (or, for trusted extensions, the CREATE privilege on the database),
+// when the privilege is missing the migration fails with an error explaining it.
+func (m *Migration) EnsureExtension(name string) {
+	sql := "CREATE EXTENSION IF NOT EXISTS " + QuoteIdentifier(name)
+	m.commands = append(m.commands, &migrationCommandExtension{
+		migrationCommandSql: migrationCommandSql{Sql: sql},
+		Name:                name,
+	})
+	m.addChecksum(sql)
 }
 
-// ExecFn Schedule the execution of a golang command in this migration
+// ExecFn Schedule the execution of a golang command in this migration. Only the name is part of the checksum, not the
+// args (see ExecSql).
 func (m *Migration) ExecFn(name string, callback MigrationCommandFn, args ...interface{}) {
-	_, fn, line, _ := runtime.Caller(1)
+	m.execFn(name, func(ctx context.Context, db *Database, migration *Migration, args ...interface{}) error {
+		return callback(db, migration, args...)
+	}, args...)
+}
+
+// ExecFnCtx Schedule the execution of a golang command in this migration, receiving the context of the migration run
+func (m *Migration) ExecFnCtx(name string, callback MigrationCommandFnCtx, args ...interface{}) {
+	m.execFn(name, callback, args...)
+}
+
+func (m *Migration) execFn(name string, callback MigrationCommandFnCtx, args ...interface{}) {
+	_, fn, line, _ := runtime.Caller(2)
 	m.commands = append(m.commands, &migrationCommandCallback{
 		Caller:   fmt.Sprintf("%s:%d", fn, line),
 		Callback: callback,
 		Args:     args,
 	})
-	m.Info.Checksum = hash(m.Info.Checksum + hash(name))
+	m.addChecksum(name)
 }
 
 type migrationCommand interface {
-	run(db *Database, migration *Migration) error
-	debug() string
+	run(ctx context.Context, db *Database, migration *Migration) error
+	debug(maxSqlLen int) string
+	info() MigrationCommandInfo
 }
 
 type migrationCommandSql struct {
@@ -73,32 +140,52 @@ type migrationCommandSql struct {
 	Args []interface{}
 }
 
-func (c *migrationCommandSql) run(db *Database, migration *Migration) error {
-	_, err := db.Execute(c.Sql, c.Args...)
+func (c *migrationCommandSql) run(ctx context.Context, db *Database, migration *Migration) error {
+	_, err := db.ExecuteContext(ctx, c.Sql, c.Args...)
 	return err
 }
 
-func (c *migrationCommandSql) debug() string {
-	debugMsg := fmt.Sprintf("%s\n", c.Sql)
+func (c *migrationCommandSql) debug(maxSqlLen int) string {
+	debugMsg := fmt.Sprintf("%s\n", truncateSql(c.Sql, maxSqlLen))
 	for i, arg := range c.Args {
 		debugMsg += fmt.Sprintf("    $%d = %v\n", i, arg)
 	}
 	return debugMsg
 }
 
+type migrationCommandExtension struct {
+	migrationCommandSql
+	Name string
+}
+
+func (c *migrationCommandExtension) run(ctx context.Context, db *Database, migration *Migration) error {
+	err := c.migrationCommandSql.run(ctx, db, migration)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "42501" {
+		// insufficient_privilege
+		return fmt.Errorf(
+			"permission denied to create extension %s, it must be created by a superuser or by a user with the "+
+				"CREATE privilege on the database, for trusted extensions (cause: %w)", c.Name, err,
+		)
+	}
+	return err
+}
+
 type MigrationCommandFn func(db *Database, migration *Migration, args ...interface{}) error
 
+type MigrationCommandFnCtx func(ctx context.Context, db *Database, migration *Migration, args ...interface{}) error
+
 type migrationCommandCallback struct {
 	Caller   string
-	Callback MigrationCommandFn
+	Callback MigrationCommandFnCtx
 	Args     []interface{}
 }
 
-func (c *migrationCommandCallback) run(db *Database, migration *Migration) error {
-	return c.Callback(db, migration, c.Args...)
+func (c *migrationCommandCallback) run(ctx context.Context, db *Database, migration *Migration) error {
+	return c.Callback(ctx, db, migration, c.Args...)
 }
 
-func (c *migrationCommandCallback) debug() string {
+func (c *migrationCommandCallback) debug(maxSqlLen int) string {
 	debugMsg := fmt.Sprintf("function %v\n", c.Caller)
 	for i, arg := range c.Args {
 		debugMsg += fmt.Sprintf("    $%d = %v\n", i, arg)
@@ -0,0 +1,62 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// BulkLoad Schedule a data load in this migration with the indexes dropped during the load, e.g. a large seed. Loading
+// into a table without indexes and building them once at the end is much faster than updating them row by row.
+//
+// The definitions of the indexes (schema qualified or in the search_path) are read when the migration runs, the
+// indexes are dropped, load is executed and the indexes are created again with the same definitions. Everything runs
+// in the transaction of the migration, when the load fails the rollback restores the indexes. Indexes that back a
+// constraint (primary key, unique or exclusion constraint) can not be dropped.
+//
+// The name and the indexes are part of the checksum, not the load function (see ExecFn).
+func (m *Migration) BulkLoad(name string, indexes []string, load MigrationCommandFnCtx) {
+	_, fn, line, _ := runtime.Caller(1)
+	m.commands = append(m.commands, &migrationCommandBulkLoad{
+		migrationCommandCallback: migrationCommandCallback{Caller: fmt.Sprintf("%s:%d", fn, line), Callback: load},
+		Indexes:                  indexes,
+	})
+	m.addChecksum(name + " " + strings.Join(indexes, ","))
+}
+
+type migrationCommandBulkLoad struct {
+	migrationCommandCallback
+	Indexes []string
+}
+
+func (c *migrationCommandBulkLoad) run(ctx context.Context, db *Database, migration *Migration) error {
+	var definitions []string
+	for _, index := range c.Indexes {
+		name := quoteQualifiedName(index)
+		var definition string
+		if err := db.QueryRowCtx(ctx, "SELECT pg_get_indexdef($1::regclass)", name).Scan(&definition); err != nil {
+			return errors.New("unable to read the definition of index " + index + " (cause: " + err.Error() + ")")
+		}
+		if _, err := db.ExecuteContext(ctx, "DROP INDEX "+name); err != nil {
+			return err
+		}
+		definitions = append(definitions, definition)
+	}
+
+	if err := c.migrationCommandCallback.run(ctx, db, migration); err != nil {
+		return err
+	}
+
+	for _, definition := range definitions {
+		if _, err := db.ExecuteContext(ctx, definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *migrationCommandBulkLoad) debug(maxSqlLen int) string {
+	return fmt.Sprintf("bulk load %v (indexes %s)\n", c.Caller, strings.Join(c.Indexes, ", "))
+}
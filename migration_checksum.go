@@ -0,0 +1,135 @@
+package pg
+
+import "strings"
+
+// addChecksum adds the part to the checksum of the migration (and to the checksum without comments, see
+// MigrationConfig.IgnoreCommentsInChecksum)
+func (m *Migration) addChecksum(part string) {
+	m.Info.Checksum = hash(m.Info.Checksum + hash(part))
+	m.sqlChecksum = hash(m.sqlChecksum + hash(part))
+}
+
+// addSqlChecksum adds the SQL to the checksum of the migration, the checksum without comments only sees the
+// executable SQL (see stripSqlComments)
+func (m *Migration) addSqlChecksum(sql string) {
+	m.Info.Checksum = hash(m.Info.Checksum + hash(sql))
+	m.sqlChecksum = hash(m.sqlChecksum + hash(stripSqlComments(sql)))
+}
+
+// ignoreComments uses the checksum without comments as the checksum of the migration. The checksum with comments is
+// kept, so migrations recorded before MigrationConfig.IgnoreCommentsInChecksum was enabled still match (see
+// checksumMatches).
+func (m *Migration) ignoreComments() {
+	if m.fullChecksum == "" {
+		m.fullChecksum = m.Info.Checksum
+		m.Info.Checksum = m.sqlChecksum
+	}
+}
+
+// checksumMatches reports whether the checksum recorded in the history table matches the migration
+func (m *Migration) checksumMatches(applied string) bool {
+	return applied == m.Info.Checksum || (m.fullChecksum != "" && applied == m.fullChecksum)
+}
+
+// stripSqlComments removes the "--" and "/* */" comments of the SQL and collapses the whitespace, so adding, editing
+// or removing comments (or blank lines) does not change the result. String literals, quoted identifiers and dollar quoted
+// strings are kept intact.
+func stripSqlComments(sql string) string {
+	var s strings.Builder
+	space := false
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			i += end
+			space = true
+			continue
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			// block comments nest in PostgreSQL
+			depth := 0
+			for i < len(sql) {
+				if strings.HasPrefix(sql[i:], "/*") {
+					depth++
+					i += 2
+				} else if strings.HasPrefix(sql[i:], "*/") {
+					depth--
+					i += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					i++
+				}
+			}
+			space = true
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+			space = true
+			continue
+		}
+
+		if space && s.Len() > 0 {
+			s.WriteByte(' ')
+		}
+		space = false
+
+		end := i + 1
+		switch {
+		case c == '\'' || c == '"':
+			escapes := c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e')
+			end = quotedEnd(sql, i, c, escapes)
+		case c == '$' && (i == 0 || !isIdentifierChar(sql[i-1])):
+			if tag := dollarTag(sql[i:]); tag != "" {
+				if close := strings.Index(sql[i+len(tag):], tag); close >= 0 {
+					end = i + len(tag) + close + len(tag)
+				} else {
+					end = len(sql)
+				}
+			}
+		}
+		s.WriteString(sql[i:end])
+		i = end
+	}
+	return s.String()
+}
+
+// quotedEnd the position after the closing quote of the literal starting at start, a doubled quote is an escaped quote
+func quotedEnd(sql string, start int, quote byte, backslashEscapes bool) int {
+	for i := start + 1; i < len(sql); i++ {
+		switch {
+		case backslashEscapes && sql[i] == '\\':
+			i++
+		case sql[i] == quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+// dollarTag the opening tag of a dollar quoted string ($$ or $tag$) at the start of sql, empty when it is not one
+// (e.g. a parameter $1)
+func dollarTag(sql string) string {
+	for i := 1; i < len(sql); i++ {
+		c := sql[i]
+		if c == '$' {
+			return sql[:i+1]
+		}
+		if !isIdentifierChar(c) || (i == 1 && c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
@@ -0,0 +1,43 @@
+package pg
+
+// MigrationCommandKind the kind of a command scheduled in a migration, see MigrationCommandInfo
+type MigrationCommandKind string
+
+const (
+	CommandKindSql MigrationCommandKind = "SQL" // ExecSql and the SQL helpers (EnsureExtension, CreatePartition, ...)
+	CommandKindFn  MigrationCommandKind = "Fn"  // ExecFn, ExecFnCtx and BulkLoad
+)
+
+// MigrationCommandInfo a read-only description of a command scheduled in a migration, see Migration.Commands
+type MigrationCommandInfo struct {
+	Kind   MigrationCommandKind
+	SQL    string // The SQL of the command (CommandKindSql)
+	Caller string // The location (file:line) that scheduled the golang command (CommandKindFn)
+	Args   int    // The number of args of the command
+}
+
+// Commands returns the commands of the migration, in the order they run, without executing them. Useful to inspect
+// the plan of a migration in tests or linters. The prepare function of the migration is invoked when needed.
+func (m *Migration) Commands() []MigrationCommandInfo {
+	m.prepare()
+	infos := make([]MigrationCommandInfo, len(m.commands))
+	for i, cmd := range m.commands {
+		infos[i] = cmd.info()
+	}
+	return infos
+}
+
+// Checksum returns the checksum of the migration, the one recorded in the history table. The prepare function of the
+// migration is invoked when needed.
+func (m *Migration) Checksum() string {
+	m.prepare()
+	return m.Info.Checksum
+}
+
+func (c *migrationCommandSql) info() MigrationCommandInfo {
+	return MigrationCommandInfo{Kind: CommandKindSql, SQL: c.Sql, Args: len(c.Args)}
+}
+
+func (c *migrationCommandCallback) info() MigrationCommandInfo {
+	return MigrationCommandInfo{Kind: CommandKindFn, Caller: c.Caller, Args: len(c.Args)}
+}
@@ -0,0 +1,36 @@
+package pg
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// deadlockCodes PostgreSQL errors of a transaction that can succeed when executed again
+var deadlockCodes = map[pq.ErrorCode]bool{
+	"40P01": true, // deadlock_detected
+	"40001": true, // serialization_failure
+}
+
+// isDeadlockError reports whether err is a deadlock or serialization failure (retryable), and not an error of the DDL
+func isDeadlockError(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && deadlockCodes[pqErr.Code]
+}
+
+// retryDeadlock executes the callback (a lock acquisition) again, up to MigrationConfig.DeadlockRetries times, while it
+// fails with a deadlock or serialization failure. While retries are left, the failure of the migration is not recorded
+// in the history table (see applyMigration).
+func (h *migrationHistory) retryDeadlock(callback func() error) error {
+	defer func() { h.retrying = false }()
+
+	for attempt := 1; ; attempt++ {
+		h.retrying = attempt <= h.deadlockRetries
+		err := callback()
+		if err == nil || !h.retrying || !isDeadlockError(err) {
+			return err
+		}
+		h.logger.Warn("Migration of schema %s failed with a deadlock, retrying (%d of %d). cause: %v",
+			h.schemaName, attempt, h.deadlockRetries, err)
+	}
+}
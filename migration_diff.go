@@ -0,0 +1,111 @@
+package pg
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+type MigrationDiffType int
+
+const (
+	MigrationAdded   MigrationDiffType = 1 // The migration exists only in the new set
+	MigrationRemoved MigrationDiffType = 2 // The migration exists only in the old set
+	MigrationChanged MigrationDiffType = 3 // The description or checksum of the migration changed
+)
+
+// MigrationDiff a difference between two migration sets, see DiffMigrations
+type MigrationDiff struct {
+	Type MigrationDiffType
+	Old  *MigrationInfo // nil when added
+	New  *MigrationInfo // nil when removed
+}
+
+func (d MigrationDiff) String() string {
+	switch d.Type {
+	case MigrationAdded:
+		return fmt.Sprintf("added %s (%s)", d.New.Identifier(), d.New.Description)
+	case MigrationRemoved:
+		return fmt.Sprintf("removed %s (%s)", d.Old.Identifier(), d.Old.Description)
+	default:
+		return fmt.Sprintf(
+			"changed %s (%s -> %s, checksum %s -> %s)",
+			d.New.Identifier(), d.Old.Description, d.New.Description, d.Old.Checksum, d.New.Checksum,
+		)
+	}
+}
+
+// DiffMigrations compares two migration sets, without a database, reporting the added, removed and changed
+// migrations (sorted by version, repeatable last). The order of the migrations in the sets is irrelevant.
+//
+// Versioned migrations are matched by version, repeatable migrations by description. The versions are sorted with
+// the comparator, when informed (defaults SemverComparator), see MigrationOptions.VersionComparator.
+func DiffMigrations(old, new []*Migration, comparator ...func(a, b string) int) ([]MigrationDiff, error) {
+	compare := SemverComparator
+	if len(comparator) > 0 && comparator[0] != nil {
+		compare = comparator[0]
+	}
+
+	oldByKey, err := migrationsByKey(old)
+	if err != nil {
+		return nil, errors.New("invalid old migration set (cause: " + err.Error() + ")")
+	}
+	newByKey, err := migrationsByKey(new)
+	if err != nil {
+		return nil, errors.New("invalid new migration set (cause: " + err.Error() + ")")
+	}
+
+	var diffs []MigrationDiff
+	for key, o := range oldByKey {
+		n, exists := newByKey[key]
+		if !exists {
+			diffs = append(diffs, MigrationDiff{Type: MigrationRemoved, Old: o})
+		} else if o.Checksum != n.Checksum || o.Description != n.Description {
+			diffs = append(diffs, MigrationDiff{Type: MigrationChanged, Old: o, New: n})
+		}
+	}
+	for key, n := range newByKey {
+		if _, exists := oldByKey[key]; !exists {
+			diffs = append(diffs, MigrationDiff{Type: MigrationAdded, New: n})
+		}
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool {
+		a := diffInfo(diffs[i])
+		b := diffInfo(diffs[j])
+		if a.Version == b.Version {
+			return a.Description < b.Description
+		}
+		if a.Version == "R" || b.Version == "R" {
+			return b.Version == "R"
+		}
+		return compare(a.Version, b.Version) < 0
+	})
+
+	return diffs, nil
+}
+
+func diffInfo(diff MigrationDiff) *MigrationInfo {
+	if diff.New != nil {
+		return diff.New
+	}
+	return diff.Old
+}
+
+// migrationsByKey prepares the migrations, indexing by version (or description for repeatable migrations)
+func migrationsByKey(migrations []*Migration) (map[string]*MigrationInfo, error) {
+	byKey := map[string]*MigrationInfo{}
+	for _, migration := range migrations {
+		migration.prepare()
+
+		key := "V" + migration.Info.Version
+		if migration.Repeat {
+			key = "R" + migration.Info.Description
+		}
+		if _, exists := byKey[key]; exists {
+			return nil, errors.New(fmt.Sprintf("found more than one migration for %s", migration.Info.Identifier()))
+		}
+		byKey[key] = migration.Info
+	}
+	return byKey, nil
+}
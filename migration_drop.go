@@ -0,0 +1,22 @@
+package pg
+
+// DropTable Schedule the removal of a table, if it exists, in this migration. The name may be qualified by the schema
+// ("public.users"). Useful to revert a migration that runs CREATE TABLE, ex. m.DropTable("users")
+func (m *Migration) DropTable(name string) {
+	m.ExecSql("DROP TABLE IF EXISTS " + quoteQualifiedName(name))
+}
+
+// DropColumn Schedule the removal of a column of the table, if both exist, in this migration
+func (m *Migration) DropColumn(table, column string) {
+	m.ExecSql("ALTER TABLE IF EXISTS " + quoteQualifiedName(table) + " DROP COLUMN IF EXISTS " + QuoteIdentifier(column))
+}
+
+// DropIndex Schedule the removal of an index, if it exists, in this migration. The name may be qualified by the schema
+func (m *Migration) DropIndex(name string) {
+	m.ExecSql("DROP INDEX IF EXISTS " + quoteQualifiedName(name))
+}
+
+// DropConstraint Schedule the removal of a constraint of the table, if both exist, in this migration
+func (m *Migration) DropConstraint(table, name string) {
+	m.ExecSql("ALTER TABLE IF EXISTS " + quoteQualifiedName(table) + " DROP CONSTRAINT IF EXISTS " + QuoteIdentifier(name))
+}
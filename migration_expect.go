@@ -0,0 +1,46 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ExecSqlExpect Schedule the execution of an SQL command (UPDATE, DELETE, INSERT) in this migration that must affect
+// exactly expectedRows rows, otherwise the migration fails and is rolled back. Guards data migrations against mistakes
+// in the WHERE clause. The SQL and the expected rows are part of the checksum, the args are not (see ExecSql). Ex.
+//
+//	m.ExecSqlExpect("UPDATE users SET plan = 'pro' WHERE legacy_plan = 'gold'", 10)
+func (m *Migration) ExecSqlExpect(sql string, expectedRows int64, args ...interface{}) {
+	m.commands = append(m.commands, &migrationCommandExpect{
+		migrationCommandSql: migrationCommandSql{Sql: sql, Args: args},
+		ExpectedRows:        expectedRows,
+	})
+	m.addSqlChecksum(sql)
+	m.addChecksum(strconv.FormatInt(expectedRows, 10))
+}
+
+type migrationCommandExpect struct {
+	migrationCommandSql
+	ExpectedRows int64
+}
+
+func (c *migrationCommandExpect) run(ctx context.Context, db *Database, migration *Migration) error {
+	result, err := db.ExecuteContext(ctx, c.Sql, c.Args...)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected != c.ExpectedRows {
+		return errors.New(fmt.Sprintf("expected %d affected rows, got %d", c.ExpectedRows, affected))
+	}
+	return nil
+}
+
+func (c *migrationCommandExpect) debug(maxSqlLen int) string {
+	return c.migrationCommandSql.debug(maxSqlLen) + fmt.Sprintf("    expected rows = %d\n", c.ExpectedRows)
+}
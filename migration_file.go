@@ -0,0 +1,57 @@
+package pg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NewMigrationFile writes the skeleton of a new migration file (vVERSION_Description.sql) into dir, to be loaded with
+// AddMigrations. Spaces of the description are replaced by "_". Returns the path of the file. Ex.
+//
+//	path, err := pg.NewMigrationFile("migrations", "1.2.0", "Add orders status") // migrations/v1.2.0_Add_orders_status.sql
+//
+// The version must be a valid semantic version, and not used by another migration file of dir.
+func NewMigrationFile(dir, version, description string) (path string, err error) {
+	if !SemverValidator(version) {
+		return "", errors.New(fmt.Sprintf("migration has a invalid version (%s)", version))
+	}
+	description = strings.TrimSpace(description)
+	if description == "" {
+		return "", errors.New(fmt.Sprintf("migration description is required (v%s)", version))
+	}
+	if strings.ContainsAny(description, `/\`) {
+		return "", errors.New(fmt.Sprintf("migration description can not contain path separators (v%s): %s", version, description))
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		if existing, _, errName := parseMigrationFileName(entry.Name()); errName == nil && existing == version {
+			return "", errors.New(fmt.Sprintf("found a migration file with version %s: %s", version, entry.Name()))
+		}
+	}
+
+	name := "v" + version + "_" + strings.Join(strings.Fields(description), "_") + ".sql"
+	path = filepath.Join(dir, name)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", err
+	}
+	_, err = fmt.Fprintf(file, "-- Migration %s: %s\n\n", version, strings.Join(strings.Fields(description), " "))
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
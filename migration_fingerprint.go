@@ -0,0 +1,67 @@
+package pg
+
+import (
+	"strings"
+)
+
+// MigrationFingerprint a digest of the applied migrations (version and checksum, in the order they were applied), read
+// from the history table only. Two databases with the same migration history have the same fingerprint, answering
+// whether two environments are on the same schema without comparing the catalog.
+//
+// Only the latest attempt of each version is considered (see MigrationConfig.KeepFailures) and failed migrations are
+// ignored. A database without history has the fingerprint of an empty history.
+func (d *Database) MigrationFingerprint(config *MigrationConfig) (string, error) {
+	history, release, err := d.newMigrationHistory(config)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	if exists, err := history.schemaExists(history.schemaName); err != nil {
+		return "", err
+	} else if !exists {
+		return history.fingerprint(nil), nil
+	}
+
+	dbSchema, err := history.newSchemaConnection(history.searchPath)
+	if err != nil {
+		return "", err
+	}
+	defer dbSchema.Close()
+	history.dbSchema = dbSchema
+
+	var appliedMigrations []*MigrationInfo
+	if tableExists, err := history.tableExists(); err != nil {
+		return "", err
+	} else if tableExists {
+		if appliedMigrations, err = history.getAppliedMigrations(); err != nil {
+			return "", err
+		}
+	}
+	return history.fingerprint(appliedMigrations), nil
+}
+
+// fingerprint digest of the applied migrations, ordered by installed_rank (oldest first)
+func (h *migrationHistory) fingerprint(appliedMigrations []*MigrationInfo) string {
+	latest := map[string]*MigrationInfo{}
+	for _, info := range appliedMigrations {
+		latest[fingerprintKey(info)] = info
+	}
+
+	var lines []string
+	for _, info := range appliedMigrations {
+		key := fingerprintKey(info)
+		if latest[key] == info && info.State == MigrationSuccess {
+			lines = append(lines, key+" "+info.Checksum)
+		}
+	}
+	return hash(strings.Join(lines, "\n"))
+}
+
+// fingerprintKey identifies the migration in the history, repeatable migrations (version R) by the description
+func fingerprintKey(info *MigrationInfo) string {
+	if info.Version == "R" {
+		return "R " + info.Description
+	}
+	return info.Version
+}
@@ -5,46 +5,61 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nidorx/retry"
-	"golang.org/x/mod/semver"
 )
 
 type migrationHistory struct {
 	db                 *Database
 	dbLock             *Database
 	dbSchema           *Database
+	cacheMu            sync.Mutex // guards cache, see getAppliedMigrations
 	cache              []*MigrationInfo
 	tableName          string
-	schemaName         string
+	schemaName         string // schema of the history table
+	searchPath         string // search_path used to run the migrations
 	lastAppliedVersion string
+	outOfOrder         bool
+	keepFailures       bool
+	allowNewerSchema   bool
+	onMissingLocal     MissingLocalPolicy
+	maxSqlLog          int
+	sessionSetup       []string
+	notifyChannel      string
+	rejectEmpty        bool
+	installedOnTz      bool
+	ignoreComments     bool
+	createTableSql     func(schema, table string) (createSQL, indexSQL string)
+	deadlockRetries    int
+	retrying           bool // the current attempt can still be retried, see retryDeadlock
+	includeTags        []string
+	excludeTags        []string
+	cacheFile          string
+	versionComparator  func(a, b string) int
+	versionValidator   func(version string) bool
+	extraColumns       []ColumnDef
+	extraValues        func(info *MigrationInfo) map[string]interface{}
 	logger             Logger
+	open               func(config *Config, customParams map[string]string, init bool) (*sql.DB, error) // openPool when nil
 }
 
-func (h *migrationHistory) Migrate() error {
+func (h *migrationHistory) Migrate(ctx context.Context) error {
 
 	h.lastAppliedVersion = "0"
 
-	migrations := h.db.migrations
-
-	sort.SliceStable(migrations, func(i, j int) bool {
-		a := migrations[i]
-		b := migrations[j]
-		if a.Repeat == b.Repeat {
-			return semver.Compare("v"+a.Info.Version, "v"+b.Info.Version) < 0
-		}
-		if a.Repeat {
-			return false
-		}
-		return true
-	})
+	migrations, err := h.prepareMigrations()
+	if err != nil {
+		return err
+	}
 
-	// init context (fast fail)
-	for _, migration := range migrations {
-		migration.Prepare(migration)
+	if h.upToDate(migrations) {
+		h.log(0, 0, h.lastAppliedVersion)
+		return nil
 	}
 
 	if err := h.createTable(); err != nil {
@@ -56,13 +71,22 @@ func (h *migrationHistory) Migrate() error {
 
 	for {
 
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		count := 0
 
 		// acquire the lock now. The lock will be released at the end of each migration.
-		err := h.lock(func() error {
-			var err error
-			count, err = h.migrateNext(totalSuccess == 0, migrations)
-			return err
+		err := h.retryDeadlock(func() error {
+			return h.lock(func() error {
+				var err error
+				count, err = h.migrateNext(ctx, totalSuccess == 0, migrations)
+				if err == nil && count == 0 && totalSuccess > 0 {
+					err = h.notify()
+				}
+				return err
+			})
 		})
 
 		if err != nil {
@@ -77,17 +101,92 @@ func (h *migrationHistory) Migrate() error {
 	}
 
 	h.log(totalSuccess, time.Since(start).Milliseconds(), h.lastAppliedVersion)
+	if appliedMigrations, err := h.getAppliedMigrations(); err == nil {
+		h.logger.Info("Migration fingerprint of schema %s: %s", h.schemaName, h.fingerprint(appliedMigrations))
+	}
+	h.saveCache(migrations)
 	return nil
 }
 
-func (h *migrationHistory) migrateNext(firstRun bool, migrations []*Migration) (int, error) {
+// ApplyMigration applies only the migration with the given version
+func (h *migrationHistory) ApplyMigration(ctx context.Context, version string) error {
+
+	var migration *Migration
+	migrations, err := h.prepareMigrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.Info.Version == version {
+			migration = m
+			break
+		}
+	}
+	if migration == nil {
+		return errors.New(fmt.Sprintf("migration not found (version %s)", version))
+	}
+
+	if err := h.createTable(); err != nil {
+		return err
+	}
+
+	return h.lock(func() error {
+		appliedMigrations, err := h.getAppliedMigrations()
+		if err != nil {
+			return err
+		}
+
+		appliedByVersion := map[string]*MigrationInfo{}
+		for _, info := range appliedMigrations {
+			if info.Version != "R" {
+				// repeatable migrations are applied again, they all have the version R
+				appliedByVersion[info.Version] = info
+			}
+		}
+		h.lastAppliedVersion = h.lastSuccessVersion(appliedMigrations)
+
+		resolved := migration.Info
+		if applied := appliedByVersion[version]; applied != nil {
+			if applied.State == MigrationSuccess {
+				return errors.New(fmt.Sprintf("Migration %s is already applied", resolved.Identifier()))
+			}
+			if !migration.checksumMatches(applied.Checksum) {
+				return errors.New(mismatchMessage("checksum", resolved.Identifier(), applied.Checksum, resolved.Checksum))
+			}
+		}
+
+		if !migration.Repeat && !h.outOfOrder {
+			if h.compare(version, h.lastAppliedVersion) <= 0 {
+				return errors.New(fmt.Sprintf(
+					"Schema %s has a version (%s) that is newer than the migration (%s).",
+					h.schemaName, h.lastAppliedVersion, version,
+				))
+			}
+
+			for _, m := range migrations {
+				applied := appliedByVersion[m.Info.Version]
+				if !m.Repeat && (applied == nil || applied.State != MigrationSuccess) &&
+					h.compare(m.Info.Version, version) < 0 {
+					return errors.New(fmt.Sprintf(
+						"Migration %s can not be applied before the pending %s",
+						resolved.Identifier(), m.Info.Identifier(),
+					))
+				}
+			}
+		}
+
+		return h.applyMigration(ctx, migration)
+	})
+}
+
+func (h *migrationHistory) migrateNext(ctx context.Context, firstRun bool, migrations []*Migration) (int, error) {
 
 	appliedMigrations, err := h.getAppliedMigrations()
 	if err != nil {
 		return 0, err
 	}
 
-	lastAppliedVersion := ""
+	lastAppliedVersion := h.lastSuccessVersion(appliedMigrations)
 	notResolved := map[string]*MigrationInfo{}
 	appliedByVersion := map[string]*MigrationInfo{}
 
@@ -96,9 +195,6 @@ func (h *migrationHistory) migrateNext(firstRun bool, migrations []*Migration) (
 		if version != "R" {
 			notResolved[version] = info
 			appliedByVersion[version] = info
-			if info.State == MigrationSuccess && semver.Compare("v"+version, "v"+lastAppliedVersion) > 0 {
-				lastAppliedVersion = version
-			}
 		}
 	}
 
@@ -108,6 +204,22 @@ func (h *migrationHistory) migrateNext(firstRun bool, migrations []*Migration) (
 		h.logger.Info("Current version of schema %s: %s", h.schemaName, lastAppliedVersion)
 	}
 
+	if h.allowNewerSchema {
+		if future := h.futureMigrations(appliedMigrations, migrations); len(future) > 0 {
+			// this instance is behind (rolling deploy), a newer version of the application has already migrated
+			var versions []string
+			for _, info := range future {
+				versions = append(versions, info.Version)
+			}
+			h.logger.Warn(
+				"Schema %s has a version (%s) that is newer than the available migrations. Skipping migration.\n"+
+					"    Unknown applied versions: %s",
+				h.schemaName, lastAppliedVersion, strings.Join(versions, ", "),
+			)
+			return 0, nil
+		}
+	}
+
 	var pendingMigrations []*Migration
 
 	// compare with local migrations
@@ -122,9 +234,16 @@ func (h *migrationHistory) migrateNext(firstRun bool, migrations []*Migration) (
 		}
 
 		applied := appliedByVersion[version]
+		if applied == nil && !h.selected(migration) {
+			// filtered by tag, neither applied nor checked against the current version
+			if firstRun {
+				h.logger.Info("Skipping migration of %s (tags %s)", resolved.Identifier(), strings.Join(resolved.Tags, ", "))
+			}
+			continue
+		}
 		if applied == nil {
 			// has not yet been applied
-			if version != "R" && semver.Compare("v"+version, "v"+lastAppliedVersion) <= 0 {
+			if version != "R" && !h.outOfOrder && h.compare(version, lastAppliedVersion) <= 0 {
 				msg := fmt.Sprintf(
 					"Schema %s has a version (%s) that is newer than the available migration (%s).",
 					h.schemaName, lastAppliedVersion, version,
@@ -133,14 +252,14 @@ func (h *migrationHistory) migrateNext(firstRun bool, migrations []*Migration) (
 			}
 		} else if applied.State == MigrationSuccess {
 			// If it has already been successfully applied to the base, check if there have been any local changes
-			if applied.Checksum != resolved.Checksum {
+			if !migration.checksumMatches(applied.Checksum) {
 
 				debugMsg := "\n------------------------------------------------------------------------------\n"
 				debugMsg += fmt.Sprintf("Migration - %s - %s", resolved.Identifier(), resolved.Description)
 				debugMsg += "\n------------------------------------------------------------------------------\n"
 				for i, cmd := range migration.commands {
 					debugMsg += fmt.Sprintf("-- (%d)\n", i+1)
-					debugMsg += cmd.debug()
+					debugMsg += cmd.debug(h.maxSqlLog)
 					debugMsg += "\n"
 				}
 				debugMsg = debugMsg[:len(debugMsg)-1]
@@ -164,12 +283,16 @@ func (h *migrationHistory) migrateNext(firstRun bool, migrations []*Migration) (
 		}
 	}
 
-	// Verifica migrations que foram removidas do código (nunca pode acontecer)
+	// Verifica migrations que foram removidas do código
+	var missing []*MigrationInfo
 	for _, info := range notResolved {
 		if info != nil {
-			return 0, errors.New("Detected applied migration not resolved locally: " + info.Identifier() + "")
+			missing = append(missing, info)
 		}
 	}
+	if err = h.checkMissingLocal(missing, firstRun); err != nil {
+		return 0, err
+	}
 
 	// nao existe migration pendente
 	if len(pendingMigrations) == 0 {
@@ -177,12 +300,44 @@ func (h *migrationHistory) migrateNext(firstRun bool, migrations []*Migration) (
 	}
 
 	// Obtém a próxima migration que sera executada
-	migration := pendingMigrations[0]
+	if err = h.applyMigration(ctx, pendingMigrations[0]); err != nil {
+		return 0, err
+	}
+
+	return 1, nil
+}
 
+// checkMissingLocal applies the MigrationConfig.OnMissingLocal policy to the applied migrations not resolved locally.
+// The warnings are only logged when warn is true (first run), to avoid repeating them for every migration.
+func (h *migrationHistory) checkMissingLocal(missing []*MigrationInfo, warn bool) error {
+	if len(missing) == 0 {
+		return nil
+	}
+
+	switch h.onMissingLocal {
+	case MissingLocalIgnore:
+		return nil
+	case MissingLocalWarn:
+		if warn {
+			for _, info := range missing {
+				h.logger.Warn("Applied migration not resolved locally: %s", info.Identifier())
+			}
+		}
+		return nil
+	default:
+		return errors.New("Detected applied migration not resolved locally: " + missing[0].Identifier() + "")
+	}
+}
+
+// applyMigration finally applies the migration. The migration state and time are updated accordingly.
+func (h *migrationHistory) applyMigration(ctx context.Context, migration *Migration) error {
 	start := time.Now()
 
-	// finally applies the migration. The migration state and time are updated accordingly.
-	err = h.migrateSingle(migration)
+	err := h.migrateSingle(ctx, migration)
+	if err != nil && h.retrying && isDeadlockError(err) {
+		// rolled back, retried by retryDeadlock without recording the failure
+		return err
+	}
 	if err != nil {
 		h.logger.Warn(
 			"Migration of %s failed!\n    Caused by: %s\n    Changes successfully rolled back.",
@@ -193,36 +348,52 @@ func (h *migrationHistory) migrateNext(firstRun bool, migrations []*Migration) (
 		if err2 != nil {
 			h.logger.Error(err2)
 		}
-		return 0, err
+		return err
 	}
 
-	h.lastAppliedVersion = migration.Info.Version
+	if !migration.Repeat && h.compare(migration.Info.Version, h.lastAppliedVersion) > 0 {
+		h.lastAppliedVersion = migration.Info.Version
+	}
 
-	return 1, nil
+	return nil
 }
 
-func (h *migrationHistory) migrateSingle(migration *Migration) error {
+func (h *migrationHistory) migrateSingle(ctx context.Context, migration *Migration) error {
 
 	start := time.Now()
 	migrationText := toMigrationText(migration)
 
 	h.logger.Info("Starting migration of %s ...", migrationText)
 
-	newDbSchemaConn, err := h.dbSchema.Conn()
+	newDbSchemaConn, err := h.dbSchema.ConnContext(ctx)
 	if err != nil {
 		return err
 	}
 
-	defer func() {
-		if errRelease := newDbSchemaConn.CloseConn(); errRelease != nil {
-			h.logger.Error(errRelease)
-		}
-	}()
+	defer h.releaseConn(newDbSchemaConn)
+
+	if err = h.setupSession(newDbSchemaConn); err != nil {
+		return err
+	}
 
 	err = newDbSchemaConn.Transaction(func(db *Database) error {
-		for _, cmd := range migration.commands {
-			if errExec := cmd.run(db, migration); errExec != nil {
-				return errors.New(fmt.Sprintf("Migration failed !\n    Caused by: %s", errExec.Error()))
+		for i, cmd := range migration.commands {
+			if errExec := cmd.run(ctx, db, migration); errExec != nil {
+				migrationErr := &MigrationError{
+					Version:     migration.Info.Version,
+					Description: migration.Info.Description,
+					Command:     i + 1,
+					Cause:       errExec,
+				}
+				switch sqlCmd := cmd.(type) {
+				case *migrationCommandSql:
+					migrationErr.SQL = sqlCmd.Sql
+				case *migrationCommandExtension:
+					migrationErr.SQL = sqlCmd.Sql
+				case *migrationCommandExpect:
+					migrationErr.SQL = sqlCmd.Sql
+				}
+				return migrationErr
 			}
 		}
 		h.logger.Info("Successfully completed migration of " + migrationText)
@@ -237,20 +408,54 @@ func (h *migrationHistory) migrateSingle(migration *Migration) error {
 	// atualiza informações sobre a migration local
 	migration.Info.State = MigrationSuccess
 
-	return h.addAppliedMigration(migration.Info, int(executionTime.Milliseconds()), true)
+	if err = h.addAppliedMigration(migration.Info, int(executionTime.Milliseconds()), true); err != nil {
+		return err
+	}
+	h.db.metrics.migrationApplied()
+	return nil
+}
+
+// setupSession prepares the connection of a migration: sets the search_path of the migrations explicitly, so the DDL
+// never runs with a leftover search_path of a pooled connection, and runs the MigrationConfig.SessionSetup statements
+func (h *migrationHistory) setupSession(conn *Database) error {
+	statements := append([]string{"SET search_path TO " + h.searchPath}, h.sessionSetup...)
+	for _, statement := range statements {
+		if _, err := conn.Execute(statement); err != nil {
+			return errors.New("unable to set up the migration session with " + statement + " (cause: " + err.Error() + ")")
+		}
+	}
+	return nil
+}
+
+// releaseConn returns a connection used by the migrations to the pool, resetting the session parameters first.
+//
+// A migration may change session parameters with SET (without LOCAL), e.g. statement_timeout, lock_timeout or
+// search_path. RESET ALL restores all parameters to their connection defaults (including the search_path of the
+// connection string), so the changes do not leak to the next use of the pooled connection. The role is not reset by
+// RESET ALL, so it is reset first (see MigrationConfig.SessionSetup). Parameters set with SET LOCAL end with the
+// transaction.
+func (h *migrationHistory) releaseConn(conn *Database) {
+	if _, err := conn.Execute("RESET ROLE; RESET ALL"); err != nil {
+		h.logger.Error(err)
+	}
+	if err := conn.CloseConn(); err != nil {
+		h.logger.Error(err)
+	}
 }
 
 func (h *migrationHistory) createTable() error {
 
-	if exists, err := h.schemaExists(); err != nil {
-		return err
-	} else if !exists {
-		if errCreateSchema := h.createSchema(); errCreateSchema != nil {
-			return errCreateSchema
+	for _, schema := range h.requiredSchemas() {
+		if exists, err := h.schemaExists(schema); err != nil {
+			return err
+		} else if !exists {
+			if errCreateSchema := h.createSchema(schema); errCreateSchema != nil {
+				return errCreateSchema
+			}
 		}
 	}
 
-	if dbSchema, err := h.newSchemaConnection(h.schemaName); err != nil {
+	if dbSchema, err := h.newSchemaConnection(h.searchPath); err != nil {
 		return err
 	} else {
 		h.dbSchema = dbSchema
@@ -259,22 +464,32 @@ func (h *migrationHistory) createTable() error {
 	if tableExists, err := h.tableExists(); err != nil {
 		return err
 	} else if tableExists {
-		return nil
+		if err = h.checkInstalledOn(); err != nil {
+			return err
+		}
+		return h.addExtraColumns()
 	}
 
 	table := h.tableName
-	sqlCreateTable := strings.Join([]string{
-		"CREATE TABLE " + table + " (",
-		"   installed_rank INT NOT NULL PRIMARY KEY,",
-		"   version VARCHAR(50),",
-		"   description VARCHAR(200) NOT NULL,",
-		"   checksum CHARACTER(32),",
-		"   installed_on TIMESTAMP NOT NULL DEFAULT now(),",
-		"   execution_time INTEGER NOT NULL,",
+	columns := []string{
+		"   installed_rank INT NOT NULL PRIMARY KEY",
+		"   version VARCHAR(50)",
+		"   description VARCHAR(200) NOT NULL",
+		"   checksum CHARACTER(32)",
+		"   installed_on TIMESTAMPTZ NOT NULL DEFAULT now()",
+		"   execution_time INTEGER NOT NULL",
 		"   success BOOLEAN NOT NULL",
-		")"}, "\n")
+	}
+	for _, column := range h.extraColumns {
+		columns = append(columns, "   "+column.sql())
+	}
+	sqlCreateTable := "CREATE TABLE " + h.qualifiedTable() + " (\n" + strings.Join(columns, ",\n") + "\n)"
+
+	sqlCreateIndex := buildCreateIndex(h.qualifiedTable(), table+"_s_idx", false, []string{"success"}, "")
 
-	sqlCreateIndex := "CREATE INDEX " + QuoteIdentifier(table+"_s_idx") + " ON " + QuoteIdentifier(table) + " (success)"
+	if h.createTableSql != nil {
+		sqlCreateTable, sqlCreateIndex = h.createTableSql(h.schemaName, table)
+	}
 
 	retries := retry.New(10, func(ctx context.Context, err error, attempt int, willRetry bool, nextRetry time.Duration) {
 		h.db.logger.Warn("Schema migrationHistory table creation failed. cause: %v", err)
@@ -283,6 +498,9 @@ func (h *migrationHistory) createTable() error {
 		}
 	})
 
+	// invalid columns of a custom DDL are not retried
+	var errColumns error
+
 	err := retries.Execute(context.Background(), func(ctx context.Context, attempt int) error {
 		if tableExists, err := h.tableExists(); err != nil {
 			return err
@@ -300,9 +518,18 @@ func (h *migrationHistory) createTable() error {
 				return err
 			}
 
-			_, err = db.Execute(sqlCreateIndex)
-			return err
+			if sqlCreateIndex != "" {
+				if _, err = db.Execute(sqlCreateIndex); err != nil {
+					return err
+				}
+			}
+
+			errColumns = h.checkTableColumns(db)
+			return errColumns
 		})
+		if errColumns != nil {
+			return nil
+		}
 		if err == nil {
 			h.db.logger.Info("Created Schema migrationHistory table " + table)
 		}
@@ -310,78 +537,207 @@ func (h *migrationHistory) createTable() error {
 		return err
 	})
 
+	if errColumns != nil {
+		return errColumns
+	}
 	return err
 }
 
+// historyColumns the columns required in the history table
+var historyColumns = []string{
+	"installed_rank", "version", "description", "checksum", "installed_on", "execution_time", "success",
+}
+
+// checkTableColumns validates that the created history table has the required columns (and the
+// MigrationConfig.ExtraColumns), which may be missing when the DDL is customized with MigrationConfig.CreateTableSQL
+func (h *migrationHistory) checkTableColumns(db *Database) error {
+	columns, err := QueryColumn[string](db, strings.Join([]string{
+		"SELECT column_name FROM information_schema.columns",
+		"WHERE table_schema = $1",
+		"AND table_name = $2",
+	}, "\n"), h.schemaName, h.tableName)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to read the columns of table %s (cause: %s)", h.tableName, err.Error()))
+	}
+
+	required := append([]string{}, historyColumns...)
+	for _, column := range h.extraColumns {
+		required = append(required, column.Name)
+	}
+	var missing []string
+	for _, column := range required {
+		if !slices.Contains(columns, column) {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return errors.New(fmt.Sprintf(
+			"Schema migrationHistory table %s is missing the columns %s", h.tableName, strings.Join(missing, ", "),
+		))
+	}
+	return nil
+}
+
+// checkInstalledOn converts the installed_on column of a history table created before it was a TIMESTAMPTZ, when
+// MigrationConfig.UpgradeInstalledOn is set. The existing values were recorded in UTC.
+//
+// The conversion runs in its own transaction, before the lock of the migrations is acquired. The table is locked
+// exclusively and the type checked again, so concurrent migrations do not both convert the column.
+func (h *migrationHistory) checkInstalledOn() error {
+	if legacy, err := h.legacyInstalledOn(h.dbSchema); err != nil || !legacy {
+		return err
+	}
+	if !h.installedOnTz {
+		h.logger.Info("Column installed_on of Schema migrationHistory table %s is a TIMESTAMP, see MigrationConfig.UpgradeInstalledOn", h.tableName)
+		return nil
+	}
+
+	return h.dbSchema.Transaction(func(db *Database) error {
+		if _, err := db.Execute("LOCK TABLE " + h.qualifiedTable() + " IN ACCESS EXCLUSIVE MODE"); err != nil {
+			return errors.New("Unable to lock Schema migrationHistory table (cause: " + err.Error() + ")")
+		}
+		if legacy, err := h.legacyInstalledOn(db); err != nil || !legacy {
+			// converted by a concurrent migration
+			return err
+		}
+
+		h.logger.Info("Converting column installed_on of Schema migrationHistory table %s to TIMESTAMPTZ", h.tableName)
+		_, err := db.Execute("ALTER TABLE " + h.qualifiedTable() +
+			" ALTER COLUMN installed_on TYPE TIMESTAMPTZ USING installed_on AT TIME ZONE 'UTC'")
+		if err != nil {
+			return errors.New(fmt.Sprintf("unable to convert installed_on of table %s (cause: %s)", h.tableName, err.Error()))
+		}
+		return nil
+	})
+}
+
+// legacyInstalledOn reports whether the installed_on column of the history table is a TIMESTAMP (without time zone)
+func (h *migrationHistory) legacyInstalledOn(db *Database) (bool, error) {
+	dataType, err := QueryColumn[string](db, strings.Join([]string{
+		"SELECT data_type FROM information_schema.columns",
+		"WHERE table_schema = $1",
+		"AND table_name = $2",
+		"AND column_name = 'installed_on'",
+	}, "\n"), h.schemaName, h.tableName)
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("unable to check the type of installed_on in table %s (cause: %s)", h.tableName, err.Error()))
+	}
+	return len(dataType) > 0 && dataType[0] == "timestamp without time zone", nil
+}
+
+// addExtraColumns adds to an existing history table the MigrationConfig.ExtraColumns that do not exist yet
+func (h *migrationHistory) addExtraColumns() error {
+	for _, column := range h.extraColumns {
+		exists, err := h.db.QueryForBoolean(strings.Join([]string{
+			"SELECT EXISTS (",
+			"    SELECT 1 FROM information_schema.columns",
+			"    WHERE table_schema = $1",
+			"    AND table_name = $2",
+			"    AND column_name = $3",
+			")",
+		}, "\n"), h.schemaName, h.tableName, column.Name)
+		if err != nil {
+			return errors.New(fmt.Sprintf(
+				"unable to check whether column %s exists in table %s (cause: %s)", column.Name, h.tableName, err.Error(),
+			))
+		}
+		if exists {
+			continue
+		}
+
+		h.logger.Info("Adding column %s to Schema migrationHistory table %s", column.Name, h.tableName)
+		if _, err = h.dbSchema.Execute("ALTER TABLE " + h.qualifiedTable() + " ADD COLUMN " + column.sql()); err != nil {
+			return errors.New(fmt.Sprintf(
+				"unable to add column %s to table %s (cause: %s)", column.Name, h.tableName, err.Error(),
+			))
+		}
+	}
+	return nil
+}
+
+// newSchemaConnection opens a pool with the search_path of schema, for the migrations, the lock and the history table.
+// The connections keep the timeout and keepalives of the Config, not the Role (see MigrationConfig.SessionSetup).
 func (h *migrationHistory) newSchemaConnection(schema string) (*Database, error) {
 	d := h.db
-	connStr := d.config.ConnString(map[string]string{"search_path": schema})
-	db, err := sql.Open("postgres", connStr)
+	open := h.open
+	if open == nil {
+		open = openPool
+	}
+	db, err := open(d.config, map[string]string{"search_path": schema}, false)
 	if err != nil {
-		panic(fmt.Sprintf("Unable to connect to database: %v", err))
+		return nil, errors.New(fmt.Sprintf(
+			"Unable to connect to database %s (cause: %s)", d.config.redactedConnString(map[string]string{"search_path": schema}), err.Error(),
+		))
 	}
 
 	return &Database{
-		db:     db,
-		logger: d.logger,
-		config: d.config,
+		db:        db,
+		logger:    d.logger,
+		config:    d.config,
+		metrics:   d.metrics,
+		maxSqlLog: h.maxSqlLog,
 	}, nil
 }
 
-func (h *migrationHistory) schemaExists() (bool, error) {
+// qualifiedTable the quoted name of the history table, qualified by its schema. The history table is always referenced
+// by the qualified name, as the search_path of the connection may not include its schema (see
+// MigrationConfig.HistorySchema)
+func (h *migrationHistory) qualifiedTable() string {
+	return QuoteIdentifier(h.schemaName) + "." + QuoteIdentifier(h.tableName)
+}
+
+// requiredSchemas the schemas created when missing: the schema of the history table and the first schema of the
+// search_path, where the objects of the migrations are created
+func (h *migrationHistory) requiredSchemas() []string {
+	schemas := []string{h.schemaName}
+	first := strings.Trim(strings.TrimSpace(strings.Split(h.searchPath, ",")[0]), `"`)
+	if first != "" && first != h.schemaName && first != "$user" {
+		schemas = append(schemas, first)
+	}
+	return schemas
+}
+
+func (h *migrationHistory) schemaExists(schema string) (bool, error) {
 	exist, err := h.db.QueryForBoolean(
 		"SELECT EXISTS (SELECT schema_name FROM information_schema.schemata WHERE schema_name = $1)",
-		h.schemaName,
+		schema,
 	)
 	if err != nil {
-		return false, errors.New(fmt.Sprintf("unable to check whether schema " + h.schemaName + " exists (cause: " + err.Error() + ")"))
+		return false, errors.New(fmt.Sprintf("unable to check whether schema " + schema + " exists (cause: " + err.Error() + ")"))
 	}
 	return exist, nil
 }
 
 func (h *migrationHistory) tableExists() (bool, error) {
-	exist, err := h.db.QueryForBoolean(strings.Join([]string{
-		"SELECT EXISTS (",
-		"    SELECT 1 FROM pg_catalog.pg_class c",
-		"    JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace",
-		"    WHERE n.nspname = $1",
-		"    AND c.relname = $2",
-		"    AND c.relkind = 'r'",
-		")",
-	}, "\n"), h.schemaName, h.tableName)
-	if err != nil {
-		return false, errors.New(fmt.Sprintf("unable to check whether table " + h.tableName + " exists (cause: " + err.Error() + ")"))
-	}
-
-	return exist, nil
+	return h.db.TableExists(h.schemaName, h.tableName)
 }
 
-func (h *migrationHistory) createSchema() error {
+func (h *migrationHistory) createSchema(schema string) error {
 
 	retries := retry.New(10, func(ctx context.Context, err error, attempt int, willRetry bool, nextRetry time.Duration) {
-		h.db.logger.Warn("Schema %s creation failed.", h.schemaName)
+		h.db.logger.Warn("Schema %s creation failed.", schema)
 		if willRetry {
 			h.db.logger.Info("Retrying in %s", (nextRetry).String())
 		}
 	})
 
 	err := retries.Execute(context.Background(), func(ctx context.Context, attempt int) error {
-		if exists, err := h.schemaExists(); err != nil {
+		if exists, err := h.schemaExists(schema); err != nil {
 			return err
 		} else if exists {
 			return nil
 		}
 
 		if attempt == 1 {
-			h.db.logger.Info("Creating Schema " + h.schemaName + " ...")
+			h.db.logger.Info("Creating Schema " + schema + " ...")
 		}
 
 		err := h.db.Transaction(func(db *Database) error {
-			_, err := db.Execute("CREATE SCHEMA " + h.schemaName + ";")
+			_, err := db.Execute("CREATE SCHEMA " + QuoteIdentifier(schema) + ";")
 			return err
 		})
 		if err == nil {
-			h.db.logger.Info("Created Schema " + h.schemaName)
+			h.db.logger.Info("Created Schema " + schema)
 		}
 
 		return err
@@ -396,27 +752,41 @@ func (h *migrationHistory) addAppliedMigration(info *MigrationInfo, executionTim
 		return errors.New("method can only be invoked when table is locked")
 	}
 
-	// removes any previous faults
 	table := h.tableName
-	_, err := h.dbLock.Execute("DELETE FROM "+table+" WHERE version = $1", info.Version)
-	if err != nil {
-		return errors.New(fmt.Sprintf(
-			"Unable to delete failed row for version %s in Schema migrationHistory table %s (cause: %s)",
-			info.Version, table, err.Error(),
-		))
+
+	// removes any previous faults. When keeping failures, each attempt has its own installed_rank and the latest
+	// attempt of a version prevails
+	if !h.keepFailures {
+		_, err := h.dbLock.Execute("DELETE FROM "+h.qualifiedTable()+" WHERE version = $1", info.Version)
+		if err != nil {
+			return errors.New(fmt.Sprintf(
+				"Unable to delete failed row for version %s in Schema migrationHistory table %s (cause: %s)",
+				info.Version, table, err.Error(),
+			))
+		}
+		h.evictCache(info.Version)
 	}
 
 	installedRank, err := h.calculateInstalledRank()
 	if err == nil {
-		_, err = h.dbLock.InsertInto(h.schemaName, table, map[string]interface{}{
+		values := map[string]interface{}{
 			"installed_rank": installedRank,
 			"version":        info.Version,
 			"description":    info.Description,
 			"checksum":       info.Checksum,
-			"installed_on":   time.Now().UTC().Format(time.RFC3339),
+			"installed_on":   time.Now().UTC(),
 			"execution_time": executionTime,
 			"success":        success,
-		})
+		}
+		if h.extraValues != nil {
+			extra := h.extraValues(info)
+			for _, column := range h.extraColumns {
+				if value, exists := extra[column.Name]; exists {
+					values[column.Name] = value
+				}
+			}
+		}
+		_, err = h.dbLock.InsertInto(h.schemaName, table, values)
 	}
 
 	if err != nil {
@@ -447,6 +817,18 @@ func (h *migrationHistory) calculateInstalledRank() (int, error) {
 	return appliedMigrations[len(appliedMigrations)-1].InstalledRank + 1, nil
 }
 
+// notify sends the new schema version to the MigrationConfig.NotifyChannel, in the transaction of the lock (delivered to
+// the listeners on commit)
+func (h *migrationHistory) notify() error {
+	if h.notifyChannel == "" {
+		return nil
+	}
+	if _, err := h.dbLock.Execute("SELECT pg_notify($1, $2)", h.notifyChannel, h.lastAppliedVersion); err != nil {
+		return errors.New("unable to notify channel " + h.notifyChannel + " (cause: " + err.Error() + ")")
+	}
+	return nil
+}
+
 // lock Acquires an exclusive read-write lock on the schema history table. This lock will be released automatically upon completion.
 func (h *migrationHistory) lock(callback func() error) error {
 
@@ -464,18 +846,18 @@ func (h *migrationHistory) lock(callback func() error) error {
 	// release connection
 	defer func() {
 		h.dbLock = nil
-		if errRelease := lockDb.CloseConn(); errRelease != nil {
-			h.db.logger.Error(errRelease)
-		}
+		h.releaseConn(lockDb)
 	}()
 
 	h.dbLock = lockDb
 
 	var cbErr error
 	err = lockDb.Transaction(func(db *Database) error {
-		// lock table
+		// lock table, SHARE ROW EXCLUSIVE conflicts with itself (and with writes) but not with reads, so concurrent
+		// migrations are serialized without reading the rows of the history. Locking rows (SELECT FOR UPDATE) would
+		// read the whole history and would not lock an empty table.
 		// https://www.postgresql.org/docs/current/explicit-locking.html#LOCKING-TABLES
-		_, err = db.Execute("SELECT * FROM " + h.tableName + " FOR UPDATE")
+		_, err = db.Execute("LOCK TABLE " + h.qualifiedTable() + " IN SHARE ROW EXCLUSIVE MODE")
 		if err != nil {
 			return errors.New("Unable to lock Schema migrationHistory table (cause: " + err.Error() + ")")
 		}
@@ -494,7 +876,13 @@ func (h *migrationHistory) lock(callback func() error) error {
 
 // getAppliedMigrations The list of all migrations applied on the schemaName in the order they were applied (oldest first).
 // An empty list if no migration has been applied so far.
+//
+// The rows already read are cached, only the rows with a greater installed_rank are queried. The cache is guarded by a
+// mutex held during the query, so concurrent calls do not append the same rows twice, and a copy is returned, so the
+// result is not changed by later calls.
 func (h *migrationHistory) getAppliedMigrations() ([]*MigrationInfo, error) {
+	h.cacheMu.Lock()
+	defer h.cacheMu.Unlock()
 
 	maxCachedInstalledRank := -1
 
@@ -506,16 +894,7 @@ func (h *migrationHistory) getAppliedMigrations() ([]*MigrationInfo, error) {
 
 	table := h.tableName
 
-	// See https://www.pgpool.net/docs/latest/en/html/runtime-config-load-balancing.html
-	query := strings.Join([]string{
-		"/*NO LOAD BALANCE*/",
-		"SELECT installed_rank, version, description, checksum, success",
-		"FROM " + table,
-		"WHERE  installed_rank > $1",
-		"ORDER BY  installed_rank",
-	}, " ")
-
-	rows, err := h.dbSchema.Query(query, maxCachedInstalledRank)
+	rows, err := h.dbSchema.Query(h.appliedMigrationsSql(), maxCachedInstalledRank)
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf(
 			"Error while retrieving the list of applied migrations from Schema migrationHistory table "+table+" (cause %s)", err.Error(),
@@ -532,6 +911,11 @@ func (h *migrationHistory) getAppliedMigrations() ([]*MigrationInfo, error) {
 			))
 		}
 
+		if u.InstalledRank <= maxCachedInstalledRank {
+			// already cached
+			continue
+		}
+
 		if success {
 			u.State = MigrationSuccess
 		} else {
@@ -542,9 +926,36 @@ func (h *migrationHistory) getAppliedMigrations() ([]*MigrationInfo, error) {
 	}
 
 	h.sortCache()
-	return h.cache, nil
+	return append([]*MigrationInfo(nil), h.cache...), nil
+}
+
+// appliedMigrationsSql the query of the applied migrations with an installed_rank greater than $1
+func (h *migrationHistory) appliedMigrationsSql() string {
+	// See https://www.pgpool.net/docs/latest/en/html/runtime-config-load-balancing.html
+	return strings.Join([]string{
+		"/*NO LOAD BALANCE*/",
+		"SELECT installed_rank, version, description, checksum, success",
+		"FROM " + h.qualifiedTable(),
+		"WHERE  installed_rank > $1",
+		"ORDER BY  installed_rank",
+	}, " ")
+}
+
+// evictCache removes the cached rows of the version, deleted from the history table (see addAppliedMigration)
+func (h *migrationHistory) evictCache(version string) {
+	h.cacheMu.Lock()
+	defer h.cacheMu.Unlock()
+
+	cache := h.cache[:0]
+	for _, info := range h.cache {
+		if info.Version != version {
+			cache = append(cache, info)
+		}
+	}
+	h.cache = cache
 }
 
+// sortCache sorts the cache by installed_rank, must be called with cacheMu held
 func (h *migrationHistory) sortCache() {
 	sort.SliceStable(h.cache, func(i, j int) bool {
 		return h.cache[i].InstalledRank < h.cache[j].InstalledRank
@@ -568,6 +979,78 @@ func (h *migrationHistory) log(successCount int, executionTime int64, schemaVers
 	)
 }
 
+// prepareMigrations validates and sorts the local migrations by version (repeatable last) and prepares its commands
+// (fast fail)
+func (h *migrationHistory) prepareMigrations() ([]*Migration, error) {
+	migrations := h.db.migrations
+
+	validator := h.versionValidator
+	if validator == nil {
+		validator = SemverValidator
+	}
+	for _, migration := range migrations {
+		if !migration.Repeat && !validator(migration.Info.Version) {
+			return nil, errors.New(fmt.Sprintf("migration has a invalid version (%s)", migration.Info.Version))
+		}
+	}
+
+	sort.SliceStable(migrations, func(i, j int) bool {
+		a := migrations[i]
+		b := migrations[j]
+		if a.Repeat == b.Repeat {
+			return h.compare(a.Info.Version, b.Info.Version) < 0
+		}
+		if a.Repeat {
+			return false
+		}
+		return true
+	})
+
+	for _, migration := range migrations {
+		migration.prepare()
+		if h.ignoreComments {
+			migration.ignoreComments()
+		}
+		if h.rejectEmpty && !migration.Repeat && len(migration.commands) == 0 {
+			return nil, errors.New(fmt.Sprintf(
+				"migration %s has no command, its prepare function must schedule at least one (ExecSql, ExecFn, ...)",
+				migration.Info.Identifier(),
+			))
+		}
+	}
+
+	return migrations, nil
+}
+
+// futureMigrations returns the applied migrations with a version newer than the latest local migration
+func (h *migrationHistory) futureMigrations(appliedMigrations []*MigrationInfo, migrations []*Migration) []*MigrationInfo {
+	latestLocal := ""
+	for _, migration := range migrations {
+		if !migration.Repeat && h.compare(migration.Info.Version, latestLocal) > 0 {
+			latestLocal = migration.Info.Version
+		}
+	}
+
+	var future []*MigrationInfo
+	for _, info := range appliedMigrations {
+		if info.Version != "R" && info.State == MigrationSuccess && h.compare(info.Version, latestLocal) > 0 {
+			future = append(future, info)
+		}
+	}
+	return future
+}
+
+// lastSuccessVersion returns the highest version successfully applied
+func (h *migrationHistory) lastSuccessVersion(appliedMigrations []*MigrationInfo) string {
+	lastAppliedVersion := ""
+	for _, info := range appliedMigrations {
+		if info.Version != "R" && info.State == MigrationSuccess && h.compare(info.Version, lastAppliedVersion) > 0 {
+			lastAppliedVersion = info.Version
+		}
+	}
+	return lastAppliedVersion
+}
+
 func toMigrationText(migration *Migration) string {
 	return fmt.Sprintf("schema to version %s (%s)", migration.Info.Version, migration.Info.Description)
 }
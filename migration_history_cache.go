@@ -0,0 +1,102 @@
+package pg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// migrationCacheEntry is persisted in MigrationConfig.CacheFile after a successful Migrate
+type migrationCacheEntry struct {
+	Checksum       string `json:"checksum"`        // aggregate checksum of the local migrations and target schema
+	InstalledRank  int    `json:"installed_rank"`  // max installed_rank of the history table
+	CurrentVersion string `json:"current_version"` // schema version, for logging
+}
+
+// upToDate checks (fast path) whether nothing changed since the last successful Migrate, using a single
+// SELECT max(installed_rank) on the history table.
+func (h *migrationHistory) upToDate(migrations []*Migration) bool {
+	if h.cacheFile == "" {
+		return false
+	}
+
+	content, err := os.ReadFile(h.cacheFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			h.logger.Warn("Unable to read migration cache file %s (cause: %s)", h.cacheFile, err.Error())
+		}
+		return false
+	}
+
+	var entry migrationCacheEntry
+	if err = json.Unmarshal(content, &entry); err != nil {
+		h.logger.Warn("Invalid migration cache file %s (cause: %s)", h.cacheFile, err.Error())
+		return false
+	}
+
+	if entry.Checksum != h.aggregateChecksum(migrations) {
+		return false
+	}
+
+	installedRank, err := h.maxInstalledRank()
+	if err != nil {
+		// table may not exist yet, follow the normal path
+		return false
+	}
+
+	if int(installedRank) != entry.InstalledRank {
+		return false
+	}
+
+	h.lastAppliedVersion = entry.CurrentVersion
+	return true
+}
+
+// saveCache persists the state of a successful Migrate in the cache file
+func (h *migrationHistory) saveCache(migrations []*Migration) {
+	if h.cacheFile == "" {
+		return
+	}
+
+	installedRank, err := h.maxInstalledRank()
+	if err == nil {
+		var content []byte
+		content, err = json.Marshal(&migrationCacheEntry{
+			Checksum:       h.aggregateChecksum(migrations),
+			InstalledRank:  int(installedRank),
+			CurrentVersion: h.lastAppliedVersion,
+		})
+		if err == nil {
+			err = os.WriteFile(h.cacheFile, content, 0644)
+		}
+	}
+
+	if err != nil {
+		h.logger.Warn("Unable to write migration cache file %s (cause: %s)", h.cacheFile, err.Error())
+	}
+}
+
+func (h *migrationHistory) maxInstalledRank() (int64, error) {
+	return h.db.QueryForInt("/*NO LOAD BALANCE*/ SELECT COALESCE(MAX(installed_rank), 0) FROM " + h.qualifiedTable())
+}
+
+// aggregateChecksum computes a checksum of all local migrations and the target history table
+func (h *migrationHistory) aggregateChecksum(migrations []*Migration) string {
+	config := h.db.config
+	parts := []string{
+		fmt.Sprintf("%s:%d/%s", config.Host, config.Port, config.Database),
+		h.schemaName + "." + h.tableName,
+		h.searchPath,
+	}
+	for _, migration := range migrations {
+		info := migration.Info
+		part := info.Version + "|" + info.Description + "|" + info.Checksum
+		if !h.selected(migration) {
+			part += "|skipped"
+		}
+		parts = append(parts, part)
+	}
+	return hash(strings.Join(parts, "\n"))
+}
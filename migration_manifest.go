@@ -0,0 +1,106 @@
+package pg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+// MigrationManifest the manifest read by AddMigrationsManifest
+//
+//	{
+//	  "migrations": [
+//	    {"file": "v1.0.0_create_users.sql"},
+//	    {"file": "users/002.sql", "version": "1.1.0", "description": "add email", "checksum": "9b1f..."}
+//	  ]
+//	}
+type MigrationManifest struct {
+	Migrations []MigrationManifestEntry `json:"migrations"`
+}
+
+// MigrationManifestEntry a migration file of the manifest
+type MigrationManifestEntry struct {
+	File        string `json:"file"`                  // path of the SQL file, relative to the manifest
+	Version     string `json:"version,omitempty"`     // defaults to the version of the file name
+	Description string `json:"description,omitempty"` // defaults to the description of the file name
+	Checksum    string `json:"checksum,omitempty"`    // expected checksum of the migration, see Migration.Checksum
+}
+
+// AddMigrationsManifest registers the migrations listed in a JSON manifest (see MigrationManifest), in the order of
+// the manifest, instead of scanning the files of a directory.
+//
+// When an entry declares the checksum (the one recorded in the history table, see Migration.Checksum), it is compared
+// with the checksum of the file, so an accidental edit of the file fails before running anything. The migrations still
+// run in the order of their versions, the manifest must list them in that order (see
+// MigrationOptions.VersionComparator). Nothing is registered when the manifest or any migration is invalid.
+func (d *Database) AddMigrationsManifest(dir fs.FS, manifestPath string) error {
+	content, err := fs.ReadFile(dir, manifestPath)
+	if err != nil {
+		return errors.New("unable to read migration manifest " + manifestPath + " (cause: " + err.Error() + ")")
+	}
+
+	var manifest MigrationManifest
+	if err = json.Unmarshal(content, &manifest); err != nil {
+		return errors.New("invalid migration manifest " + manifestPath + " (cause: " + err.Error() + ")")
+	}
+
+	var files []migrationFile
+	last := -1 // last entry with a version (repeatable migrations are not ordered)
+	for i, entry := range manifest.Migrations {
+		if entry.File == "" {
+			return errors.New(fmt.Sprintf("entry %d of migration manifest %s has no file", i+1, manifestPath))
+		}
+		filepath := path.Join(path.Dir(manifestPath), entry.File)
+
+		version, description := entry.Version, entry.Description
+		if version == "" || description == "" {
+			fileVersion, fileDescription, err := parseMigrationFileName(filepath)
+			if err != nil {
+				return err
+			}
+			if version == "" {
+				version = fileVersion
+			}
+			if description == "" {
+				description = fileDescription
+			}
+		}
+
+		sql, err := fs.ReadFile(dir, filepath)
+		if err != nil {
+			return errors.New("unable to read migration " + filepath + " (cause: " + err.Error() + ")")
+		}
+
+		if entry.Checksum != "" {
+			migration := &Migration{Info: &MigrationInfo{Version: version}}
+			migration.ExecSql(string(sql))
+			if migration.Info.Checksum != entry.Checksum {
+				return errors.New(fmt.Sprintf(
+					"migration v%s (%s) was modified, the checksum declared in %s is %s, the file has %s",
+					version, filepath, manifestPath, entry.Checksum, migration.Info.Checksum,
+				))
+			}
+		}
+
+		files = append(files, migrationFile{
+			path:        filepath,
+			version:     version,
+			description: description,
+			content:     string(sql),
+		})
+
+		if version != "R" {
+			if last >= 0 && d.compareVersions(files[last].version, version) >= 0 {
+				return errors.New(fmt.Sprintf(
+					"migration manifest %s is not in the order of the versions, v%s (%s) is listed after v%s (%s)",
+					manifestPath, version, filepath, files[last].version, files[last].path,
+				))
+			}
+			last = len(files) - 1
+		}
+	}
+
+	return d.addMigrationFiles(files)
+}
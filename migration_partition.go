@@ -0,0 +1,35 @@
+package pg
+
+import "strings"
+
+// CreatePartition Schedule the creation of a partition of a range partitioned table (PostgreSQL 10+) in this migration
+//
+// The parent and name may be qualified by the schema ("public.events"), each part is quoted. from and to are the
+// bounds as SQL, e.g. a literal ('2024-01-01') or MINVALUE/MAXVALUE, the upper bound is exclusive. As the SQL contains
+// all parameters, changing any of them changes the checksum. Ex.
+//
+//	m.CreatePartition("events", "events_2024_01", "'2024-01-01'", "'2024-02-01'")
+func (m *Migration) CreatePartition(parent, name, from, to string) {
+	m.ExecSql("CREATE TABLE " + quoteQualifiedName(name) + " PARTITION OF " + quoteQualifiedName(parent) +
+		" FOR VALUES FROM (" + from + ") TO (" + to + ")")
+}
+
+// AttachPartition Schedule the attachment of an existing table as a partition of a range partitioned table in this
+// migration, see CreatePartition
+func (m *Migration) AttachPartition(parent, name, from, to string) {
+	m.ExecSql("ALTER TABLE " + quoteQualifiedName(parent) + " ATTACH PARTITION " + quoteQualifiedName(name) +
+		" FOR VALUES FROM (" + from + ") TO (" + to + ")")
+}
+
+// DetachPartition Schedule the detachment of a partition in this migration, the partition becomes a regular table
+func (m *Migration) DetachPartition(parent, name string) {
+	m.ExecSql("ALTER TABLE " + quoteQualifiedName(parent) + " DETACH PARTITION " + quoteQualifiedName(name))
+}
+
+// quoteQualifiedName quotes a name optionally qualified by the schema ("schema.table")
+func quoteQualifiedName(name string) string {
+	if schema, table, qualified := strings.Cut(name, "."); qualified {
+		return QuoteIdentifier(schema) + "." + QuoteIdentifier(table)
+	}
+	return QuoteIdentifier(name)
+}
@@ -0,0 +1,68 @@
+package pg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MigrationRename renames the history table of config (MigrationConfig.Table) to newTable, in the same schema, e.g.
+// to adopt the default pg_schema_history name after migrating from another tool.
+//
+// The rename runs under the lock of the history table, so it waits for a concurrent Migrate. The rows are preserved,
+// the primary key and the index of the table are renamed to match the new name. Fails when the history table does not
+// exist or when newTable already exists. Use the new name in the MigrationConfig of the following runs.
+func (d *Database) MigrationRename(config *MigrationConfig, newTable string) error {
+	history, release, err := d.newMigrationHistory(config)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return history.rename(newTable)
+}
+
+func (h *migrationHistory) rename(newTable string) error {
+	if newTable == "" || newTable == h.tableName {
+		return errors.New(fmt.Sprintf("invalid new name for the history table %s: %q", h.qualifiedTable(), newTable))
+	}
+
+	if exists, err := h.tableExists(); err != nil {
+		return err
+	} else if !exists {
+		return errors.New(fmt.Sprintf("history table %s does not exist", h.qualifiedTable()))
+	}
+
+	dbSchema, err := h.newSchemaConnection(h.searchPath)
+	if err != nil {
+		return err
+	}
+	defer dbSchema.Close()
+	h.dbSchema = dbSchema
+
+	oldTable := h.tableName
+	schema := QuoteIdentifier(h.schemaName)
+
+	return h.lock(func() error {
+		// checked under the lock, a concurrent rename may have created it
+		if exists, err := h.dbLock.TableExists(h.schemaName, newTable); err != nil {
+			return err
+		} else if exists {
+			return errors.New(fmt.Sprintf("unable to rename history table %s, table %s already exists", h.qualifiedTable(), newTable))
+		}
+
+		statements := []string{
+			"ALTER TABLE " + h.qualifiedTable() + " RENAME TO " + QuoteIdentifier(newTable),
+			// renaming the index of the primary key also renames the constraint
+			"ALTER INDEX IF EXISTS " + schema + "." + QuoteIdentifier(oldTable+"_pkey") + " RENAME TO " + QuoteIdentifier(newTable+"_pkey"),
+			"ALTER INDEX IF EXISTS " + schema + "." + QuoteIdentifier(oldTable+"_s_idx") + " RENAME TO " + QuoteIdentifier(newTable+"_s_idx"),
+		}
+		for _, statement := range statements {
+			if _, err := h.dbLock.Execute(statement); err != nil {
+				return errors.New("unable to rename history table " + h.qualifiedTable() + " (cause: " + err.Error() + ")")
+			}
+		}
+
+		h.logger.Info("Renamed Schema migrationHistory table %s to %s", oldTable, newTable)
+		return nil
+	})
+}
@@ -0,0 +1,31 @@
+package pg
+
+import "slices"
+
+// Tag Tags this migration, e.g. "analytics" for migrations of an optional feature. A tagged migration is only applied
+// when at least one of its tags is in MigrationConfig.IncludeTags and none is in MigrationConfig.ExcludeTags, so each
+// environment can apply a subset of the migrations of the same codebase.
+//
+// The tags are not part of the checksum. A skipped migration remains pending, including its tag later with a lower
+// version than the current schema version requires MigrationConfig.OutOfOrder.
+func (m *Migration) Tag(tags ...string) {
+	m.Info.Tags = append(m.Info.Tags, tags...)
+}
+
+// selected checks whether the migration is applied by the tags of the config, see Migration.Tag
+func (h *migrationHistory) selected(migration *Migration) bool {
+	tags := migration.Info.Tags
+	if len(tags) == 0 {
+		return true
+	}
+	included := false
+	for _, tag := range tags {
+		if slices.Contains(h.excludeTags, tag) {
+			return false
+		}
+		if slices.Contains(h.includeTags, tag) {
+			included = true
+		}
+	}
+	return included
+}
@@ -0,0 +1,66 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"text/template"
+)
+
+// migrationTemplateFuncs functions available in ExecSqlTemplate
+var migrationTemplateFuncs = template.FuncMap{
+	"quote": QuoteLiteral,
+	"ident": QuoteIdentifier,
+	// seq returns the integers [0, n), to be used with range
+	"seq": func(n int) []int {
+		s := make([]int, n)
+		for i := range s {
+			s[i] = i
+		}
+		return s
+	},
+	"add": func(a, b int) int {
+		return a + b
+	},
+}
+
+// ExecSqlTemplate Schedule the execution of an SQL command rendered from a text/template in this migration.
+//
+// The checksum is computed on the rendered SQL, so changing the template data changes the checksum. In addition to
+// the builtin template functions, the template can use quote (QuoteLiteral), ident (QuoteIdentifier), seq (the
+// integers from 0 to n-1) and add. Ex.
+//
+//	m.ExecSqlTemplate(`
+//		{{range $i := seq .Months}}
+//		CREATE TABLE {{ident (printf "events_%02d" (add $i 1))}} PARTITION OF events
+//			FOR VALUES FROM ({{add $i 1}}) TO ({{add $i 2}});
+//		{{end}}`, map[string]interface{}{"Months": 12})
+//
+// When the template is invalid, the migration fails when applied.
+func (m *Migration) ExecSqlTemplate(tmpl string, data interface{}) {
+	sql, err := renderSqlTemplate(tmpl, data)
+	if err != nil {
+		m.commands = append(m.commands, &migrationCommandCallback{
+			Caller: "template",
+			Callback: func(ctx context.Context, db *Database, migration *Migration, args ...interface{}) error {
+				return err
+			},
+		})
+		m.addSqlChecksum(tmpl)
+		return
+	}
+	m.ExecSql(sql)
+}
+
+func renderSqlTemplate(tmpl string, data interface{}) (string, error) {
+	t, err := template.New("migration").Funcs(migrationTemplateFuncs).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", errors.New("invalid migration template (cause: " + err.Error() + ")")
+	}
+
+	var sql strings.Builder
+	if err = t.Execute(&sql, data); err != nil {
+		return "", errors.New("unable to render migration template (cause: " + err.Error() + ")")
+	}
+	return sql.String(), nil
+}
@@ -9,11 +9,19 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
+	"unicode/utf8"
 
 	"github.com/dhui/dktest"
+	"github.com/lib/pq"
 )
 
 const (
@@ -103,41 +111,2521 @@ var migrationsFs embed.FS
 
 func Test(t *testing.T) {
 	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
-		ip, port, err := c.FirstPort()
+		db := openTestDatabase(t, c)
+		defer func() {
+			if err := db.CloseConn(); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		err := db.AddMigrations(migrationsFs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = db.Migrate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// dt.Test(t, db, []byte("SELECT 1"))
+	})
+}
+
+func TestMigrateKeepFailures(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		config := &MigrationConfig{Table: "history_keep_failures", KeepFailures: true}
+
+		err := db.AddMigration("1.0.0", "Create Table", func(migration *Migration) {
+			migration.ExecSql("CREATE TABLE keep_failures (id INT PRIMARY KEY, invalid_type_here)")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = db.Migrate(config); err == nil {
+			t.Fatal("expected migration to fail")
+		}
+
+		db.migrations = nil
+		err = db.AddMigration("1.0.0", "Create Table", func(migration *Migration) {
+			migration.ExecSql("CREATE TABLE keep_failures (id INT PRIMARY KEY)")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = db.Migrate(config); err != nil {
+			t.Fatal(err)
+		}
+
+		failed, err := db.QueryForInt("SELECT COUNT(*) FROM history_keep_failures WHERE version = '1.0.0' AND NOT success")
+		if err != nil {
+			t.Fatal(err)
+		}
+		succeeded, err := db.QueryForInt("SELECT COUNT(*) FROM history_keep_failures WHERE version = '1.0.0' AND success")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failed != 1 || succeeded != 1 {
+			t.Errorf("expected 1 failed and 1 successful rows, got %d failed and %d successful", failed, succeeded)
+		}
+	})
+}
+
+func openTestDatabase(t *testing.T, c dktest.ContainerInfo) *Database {
+	ip, port, err := c.FirstPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	portInt, _ := strconv.Atoi(port)
+	db, err := Open(&Config{
+		Username: "postgres",
+		Password: "postgres",
+		Host:     ip,
+		Port:     portInt,
+		Database: "postgres",
+		SSLMode:  "disable",
+		DebugSql: true,
+		Logger:   nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMigrationExecFn(t *testing.T) {
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+
+	var calls []string
+	migration := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	migration.ExecFn("legacy", func(db *Database, migration *Migration, args ...interface{}) error {
+		calls = append(calls, fmt.Sprintf("legacy %v", args))
+		return nil
+	}, 1)
+	migration.ExecFnCtx("ctx", func(ctx context.Context, db *Database, migration *Migration, args ...interface{}) error {
+		calls = append(calls, fmt.Sprintf("ctx %v %v", ctx.Value(ctxKey{}), args))
+		return nil
+	}, 2)
+
+	for _, cmd := range migration.commands {
+		if !strings.Contains(cmd.debug(0), "migration_test.go") {
+			t.Errorf("expected caller to be the test file, got %s", cmd.debug(0))
+		}
+		if err := cmd.run(ctx, nil, migration); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if want := []string{"legacy [1]", "ctx value [2]"}; strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Errorf("unexpected calls %v, want %v", calls, want)
+	}
+}
+
+func TestMigrationError(t *testing.T) {
+	cause := errors.New("syntax error")
+	var err error = errors.Join(nil, &MigrationError{Version: "1.0.0", Command: 2, SQL: "SELEC 1", Cause: cause})
+
+	var migrationErr *MigrationError
+	if !errors.As(err, &migrationErr) {
+		t.Fatal("expected a MigrationError")
+	}
+	if migrationErr.Version != "1.0.0" || migrationErr.Command != 2 || migrationErr.SQL != "SELEC 1" {
+		t.Errorf("unexpected MigrationError %+v", migrationErr)
+	}
+	if !errors.Is(err, cause) {
+		t.Error("expected MigrationError to wrap the cause")
+	}
+	if want := "Migration failed !\n    Caused by: syntax error"; err.Error() != want {
+		t.Errorf("unexpected message %q, want %q", err.Error(), want)
+	}
+}
+
+func TestFutureMigrations(t *testing.T) {
+	migrations := []*Migration{
+		{Info: &MigrationInfo{Version: "1.0.0"}},
+		{Info: &MigrationInfo{Version: "1.1.0"}},
+		{Info: &MigrationInfo{Version: "R"}, Repeat: true},
+	}
+	applied := []*MigrationInfo{
+		{Version: "1.0.0", State: MigrationSuccess},
+		{Version: "1.1.0", State: MigrationSuccess},
+		{Version: "R", State: MigrationSuccess},
+		{Version: "1.2.0", State: MigrationSuccess},
+		{Version: "1.3.0", State: MigrationFailed},
+	}
+
+	history := &migrationHistory{}
+
+	future := history.futureMigrations(applied, migrations)
+	if len(future) != 1 || future[0].Version != "1.2.0" {
+		t.Errorf("unexpected future migrations %v", future)
+	}
+
+	if future = history.futureMigrations(applied[:3], migrations); len(future) != 0 {
+		t.Errorf("expected no future migrations, got %v", future)
+	}
+}
+
+func TestMigrateCacheFile(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		cacheFile := t.TempDir() + "/migration.cache"
+		newConfig := func() *MigrationConfig {
+			return &MigrationConfig{Table: "history_cache", CacheFile: cacheFile}
+		}
+
+		if err := db.AddMigrations(migrationsFs); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Migrate(newConfig()); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.AddMigrations(migrationsFs); err != nil {
+			t.Fatal(err)
+		}
+		history, release, err := db.newMigrationHistory(newConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+
+		migrations, err := history.prepareMigrations()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !history.upToDate(migrations) {
+			t.Error("expected unchanged migrations to be up to date")
+		}
+
+		if _, err = db.Execute("DELETE FROM history_cache WHERE version = '1.1.0'"); err != nil {
+			t.Fatal(err)
+		}
+		if history.upToDate(migrations) {
+			t.Error("expected changed history table to invalidate the cache")
+		}
+	})
+}
+
+func TestMigrationVersions(t *testing.T) {
+	db := &Database{}
+	for _, version := range []string{"20240115123000", "20231201000000", "20240115090000"} {
+		if err := db.AddMigration(version, "Migration "+version, func(migration *Migration) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.AddMigration("001", "Sequential", func(migration *Migration) {}); err == nil {
+		t.Error("expected sequential version 001 to be rejected by the default validator")
+	}
+
+	db.SetMigrationOptions(MigrationOptions{VersionComparator: NumericComparator, VersionValidator: NumericValidator})
+	if err := db.AddMigration("001", "Sequential", func(migration *Migration) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddMigration("1", "Sequential duplicate", func(migration *Migration) {}); err == nil ||
+		!strings.Contains(err.Error(), "found more than one migration with version 1") {
+		t.Errorf("expected 1 to be a duplicate of 001, got %v", err)
+	}
+
+	history := &migrationHistory{db: db}
+	if _, err := history.prepareMigrations(); err == nil {
+		t.Error("expected sequential version 001 to be rejected by the default validator of Migrate")
+	}
+
+	history = &migrationHistory{db: db, versionComparator: NumericComparator, versionValidator: NumericValidator}
+	migrations, err := history.prepareMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var versions []string
+	for _, migration := range migrations {
+		versions = append(versions, migration.Info.Version)
+	}
+	if want := "001,20231201000000,20240115090000,20240115123000"; strings.Join(versions, ",") != want {
+		t.Errorf("unexpected order %v, want %s", versions, want)
+	}
+
+	if NumericComparator("002", "10") != -1 || NumericComparator("010", "10") != 0 || NumericComparator("2", "1") != 1 {
+		t.Error("unexpected NumericComparator result")
+	}
+	if history.compare("", "001") != -1 {
+		t.Error("empty version must be lower than any other")
+	}
+}
+
+func TestMigrationVerify(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		config := &MigrationConfig{Table: "history_verify"}
+
+		if err := db.AddMigrations(migrationsFs); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Migrate(config); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := db.Execute("UPDATE history_verify SET checksum = 'changed', description = 'changed' WHERE version = '1.0.0'")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = db.AddMigrations(migrationsFs); err != nil {
+			t.Fatal(err)
+		}
+		err = db.MigrationVerify(config)
+		if err == nil {
+			t.Fatal("expected verification error")
+		}
+		if msg := err.Error(); !strings.Contains(msg, "checksum mismatch") || !strings.Contains(msg, "description mismatch") {
+			t.Errorf("expected all mismatches to be reported, got %s", msg)
+		}
+	})
+}
+
+func TestAddMigrationsSources(t *testing.T) {
+	fromFs := &Database{}
+	if err := fromFs.AddMigrations(migrationsFs); err != nil {
+		t.Fatal(err)
+	}
+
+	fromDir := &Database{}
+	if err := fromDir.AddMigrationsFromDir("testing/migrations"); err != nil {
+		t.Fatal(err)
+	}
+
+	fromSlice := &Database{}
+	var sources []MigrationSource
+	for _, name := range []string{"v1.0.0_create_user_table.sql", "v1.1.0_create_books_table.sql"} {
+		content, err := migrationsFs.ReadFile("testing/migrations/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := strings.SplitN(strings.TrimSuffix(strings.TrimPrefix(name, "v"), ".sql"), "_", 2)
+		sources = append(sources, MigrationSource{
+			Version:     parts[0],
+			Description: strings.ReplaceAll(parts[1], "_", " "),
+			SQL:         string(content),
+		})
+	}
+	if err := fromSlice.AddMigrationsFromSlice(sources); err != nil {
+		t.Fatal(err)
+	}
+
+	fromReader := &Database{}
+	for _, source := range sources {
+		if err := fromReader.AddMigrationReader(source.Version, source.Description, strings.NewReader(source.SQL)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checksums := func(db *Database) string {
+		var result []string
+		for _, migration := range db.migrations {
+			migration.prepare()
+			result = append(result, migration.Info.Version+" "+migration.Info.Description+" "+migration.Info.Checksum)
+		}
+		return strings.Join(result, "\n")
+	}
+
+	expected := checksums(fromFs)
+	if len(fromFs.migrations) != 2 {
+		t.Fatalf("expected 2 migrations, got %d", len(fromFs.migrations))
+	}
+	if got := checksums(fromDir); got != expected {
+		t.Errorf("AddMigrationsFromDir:\n%s\nwant\n%s", got, expected)
+	}
+	if got := checksums(fromSlice); got != expected {
+		t.Errorf("AddMigrationsFromSlice:\n%s\nwant\n%s", got, expected)
+	}
+	if got := checksums(fromReader); got != expected {
+		t.Errorf("AddMigrationReader:\n%s\nwant\n%s", got, expected)
+	}
+}
+
+type failingReader struct{}
+
+func (r failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+func TestAddMigrationReaderErrors(t *testing.T) {
+	db := &Database{}
+
+	err := db.AddMigrationReader("1.0.0", "remote", failingReader{})
+	if err == nil || !strings.Contains(err.Error(), "unable to read migration v1.0.0") ||
+		!strings.Contains(err.Error(), "connection reset by peer") {
+		t.Errorf("expected read error, got %v", err)
+	}
+
+	if err = db.AddMigrationReader("1.0.0", "remote", strings.NewReader(" \n ")); err == nil ||
+		!strings.Contains(err.Error(), "empty body") {
+		t.Errorf("expected empty body error, got %v", err)
+	}
+
+	if len(db.migrations) != 0 {
+		t.Errorf("expected no migration registered, got %d", len(db.migrations))
+	}
+}
+
+func TestAddMigrationsMany(t *testing.T) {
+	users := fstest.MapFS{
+		"migrations/v1.0.0_create_users.sql": {Data: []byte("CREATE TABLE users (id INT)")},
+		"migrations/R_refresh_views.sql":     {Data: []byte("SELECT 1")},
+	}
+	billing := fstest.MapFS{
+		"v2.0.0_create_invoices.sql": {Data: []byte("CREATE TABLE invoices (id INT)")},
+		"R_refresh_reports.sql":      {Data: []byte("SELECT 1")},
+	}
+	conflicting := fstest.MapFS{
+		"v1.0.0_create_orders.sql": {Data: []byte("CREATE TABLE orders (id INT)")},
+	}
+
+	db := &Database{}
+	if err := db.AddMigrationsMany(users, billing); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.migrations) != 4 {
+		t.Errorf("expected 4 migrations, got %d", len(db.migrations))
+	}
+
+	db = &Database{}
+	err := db.AddMigrationsMany(users, billing, conflicting)
+	want := "found more than one migration with version 1.0.0\nOffenders:\n" +
+		"-> source 1: migrations/v1.0.0_create_users.sql\n-> source 3: v1.0.0_create_orders.sql"
+	if err == nil || err.Error() != want {
+		t.Errorf("got  %v\nwant %s", err, want)
+	}
+	if len(db.migrations) != 0 {
+		t.Errorf("expected no migration registered, got %d", len(db.migrations))
+	}
+}
+
+func TestAddMigrationsTwice(t *testing.T) {
+	dir := fstest.MapFS{
+		"v1.0.0_create_users.sql": {Data: []byte("CREATE TABLE users (id INT)")},
+		"R_refresh_views.sql":     {Data: []byte("SELECT 1")},
+	}
+
+	db := &Database{}
+	for i := 0; i < 2; i++ {
+		if err := db.AddMigrations(dir); err != nil {
+			t.Fatalf("expected scanning the same files to be a no-op, got %v", err)
+		}
+	}
+	if err := db.AddMigrationsMany(dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.migrations) != 2 {
+		t.Errorf("expected 2 migrations, got %d", len(db.migrations))
+	}
+
+	changed := fstest.MapFS{
+		"v1.0.0_create_users.sql": {Data: []byte("CREATE TABLE users (id BIGINT)")},
+	}
+	if err := db.AddMigrations(changed); err == nil || !strings.Contains(err.Error(), "more than one migration with version 1.0.0") {
+		t.Errorf("expected conflict for a different content, got %v", err)
+	}
+
+	renamed := fstest.MapFS{
+		"v1.0.0_create_accounts.sql": {Data: []byte("CREATE TABLE users (id INT)")},
+	}
+	if err := db.AddMigrations(renamed); err == nil {
+		t.Error("expected conflict for a different description")
+	}
+
+	changedRepeatable := fstest.MapFS{
+		"R_refresh_views.sql": {Data: []byte("SELECT 2")},
+	}
+	if err := db.AddMigrations(changedRepeatable); err == nil {
+		t.Error("expected conflict for a repeatable migration with a different content")
+	}
+}
+
+func TestAddMigrationDuplicates(t *testing.T) {
+	db := &Database{}
+	noop := func(migration *Migration) {}
+
+	if err := db.AddMigration("R", "Refresh views", noop); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddMigration("R", "Refresh functions", noop); err != nil {
+		t.Fatalf("repeatable migrations with distinct descriptions must be accepted: %v", err)
+	}
+
+	err := db.AddMigration("R", "Refresh views", noop)
+	if err == nil || !strings.Contains(err.Error(), "repeatable migration with description Refresh views") {
+		t.Errorf("expected duplicated repeatable description error, got %v", err)
+	} else if !strings.Contains(err.Error(), "-> registration 1\n-> registration 3") {
+		t.Errorf("expected the registration order of the offenders, got %v", err)
+	}
+
+	if err = db.AddMigration("1.0.0", "Create users", noop); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AddMigration("1.0.0", "Create books", noop); err == nil {
+		t.Error("expected duplicated version error")
+	}
+}
+
+func TestAddMigrationLongDescription(t *testing.T) {
+	noop := func(migration *Migration) {}
+	logger := &recordingLogger{}
+	db := &Database{logger: logger}
+
+	// 150 emoji, 600 bytes but 150 characters
+	emoji := strings.Repeat("\U0001F680", 150)
+	if err := db.AddMigration("1.0.0", emoji, noop); err != nil {
+		t.Fatal(err)
+	}
+	if db.migrations[0].Info.Description != emoji || len(logger.warnings) != 0 {
+		t.Errorf("expected description to be kept, got %d characters", len([]rune(db.migrations[0].Info.Description)))
+	}
+
+	// e + combining acute accent, 2 characters each
+	combining := strings.Repeat("e\u0301", 101)
+	if err := db.AddMigration("1.1.0", combining, noop); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.migrations[1].Info.Description; got != combining[:len(combining)-len("e\u0301")] {
+		t.Errorf("expected description truncated to 200 characters, got %d", len([]rune(got)))
+	}
+	if len(logger.warnings) != 1 || !strings.Contains(logger.warnings[0], "truncated to 200 characters (v1.1.0)") {
+		t.Errorf("expected truncation warning, got %v", logger.warnings)
+	}
+
+	// repeatable migrations differing only after the limit
+	if err := db.AddMigration("R", strings.Repeat("a", 200)+"1", noop); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddMigration("R", strings.Repeat("a", 200)+"2", noop); err == nil {
+		t.Error("expected duplicated repeatable description error after truncation")
+	}
+
+	strict := &Database{}
+	strict.SetMigrationOptions(MigrationOptions{StrictDescription: true})
+	err := strict.AddMigration("1.0.0", strings.Repeat("\U0001F680", 201), noop)
+	if err == nil || !strings.Contains(err.Error(), "exceeds 200 characters") {
+		t.Errorf("expected strict mode error, got %v", err)
+	}
+	if err = strict.AddMigration("1.0.0", emoji, noop); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestMigrateLongDescription(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		description := strings.Repeat("\U0001F469\U0001F3FD", 101)
+		config := &MigrationConfig{Table: "history_description"}
+		for i := 0; i < 2; i++ {
+			if err := db.AddMigration("1.0.0", description, func(migration *Migration) {}); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Migrate(config); err != nil {
+				t.Fatalf("run %d: %v", i+1, err)
+			}
+		}
+
+		if err := db.AddMigration("1.0.0", description, func(migration *Migration) {}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.MigrationVerify(config); err != nil {
+			t.Errorf("expected stored description to match, got %v", err)
+		}
+	})
+}
+
+func TestDiffMigrations(t *testing.T) {
+	newSet := func(migrations ...MigrationSource) []*Migration {
+		db := &Database{}
+		if err := db.AddMigrationsFromSlice(migrations); err != nil {
+			t.Fatal(err)
+		}
+		return db.migrations
+	}
+
+	users := MigrationSource{Version: "1.0.0", Description: "Create users", SQL: "CREATE TABLE users ()"}
+	books := MigrationSource{Version: "1.1.0", Description: "Create books", SQL: "CREATE TABLE books ()"}
+	views := MigrationSource{Version: "R", Description: "Views", SQL: "CREATE OR REPLACE VIEW v AS SELECT 1"}
+
+	diffs, err := DiffMigrations(newSet(users, books, views), newSet(views, books, users))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("reordering must not produce a diff, got %v", diffs)
+	}
+
+	changedBooks := books
+	changedBooks.SQL = "CREATE TABLE books (id INT)"
+	authors := MigrationSource{Version: "1.2.0", Description: "Create authors", SQL: "CREATE TABLE authors ()"}
+
+	diffs, err = DiffMigrations(newSet(users, books, views), newSet(changedBooks, authors, views))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, diff := range diffs {
+		got = append(got, fmt.Sprintf("%d %s", diff.Type, diffInfo(diff).Version))
+	}
+	if want := "2 1.0.0,3 1.1.0,1 1.2.0"; strings.Join(got, ",") != want {
+		t.Errorf("unexpected diffs %v, want %s", got, want)
+	}
+
+	numeric := &Database{}
+	numeric.SetMigrationOptions(MigrationOptions{VersionComparator: NumericComparator, VersionValidator: NumericValidator})
+	if err = numeric.AddMigrationsFromSlice([]MigrationSource{
+		{Version: "10", Description: "Ten", SQL: "SELECT 10"},
+		{Version: "002", Description: "Two", SQL: "SELECT 2"},
+		{Version: "9", Description: "Nine", SQL: "SELECT 9"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	diffs, err = DiffMigrations(nil, numeric.migrations, NumericComparator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, diff := range diffs {
+		got = append(got, fmt.Sprintf("%d %s", diff.Type, diffInfo(diff).Version))
+	}
+	if want := "1 002,1 9,1 10"; strings.Join(got, ",") != want {
+		t.Errorf("unexpected diffs %v, want %s", got, want)
+	}
+}
+
+func TestMigrateExtraColumns(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		if err := db.AddMigrations(migrationsFs); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Migrate(&MigrationConfig{Table: "history_extra"}); err != nil {
+			t.Fatal(err)
+		}
+
+		err := db.AddMigration("2.0.0", "Create authors", func(migration *Migration) {
+			migration.ExecSql("CREATE TABLE authors (id INT)")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.Migrate(&MigrationConfig{
+			Table:        "history_extra",
+			ExtraColumns: []ColumnDef{{Name: "git_sha", Type: "VARCHAR(40)"}},
+			ExtraValues: func(info *MigrationInfo) map[string]interface{} {
+				return map[string]interface{}{"git_sha": "abc123", "success": false}
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var sha string
+		var success bool
+		err = db.QueryRowOld("SELECT git_sha, success FROM history_extra WHERE version = '2.0.0'").Scan(&sha, &success)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sha != "abc123" || !success {
+			t.Errorf("unexpected extra values git_sha=%s success=%v", sha, success)
+		}
+	})
+}
+
+func TestMigrateHistorySchema(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		err := db.AddMigration("1.0.0", "Create items", func(migration *Migration) {
+			migration.ExecSql("CREATE TABLE items (id INT)")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = db.Migrate(&MigrationConfig{HistorySchema: "migrations", MigrationSearchPath: "app, public"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tableSchema := func(table string) string {
+			var schema string
+			err := db.QueryRowOld("SELECT table_schema FROM information_schema.tables WHERE table_name = $1", table).Scan(&schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return schema
+		}
+		if schema := tableSchema("items"); schema != "app" {
+			t.Errorf("expected migration objects in schema app, got %s", schema)
+		}
+		if schema := tableSchema("pg_schema_history"); schema != "migrations" {
+			t.Errorf("expected history table in schema migrations, got %s", schema)
+		}
+
+		// up to date
+		if err = db.Migrate(&MigrationConfig{HistorySchema: "migrations", MigrationSearchPath: "app, public"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestMigrateConcurrent(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		const instances = 3
+
+		var wg sync.WaitGroup
+		errs := make(chan error, instances)
+		for i := 0; i < instances; i++ {
+			db := openTestDatabase(t, c)
+			defer db.Close()
+
+			err := db.AddMigration("1.0.0", "Slow migration", func(migration *Migration) {
+				migration.ExecSql("CREATE TABLE IF NOT EXISTS slow_runs (id SERIAL)")
+				migration.ExecSql("INSERT INTO slow_runs DEFAULT VALUES")
+				migration.ExecSql("SELECT pg_sleep(0.3)")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- db.Migrate(&MigrationConfig{Table: "history_concurrent"})
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Error(err)
+			}
+		}
+
+		db := openTestDatabase(t, c)
+		defer db.Close()
+		if count, err := db.QueryForInt("SELECT count(*) FROM slow_runs"); err != nil || count != 1 {
+			t.Errorf("expected the migration to run once, got %d (%v)", count, err)
+		}
+	})
+}
+
+// BenchmarkMigrationLock measures the lock of a large history table. Requires a database configured by the
+// environment variables PG_BENCH_HOST, PG_BENCH_USER, ... (see ConfigFromEnv)
+func BenchmarkMigrationLock(b *testing.B) {
+	config, err := ConfigFromEnv("PG_BENCH")
+	if err != nil || config.Host == "" {
+		b.Skip("PG_BENCH_HOST not set")
+	}
+	db, err := Open(config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	history, release, err := db.newMigrationHistory(&MigrationConfig{Table: "history_bench"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer release()
+	if err = history.createTable(); err != nil {
+		b.Fatal(err)
+	}
+	defer db.Execute("DROP TABLE history_bench")
+
+	_, err = db.Execute(`INSERT INTO history_bench (installed_rank, version, description, checksum, execution_time, success)
+		SELECT s, s::text, 'migration', md5(s::text), 1, true FROM generate_series(1, 50000) s
+		ON CONFLICT DO NOTHING`)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err = history.lock(func() error { return nil }); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMigrationReleaseConn(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	conn, err := db.Conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &migrationHistory{db: db, logger: db.logger}
+	h.releaseConn(conn)
+
+	if got := log.String(); got != "RESET ROLE; RESET ALL" {
+		t.Errorf("expected session reset, got %s", got)
+	}
+	if conn.conn != nil {
+		t.Error("expected connection to be released")
+	}
+}
+
+func TestMigrationSetupSession(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	conn, err := db.Conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseConn()
+
+	h := &migrationHistory{db: db, searchPath: "app, public", sessionSetup: []string{"SET ROLE ddl_admin", "FAIL"}}
+	if err = h.setupSession(conn); err == nil || !strings.Contains(err.Error(), "unable to set up the migration session") {
+		t.Errorf("expected session setup error, got %v", err)
+	}
+	if got := log.String(); got != "SET search_path TO app, public;SET ROLE ddl_admin;FAIL" {
+		t.Errorf("unexpected statements %s", got)
+	}
+}
+
+func TestMigrateSessionSetup(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		if _, err := db.Execute("CREATE ROLE ddl_admin; GRANT ddl_admin TO CURRENT_USER; GRANT ALL ON SCHEMA public TO ddl_admin"); err != nil {
+			t.Fatal(err)
+		}
+
+		var roles []string
+		recordRole := func(db *Database, migration *Migration, args ...interface{}) error {
+			var role string
+			if err := db.QueryRowOld("SELECT current_user").Scan(&role); err != nil {
+				return err
+			}
+			roles = append(roles, role)
+			return nil
+		}
+		for _, version := range []string{"1.0.0", "1.1.0"} {
+			table := "session_setup_" + strings.ReplaceAll(version, ".", "_")
+			err := db.AddMigration(version, "create "+table, func(migration *Migration) {
+				migration.ExecSql("CREATE TABLE " + table + " (id INT)")
+				migration.ExecFn("role", recordRole)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		history, release, err := db.newMigrationHistory(&MigrationConfig{
+			Table:        "history_session",
+			SessionSetup: []string{"SET ROLE ddl_admin"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		if err = history.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Join(roles, ",") != "ddl_admin,ddl_admin" {
+			t.Errorf("expected each migration to run as ddl_admin, got %v", roles)
+		}
+		owners, err := QueryColumn[string](db, "SELECT tableowner FROM pg_tables WHERE tablename LIKE 'session_setup_%'")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(owners, ",") != "ddl_admin,ddl_admin" {
+			t.Errorf("expected tables owned by ddl_admin, got %v", owners)
+		}
+
+		// the role does not leak to the pooled connection
+		conn, err := history.dbSchema.Conn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.CloseConn()
+		var role string
+		if err = conn.QueryRowOld("SELECT current_user").Scan(&role); err != nil {
+			t.Fatal(err)
+		}
+		if role == "ddl_admin" {
+			t.Error("expected the role to be reset")
+		}
+	})
+}
+
+func TestMigrateSessionReset(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		err := db.AddMigration("1.0.0", "Set timeouts", func(migration *Migration) {
+			migration.ExecSql("SET statement_timeout = 1234")
+			migration.ExecSql("SET lock_timeout = 1234")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		history, release, err := db.newMigrationHistory(&MigrationConfig{Table: "history_reset"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		if err = history.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		// the lock and migration connections are idle in the pool
+		var conns []*Database
+		for i := 0; i < 2; i++ {
+			conn, err := history.dbSchema.Conn()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conns = append(conns, conn)
+
+			var timeout string
+			if err = conn.QueryRowOld("SHOW statement_timeout").Scan(&timeout); err != nil {
+				t.Fatal(err)
+			}
+			if timeout != "0" {
+				t.Errorf("expected statement_timeout to be reset, got %s", timeout)
+			}
+		}
+		for _, conn := range conns {
+			_ = conn.CloseConn()
+		}
+	})
+}
+
+func TestMigrationRequiredSchemas(t *testing.T) {
+	tests := []struct {
+		history    string
+		searchPath string
+		want       []string
+	}{
+		{"public", "public", []string{"public"}},
+		{"migrations", "app, public", []string{"migrations", "app"}},
+		{"migrations", `"$user", public`, []string{"migrations"}},
+		{"app", `"app",public`, []string{"app"}},
+	}
+	for _, tt := range tests {
+		h := &migrationHistory{schemaName: tt.history, searchPath: tt.searchPath}
+		if got := h.requiredSchemas(); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("requiredSchemas(%s, %s) = %v, want %v", tt.history, tt.searchPath, got, tt.want)
+		}
+	}
+}
+
+func TestMigrationExecSqlTemplate(t *testing.T) {
+	tmpl := `{{range $i := seq .Count}}CREATE TABLE {{ident (printf "events_%02d" (add $i 1))}} ` +
+		`(CHECK (month = {{add $i 1}}), label TEXT DEFAULT {{quote $.Label}}) INHERITS (events);{{end}}`
+
+	migration := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	migration.ExecSqlTemplate(tmpl, map[string]interface{}{"Count": 3, "Label": "it's"})
+
+	sql := migration.commands[0].(*migrationCommandSql).Sql
+	want := `CREATE TABLE "events_01" (CHECK (month = 1), label TEXT DEFAULT 'it''s') INHERITS (events);` +
+		`CREATE TABLE "events_02" (CHECK (month = 2), label TEXT DEFAULT 'it''s') INHERITS (events);` +
+		`CREATE TABLE "events_03" (CHECK (month = 3), label TEXT DEFAULT 'it''s') INHERITS (events);`
+	if sql != want {
+		t.Errorf("got  %s\nwant %s", sql, want)
+	}
+
+	// checksum of the rendered sql
+	rendered := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	rendered.ExecSql(want)
+	if migration.Info.Checksum != rendered.Info.Checksum {
+		t.Error("expected checksum of the rendered sql")
+	}
+
+	other := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	other.ExecSqlTemplate(tmpl, map[string]interface{}{"Count": 12, "Label": "it's"})
+	if strings.Count(other.commands[0].(*migrationCommandSql).Sql, "CREATE TABLE") != 12 {
+		t.Error("expected 12 tables")
+	}
+	if other.Info.Checksum == migration.Info.Checksum {
+		t.Error("expected checksum to change with the template data")
+	}
+
+	// invalid templates fail when applied
+	for _, invalid := range []string{"{{range}}", "{{.Missing}}"} {
+		failed := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+		failed.ExecSqlTemplate(invalid, map[string]interface{}{})
+		if err := failed.commands[0].run(context.Background(), nil, failed); err == nil {
+			t.Errorf("expected error for template %s", invalid)
+		}
+	}
+}
+
+func TestMigrationEnsureExtension(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	log.fail(`CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`, &pq.Error{Code: "42501", Message: "permission denied"})
+
+	migration := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	migration.EnsureExtension("pgcrypto")
+	other := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	other.EnsureExtension("uuid-ossp")
+
+	if migration.Info.Checksum == "" || migration.Info.Checksum == other.Info.Checksum {
+		t.Error("expected checksum to incorporate the extension name")
+	}
+
+	if err := migration.commands[0].run(context.Background(), db, migration); err != nil {
+		t.Fatal(err)
+	}
+	if got := log.String(); got != `CREATE EXTENSION IF NOT EXISTS "pgcrypto"` {
+		t.Errorf("unexpected statement %s", got)
+	}
+
+	err := other.commands[0].run(context.Background(), db, other)
+	var pqErr *pq.Error
+	if err == nil || !strings.Contains(err.Error(), "permission denied to create extension uuid-ossp") || !errors.As(err, &pqErr) {
+		t.Errorf("expected permission error, got %v", err)
+	}
+}
+
+type recordingLogger struct {
+	infos    []string
+	warnings []string
+}
+
+func (l *recordingLogger) Error(err error) {}
+
+func (l *recordingLogger) Info(f string, v ...interface{}) {
+	l.infos = append(l.infos, fmt.Sprintf(f, v...))
+}
+
+func (l *recordingLogger) Warn(f string, v ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintf(f, v...))
+}
+
+func TestMigrationCheckMissingLocal(t *testing.T) {
+	missing := []*MigrationInfo{{Version: "1.0.0", Description: "create user table"}}
+
+	tests := []struct {
+		policy   MissingLocalPolicy
+		wantErr  bool
+		warnings int
+	}{
+		{MissingLocalFail, true, 0},
+		{MissingLocalWarn, false, 1},
+		{MissingLocalIgnore, false, 0},
+	}
+	for _, tt := range tests {
+		logger := &recordingLogger{}
+		h := &migrationHistory{logger: logger, onMissingLocal: tt.policy}
+		if err := h.checkMissingLocal(missing, true); (err != nil) != tt.wantErr {
+			t.Errorf("policy %d: unexpected error %v", tt.policy, err)
+		}
+		if len(logger.warnings) != tt.warnings {
+			t.Errorf("policy %d: unexpected warnings %v", tt.policy, logger.warnings)
+		}
+		if err := h.checkMissingLocal(nil, true); err != nil {
+			t.Errorf("policy %d: unexpected error without missing migrations %v", tt.policy, err)
+		}
+	}
+}
+
+func TestMigrateMissingLocal(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		if err := db.AddMigrations(migrationsFs); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Migrate(&MigrationConfig{}); err != nil {
+			t.Fatal(err)
+		}
+
+		books, err := migrationsFs.ReadFile("testing/migrations/v1.1.0_create_books_table.sql")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// v1.0.0 pruned from the codebase
+		migrate := func(policy MissingLocalPolicy, version string) error {
+			// a failed Migrate keeps the registered migrations
+			db.migrations = nil
+			err := db.AddMigrationsFromSlice([]MigrationSource{
+				{Version: "1.1.0", Description: "create books table", SQL: string(books)},
+				{Version: version, Description: "create table", SQL: "CREATE TABLE missing_local_" + strings.ReplaceAll(version, ".", "_") + " (id INT)"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return db.Migrate(&MigrationConfig{OnMissingLocal: policy})
+		}
+
+		if err = migrate(MissingLocalFail, "1.2.0"); err == nil || !strings.Contains(err.Error(), "not resolved locally") {
+			t.Errorf("MissingLocalFail: expected error, got %v", err)
+		}
+		if err = migrate(MissingLocalWarn, "1.2.0"); err != nil {
+			t.Errorf("MissingLocalWarn: unexpected error %v", err)
+		}
+		if err = migrate(MissingLocalIgnore, "1.3.0"); err != nil {
+			t.Errorf("MissingLocalIgnore: unexpected error %v", err)
+		}
+
+		count, err := db.QueryForInt("SELECT count(*) FROM pg_tables WHERE tablename LIKE 'missing_local_%'")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Errorf("expected 2 migrations applied, got %d", count)
+		}
+	})
+}
+
+func TestMigrationMaxDebugSQLLen(t *testing.T) {
+	seed := "INSERT INTO seed VALUES " + strings.Repeat("(1), ", 1000) + "(1)"
+
+	cmd := &migrationCommandSql{Sql: seed, Args: []interface{}{1}}
+	if got, want := cmd.debug(24), "INSERT INTO seed VALUES ... (truncated)\n    $0 = 1\n"; got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	if got := cmd.debug(0); !strings.HasPrefix(got, seed+"\n") {
+		t.Errorf("expected no truncation, got %q", got)
+	}
+	// "ã" is 2 bytes, "€" 3 bytes, cut at a rune boundary
+	if got, want := truncateSql("SELECT 'não', '€'", 10), "SELECT 'n... (truncated)"; got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	if got, want := truncateSql("SELECT 'não', '€'", 17), "SELECT 'não', '... (truncated)"; got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	if !utf8.ValidString(truncateSql("€€€", 2)) {
+		t.Error("expected a valid UTF-8 string")
+	}
+
+	db, _ := openFakeDatabase(t)
+	db.config.DebugSql = true
+	logger := &recordingLogger{}
+	db.logger = logger
+
+	history := &migrationHistory{db: db, maxSqlLog: 24}
+	schemaDb, err := history.newSchemaConnection("public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer schemaDb.Close()
+	schemaDb.debugQuery(seed)
+	if len(logger.infos) != 1 || logger.infos[0] != "\n    INSERT INTO seed VALUES ... (truncated)" {
+		t.Errorf("unexpected debug output %q", logger.infos)
+	}
+}
+
+func TestNewSchemaConnectionError(t *testing.T) {
+	db, _ := openFakeDatabase(t)
+	db.config.Password = "s3cr3t"
+
+	history := &migrationHistory{db: db, open: func(*Config, map[string]string, bool) (*sql.DB, error) {
+		return nil, errors.New(`sql: unknown driver "postgres"`)
+	}}
+	schemaDb, err := history.newSchemaConnection("public")
+	if err == nil || schemaDb != nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "unknown driver") || strings.Contains(err.Error(), "s3cr3t") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestMigrateNotifyChannel(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		listener := pq.NewListener(db.config.ConnString(nil), time.Second, time.Minute, nil)
+		defer listener.Close()
+		if err := listener.Listen("schema_changes"); err != nil {
+			t.Fatal(err)
+		}
+
+		config := &MigrationConfig{Table: "history_notify", NotifyChannel: "schema_changes"}
+		if err := db.AddMigrations(migrationsFs); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Migrate(config); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case notification := <-listener.Notify:
+			if notification.Channel != "schema_changes" || notification.Extra != "1.1.0" {
+				t.Errorf("unexpected notification %+v", notification)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a notification")
+		}
+
+		// up to date, no migration applied
+		if err := db.AddMigrations(migrationsFs); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Migrate(config); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case notification := <-listener.Notify:
+			t.Errorf("unexpected notification %+v", notification)
+		case <-time.After(500 * time.Millisecond):
+		}
+	})
+}
+
+func TestMigrationFingerprint(t *testing.T) {
+	h := &migrationHistory{}
+	applied := []*MigrationInfo{
+		{Version: "1.0.0", Checksum: "a", State: MigrationSuccess},
+		{Version: "1.1.0", Checksum: "b", State: MigrationSuccess},
+	}
+	withFailures := []*MigrationInfo{
+		{Version: "1.0.0", Checksum: "a", State: MigrationSuccess},
+		{Version: "1.1.0", Checksum: "x", State: MigrationFailed},
+		{Version: "1.1.0", Checksum: "b", State: MigrationSuccess},
+		{Version: "1.2.0", Checksum: "c", State: MigrationFailed},
+	}
+	changed := []*MigrationInfo{
+		{Version: "1.0.0", Checksum: "a", State: MigrationSuccess},
+		{Version: "1.1.0", Checksum: "c", State: MigrationSuccess},
+	}
+
+	if h.fingerprint(applied) != h.fingerprint(withFailures) {
+		t.Error("expected failed attempts to be ignored")
+	}
+	if h.fingerprint(applied) == h.fingerprint(changed) {
+		t.Error("expected different fingerprints for different checksums")
+	}
+	if h.fingerprint(applied) == h.fingerprint(applied[:1]) || h.fingerprint(nil) != hash("") {
+		t.Error("expected different fingerprints for different histories")
+	}
+
+	// repeatable migrations all have the version R
+	repeatables := append(applied[:2:2],
+		&MigrationInfo{Version: "R", Description: "views", Checksum: "v1", State: MigrationSuccess},
+		&MigrationInfo{Version: "R", Description: "functions", Checksum: "f1", State: MigrationSuccess},
+	)
+	changedRepeatable := append(applied[:2:2],
+		&MigrationInfo{Version: "R", Description: "views", Checksum: "v2", State: MigrationSuccess},
+		&MigrationInfo{Version: "R", Description: "functions", Checksum: "f1", State: MigrationSuccess},
+	)
+	reapplied := append(repeatables[:4:4],
+		&MigrationInfo{Version: "R", Description: "views", Checksum: "v2", State: MigrationSuccess},
+	)
+	if h.fingerprint(repeatables) == h.fingerprint(changedRepeatable) {
+		t.Error("expected different fingerprints for different repeatable migrations")
+	}
+	if h.fingerprint(repeatables) == h.fingerprint(reapplied) {
+		t.Error("expected the latest attempt of each repeatable migration")
+	}
+}
+
+func TestMigrationFingerprintDatabase(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		fingerprints := map[string]string{}
+		for _, schema := range []string{"fingerprint_a", "fingerprint_b"} {
+			config := &MigrationConfig{Schema: schema}
+			if err := db.AddMigrations(migrationsFs); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Migrate(config); err != nil {
+				t.Fatal(err)
+			}
+			fingerprint, err := db.MigrationFingerprint(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fingerprints[schema] = fingerprint
+		}
+
+		if fingerprints["fingerprint_a"] != fingerprints["fingerprint_b"] {
+			t.Errorf("expected same fingerprint, got %v", fingerprints)
+		}
+
+		empty, err := db.MigrationFingerprint(&MigrationConfig{Schema: "fingerprint_none"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if empty == fingerprints["fingerprint_a"] {
+			t.Error("expected a different fingerprint without history")
+		}
+	})
+}
+
+func TestMigrationWaitForIdle(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		if _, err := db.Execute("CREATE TABLE wait_orders (id INT)"); err != nil {
+			t.Fatal(err)
+		}
+
+		// simulated long query, holding a lock until the transaction ends
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tx.Execute("SELECT * FROM wait_orders"); err != nil {
+			t.Fatal(err)
+		}
+
+		err = waitForIdle(context.Background(), db, "public.wait_orders", 300*time.Millisecond)
+		if err == nil || !strings.Contains(err.Error(), "still in use by 1 sessions") {
+			t.Errorf("expected timeout error, got %v", err)
+		}
+
+		go func() {
+			time.Sleep(500 * time.Millisecond)
+			_ = tx.Rollback()
+		}()
+
+		err = db.AddMigration("1.0.0", "add notes", func(migration *Migration) {
+			migration.WaitForIdle("public.wait_orders", 10*time.Second)
+			migration.ExecSql("ALTER TABLE wait_orders ADD COLUMN notes TEXT")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		if err = db.Migrate(&MigrationConfig{Table: "history_wait"}); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+			t.Errorf("expected the migration to wait for the long query, took %s", elapsed)
+		}
+		if exists, err := db.ColumnExists("public", "wait_orders", "notes"); err != nil || !exists {
+			t.Errorf("expected column to be added, got %v, %v", exists, err)
+		}
+	})
+}
+
+func TestMigrationChecksumArgs(t *testing.T) {
+	checksum := func(prepare MigrationPrepare) string {
+		migration := &Migration{Info: &MigrationInfo{Version: "1.0.0"}, Prepare: prepare}
+		migration.prepare()
+		return migration.Info.Checksum
+	}
+	noop := func(db *Database, migration *Migration, args ...interface{}) error { return nil }
+
+	withArgs := checksum(func(m *Migration) {
+		m.ExecSql("INSERT INTO settings (name, value) VALUES ($1, $2)", "tenant", "staging")
+		m.ExecFn("seed", noop, "staging")
+	})
+	otherArgs := checksum(func(m *Migration) {
+		m.ExecSql("INSERT INTO settings (name, value) VALUES ($1, $2)", "tenant", "production")
+		m.ExecFn("seed", noop, "production")
+	})
+	noArgs := checksum(func(m *Migration) {
+		m.ExecSql("INSERT INTO settings (name, value) VALUES ($1, $2)")
+		m.ExecFn("seed", noop)
+	})
+
+	// args are not part of the checksum, see ExecSql
+	if withArgs != otherArgs || withArgs != noArgs {
+		t.Errorf("expected checksum independent of args, got %s %s %s", withArgs, otherArgs, noArgs)
+	}
+
+	otherSql := checksum(func(m *Migration) {
+		m.ExecSql("INSERT INTO settings (name, value) VALUES ($1, 'production')", "tenant")
+		m.ExecFn("seed", noop)
+	})
+	if withArgs == otherSql {
+		t.Error("expected checksum to change with the SQL")
+	}
+}
+
+func TestMigrationRejectEmpty(t *testing.T) {
+	db := &Database{}
+	noop := func(migration *Migration) {}
+	if err := db.AddMigration("1.0.0", "create users", func(migration *Migration) {
+		migration.ExecSql("CREATE TABLE users (id INT)")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddMigration("R", "refresh views", noop); err != nil {
+		t.Fatal(err)
+	}
+
+	history := &migrationHistory{db: db, rejectEmpty: true}
+	if _, err := history.prepareMigrations(); err != nil {
+		t.Errorf("empty repeatable migrations must be accepted: %v", err)
+	}
+
+	if err := db.AddMigration("1.1.0", "forgot the sql", noop); err != nil {
+		t.Fatal(err)
+	}
+	_, err := history.prepareMigrations()
+	if err == nil || !strings.Contains(err.Error(), "migration version 1.1.0 has no command") {
+		t.Errorf("expected empty migration error, got %v", err)
+	}
+
+	history.rejectEmpty = false
+	if _, err = history.prepareMigrations(); err != nil {
+		t.Errorf("unexpected error without RejectEmpty: %v", err)
+	}
+}
+
+func TestMigrationPartitions(t *testing.T) {
+	migration := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	migration.CreatePartition("public.events", "events_2024_01", "'2024-01-01'", "'2024-02-01'")
+	migration.AttachPartition("events", "events_legacy", "MINVALUE", "'2024-01-01'")
+	migration.DetachPartition("events", "events_legacy")
+
+	want := []string{
+		`CREATE TABLE "events_2024_01" PARTITION OF "public"."events" FOR VALUES FROM ('2024-01-01') TO ('2024-02-01')`,
+		`ALTER TABLE "events" ATTACH PARTITION "events_legacy" FOR VALUES FROM (MINVALUE) TO ('2024-01-01')`,
+		`ALTER TABLE "events" DETACH PARTITION "events_legacy"`,
+	}
+	for i, cmd := range migration.commands {
+		if got := cmd.(*migrationCommandSql).Sql; got != want[i] {
+			t.Errorf("got  %s\nwant %s", got, want[i])
+		}
+	}
+
+	other := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	other.CreatePartition("public.events", "events_2024_01", "'2024-01-01'", "'2024-03-01'")
+	first := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	first.CreatePartition("public.events", "events_2024_01", "'2024-01-01'", "'2024-02-01'")
+	if other.Info.Checksum == first.Info.Checksum {
+		t.Error("expected checksum to reflect the bounds")
+	}
+}
+
+func TestMigrationDrop(t *testing.T) {
+	migration := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	migration.DropTable("public.users")
+	migration.DropColumn("users", "nick name")
+	migration.DropIndex("users_email_idx")
+	migration.DropConstraint("public.users", "users_email_key")
+
+	want := []string{
+		`DROP TABLE IF EXISTS "public"."users"`,
+		`ALTER TABLE IF EXISTS "users" DROP COLUMN IF EXISTS "nick name"`,
+		`DROP INDEX IF EXISTS "users_email_idx"`,
+		`ALTER TABLE IF EXISTS "public"."users" DROP CONSTRAINT IF EXISTS "users_email_key"`,
+	}
+	for i, cmd := range migration.commands {
+		if got := cmd.(*migrationCommandSql).Sql; got != want[i] {
+			t.Errorf("got  %s\nwant %s", got, want[i])
+		}
+	}
+
+	other := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	other.DropTable("public.customers")
+	first := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	first.DropTable("public.users")
+	if other.Info.Checksum == first.Info.Checksum {
+		t.Error("expected checksum to reflect the names")
+	}
+}
+
+func TestMigrateDrop(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		err := db.AddMigration("1.0.0", "create tables", func(migration *Migration) {
+			migration.ExecSql("CREATE TABLE drop_users (id INT, email TEXT CONSTRAINT drop_users_email_key UNIQUE, nick TEXT)")
+			migration.ExecSql("CREATE INDEX drop_users_nick_idx ON drop_users (nick)")
+			migration.ExecSql("CREATE TABLE drop_legacy (id INT)")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// applied twice (the second time on missing objects) to revert the first migration
+		for _, version := range []string{"1.1.0", "1.2.0"} {
+			err = db.AddMigration(version, "drop "+version, func(migration *Migration) {
+				migration.DropIndex("drop_users_nick_idx")
+				migration.DropConstraint("drop_users", "drop_users_email_key")
+				migration.DropColumn("drop_users", "nick")
+				migration.DropTable("public.drop_legacy")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = db.Migrate(&MigrationConfig{Table: "history_drop"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if exists, err := db.TableExists("public", "drop_legacy"); err != nil || exists {
+			t.Errorf("expected table dropped, got %v, %v", exists, err)
+		}
+		if exists, err := db.ColumnExists("public", "drop_users", "nick"); err != nil || exists {
+			t.Errorf("expected column dropped, got %v, %v", exists, err)
+		}
+		if exists, err := db.IndexExists("public", "drop_users_nick_idx"); err != nil || exists {
+			t.Errorf("expected index dropped, got %v, %v", exists, err)
+		}
+		if _, err = db.Execute("INSERT INTO drop_users (id, email) VALUES (1, 'a'), (2, 'a')"); err != nil {
+			t.Errorf("expected unique constraint dropped, got %v", err)
+		}
+	})
+}
+
+func TestStripSqlComments(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT 1 -- one", "SELECT 1"},
+		{"-- header\nCREATE TABLE a (\n  id INT -- key\n)", "CREATE TABLE a ( id INT )"},
+		{"SELECT /* a /* nested */ comment */ 1", "SELECT 1"},
+		{"SELECT '-- not a comment', \"/* col */\"", "SELECT '-- not a comment', \"/* col */\""},
+		{"SELECT 'it''s -- here' -- gone", "SELECT 'it''s -- here'"},
+		{"SELECT E'\\' -- x' -- gone", "SELECT E'\\' -- x'"},
+		{"CREATE FUNCTION f() RETURNS INT AS $body$ -- kept\n SELECT 1 $body$ LANGUAGE sql", "CREATE FUNCTION f() RETURNS INT AS $body$ -- kept\n SELECT 1 $body$ LANGUAGE sql"},
+		{"SELECT $$ /* kept */ $$, $1 -- gone", "SELECT $$ /* kept */ $$, $1"},
+		{"SELECT a$b$ -- gone", "SELECT a$b$"},
+	}
+	for _, tt := range tests {
+		if got := stripSqlComments(tt.sql); got != tt.want {
+			t.Errorf("stripSqlComments(%q)\n got  %q\n want %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestMigrationChecksumIgnoreComments(t *testing.T) {
+	newMigration := func(sql string) *Migration {
+		migration := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+		migration.ExecSql(sql)
+		migration.ExecFn("seed", func(db *Database, migration *Migration, args ...interface{}) error { return nil })
+		return migration
+	}
+
+	first := newMigration("-- create users\nCREATE TABLE users (id INT)")
+	edited := newMigration("/* users of the application */\nCREATE TABLE users   (id INT) -- key")
+	if first.Info.Checksum == edited.Info.Checksum {
+		t.Error("expected comments to change the checksum by default")
+	}
+
+	recorded := first.Info.Checksum
+	first.ignoreComments()
+	edited.ignoreComments()
+	edited.ignoreComments()
+	if first.Info.Checksum != edited.Info.Checksum {
+		t.Error("expected comment-only edits to keep the checksum")
+	}
+	if !first.checksumMatches(recorded) || edited.checksumMatches(recorded) {
+		t.Error("expected the checksum recorded with comments to match the unchanged migration only")
+	}
+
+	other := newMigration("CREATE TABLE users (id BIGINT)")
+	other.ignoreComments()
+	if other.Info.Checksum == first.Info.Checksum {
+		t.Error("expected SQL changes to change the checksum")
+	}
+}
+
+func TestIsMigrationRegisteredIgnoreComments(t *testing.T) {
+	prepare := func(migration *Migration) {
+		migration.ExecSql("-- create users\nCREATE TABLE users (id INT)")
+	}
+	db := &Database{}
+	if err := db.AddMigration("1.0.0", "create users", prepare); err != nil {
+		t.Fatal(err)
+	}
+	// a previous Migrate with MigrationConfig.IgnoreCommentsInChecksum
+	db.migrations[0].prepare()
+	db.migrations[0].ignoreComments()
+
+	if !db.isMigrationRegistered("1.0.0", "create users", prepare) {
+		t.Error("expected the migration to be registered")
+	}
+}
+
+func TestMigrateIgnoreCommentsInChecksum(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		addMigration := func(sql string) {
+			if err := db.AddMigration("1.0.0", "create table", func(migration *Migration) {
+				migration.ExecSql(sql)
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		config := &MigrationConfig{Table: "history_comments", IgnoreCommentsInChecksum: true}
+
+		addMigration("-- first version\nCREATE TABLE comments (id INT)")
+		if err := db.Migrate(config); err != nil {
+			t.Fatal(err)
+		}
+
+		addMigration("/* edited */ CREATE TABLE comments (id INT) -- key")
+		if err := db.Migrate(config); err != nil {
+			t.Errorf("expected comment-only edit to be accepted, got %v", err)
+		}
+
+		addMigration("/* edited */ CREATE TABLE comments (id INT) -- key")
+		if err := db.Migrate(&MigrationConfig{Table: "history_comments"}); err == nil || !strings.Contains(err.Error(), "checksum") {
+			t.Errorf("expected checksum mismatch when disabled, got %v", err)
+		}
+
+		// recorded with comments, verified ignoring them
+		verifyConfig := &MigrationConfig{Table: "history_comments_verify"}
+		db.migrations = nil
+		addMigration("-- first version\nCREATE TABLE comments_verify (id INT)")
+		if err := db.Migrate(verifyConfig); err != nil {
+			t.Fatal(err)
+		}
+		db.migrations = nil
+		addMigration("-- first version\nCREATE TABLE comments_verify (id INT)")
+		verifyConfig.IgnoreCommentsInChecksum = true
+		if err := db.MigrationVerify(verifyConfig); err != nil {
+			t.Errorf("expected the checksum recorded with comments to be verified, got %v", err)
+		}
+	})
+}
+
+func TestMigrationExecSqlExpect(t *testing.T) {
+	db, log := openFakeDatabase(t)
+
+	migration := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	migration.ExecSqlExpect("UPDATE users SET plan = $1", 1, "pro")
+	migration.ExecSqlExpect("DELETE FROM users", 2)
+
+	if err := migration.commands[0].run(context.Background(), db, migration); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := migration.commands[1].run(context.Background(), db, migration); err == nil || err.Error() != "expected 2 affected rows, got 1" {
+		t.Errorf("expected affected rows mismatch, got %v", err)
+	}
+	if log.String() != "UPDATE users SET plan = $1;DELETE FROM users" {
+		t.Errorf("unexpected statements %s", log.String())
+	}
+	if debug := migration.commands[1].debug(0); !strings.Contains(debug, "expected rows = 2") {
+		t.Errorf("unexpected debug %s", debug)
+	}
+
+	other := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	other.ExecSqlExpect("UPDATE users SET plan = $1", 2, "pro")
+	first := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	first.ExecSqlExpect("UPDATE users SET plan = $1", 1, "pro")
+	if other.Info.Checksum == first.Info.Checksum {
+		t.Error("expected checksum to reflect the expected rows")
+	}
+}
+
+func TestMigrateExecSqlExpect(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		if _, err := db.Execute("CREATE TABLE expect_users (id INT, plan TEXT); " +
+			"INSERT INTO expect_users SELECT i, 'gold' FROM generate_series(1, 3) i"); err != nil {
+			t.Fatal(err)
+		}
+
+		err := db.AddMigration("1.0.0", "backfill", func(migration *Migration) {
+			migration.ExecSqlExpect("UPDATE expect_users SET plan = 'pro' WHERE plan = $1", 2, "gold")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.Migrate(&MigrationConfig{Table: "history_expect"})
+		var migrationErr *MigrationError
+		if !errors.As(err, &migrationErr) || !strings.Contains(migrationErr.SQL, "UPDATE expect_users") {
+			t.Fatalf("expected MigrationError, got %v", err)
+		}
+		if count, err := db.QueryForInt("SELECT count(*) FROM expect_users WHERE plan = 'pro'"); err != nil || count != 0 {
+			t.Errorf("expected the update rolled back, got %d, %v", count, err)
+		}
+
+		// fixed expectation
+		db.migrations = nil
+		err = db.AddMigration("1.0.0", "backfill", func(migration *Migration) {
+			migration.ExecSqlExpect("UPDATE expect_users SET plan = 'pro' WHERE plan = $1", 3, "gold")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = db.Migrate(&MigrationConfig{Table: "history_expect"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestMigratePartitions(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		if version, err := db.ServerVersion(); err != nil {
+			t.Fatal(err)
+		} else if version < 100000 {
+			t.Skip("declarative partitioning requires PostgreSQL 10+")
+		}
+
+		err := db.AddMigration("1.0.0", "create events", func(migration *Migration) {
+			migration.ExecSql("CREATE TABLE events (created_at DATE NOT NULL, payload TEXT) PARTITION BY RANGE (created_at)")
+			migration.CreatePartition("public.events", "events_2024_01", "'2024-01-01'", "'2024-02-01'")
+			migration.ExecSql("CREATE TABLE events_2023 (created_at DATE NOT NULL, payload TEXT)")
+			migration.AttachPartition("events", "events_2023", "'2023-01-01'", "'2024-01-01'")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = db.Migrate(&MigrationConfig{Table: "history_partitions"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = db.Execute("INSERT INTO events VALUES ('2023-06-01', 'a'), ('2024-01-15', 'b')"); err != nil {
+			t.Fatal(err)
+		}
+		if count, err := db.QueryForInt("SELECT count(*) FROM events_2024_01"); err != nil || count != 1 {
+			t.Errorf("expected a row in the partition, got %d, %v", count, err)
+		}
+		if _, err = db.Execute("INSERT INTO events VALUES ('2024-03-01', 'c')"); err == nil {
+			t.Error("expected error for a value without partition")
+		}
+
+		err = db.AddMigration("1.1.0", "detach 2023", func(migration *Migration) {
+			migration.DetachPartition("events", "events_2023")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = db.Migrate(&MigrationConfig{Table: "history_partitions"}); err != nil {
+			t.Fatal(err)
+		}
+		if count, err := db.QueryForInt("SELECT count(*) FROM events"); err != nil || count != 1 {
+			t.Errorf("expected the detached rows to leave the parent, got %d, %v", count, err)
+		}
+	})
+}
+
+func TestMigrationBulkLoad(t *testing.T) {
+	load := func(ctx context.Context, db *Database, migration *Migration, args ...interface{}) error { return nil }
+
+	first := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	first.BulkLoad("seed events", []string{"events_created_idx"}, load)
+	same := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	same.BulkLoad("seed events", []string{"events_created_idx"}, load)
+	other := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+	other.BulkLoad("seed events", []string{"events_created_idx", "events_kind_idx"}, load)
+
+	if first.Info.Checksum != same.Info.Checksum || first.Info.Checksum == other.Info.Checksum {
+		t.Error("expected the checksum to reflect the name and the indexes")
+	}
+	if got := other.commands[0].debug(0); !strings.Contains(got, "migration_test.go:") ||
+		!strings.Contains(got, "(indexes events_created_idx, events_kind_idx)") {
+		t.Errorf("unexpected debug %s", got)
+	}
+}
+
+func TestMigrateBulkLoad(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		indexes := func() string {
+			rows, err := db.QueryMaps("SELECT indexdef FROM pg_indexes WHERE tablename = 'events' ORDER BY indexname")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return fmt.Sprint(rows)
+		}
+
+		_, err := db.Execute(`CREATE TABLE events (id INT, kind TEXT, created_at TIMESTAMPTZ);
+			CREATE INDEX events_created_idx ON events (created_at DESC) WHERE kind IS NOT NULL;
+			CREATE UNIQUE INDEX events_id_idx ON events (id)`)
 		if err != nil {
 			t.Fatal(err)
 		}
+		before := indexes()
 
-		portInt, _ := strconv.Atoi(port)
-		db, err := Open(&Config{
-			Username: "postgres",
-			Password: "postgres",
-			Host:     ip,
-			Port:     portInt,
-			Database: "postgres",
-			SSLMode:  "disable",
-			DebugSql: true,
-			Logger:   nil,
+		var indexesDuringLoad int64
+		err = db.AddMigration("1.0.0", "seed events", func(migration *Migration) {
+			migration.BulkLoad("seed events", []string{"events_created_idx", "public.events_id_idx"}, func(ctx context.Context, db *Database, migration *Migration, args ...interface{}) error {
+				var err error
+				if indexesDuringLoad, err = db.QueryForInt("SELECT count(*) FROM pg_indexes WHERE tablename = 'events'"); err != nil {
+					return err
+				}
+				_, err = db.Execute("INSERT INTO events SELECT s, 'seed', now() FROM generate_series(1, 10000) s")
+				return err
+			})
 		})
 		if err != nil {
 			t.Fatal(err)
 		}
-		defer func() {
-			if err := db.CloseConn(); err != nil {
-				t.Error(err)
+		if err = db.Migrate(&MigrationConfig{Table: "history_bulk_load"}); err != nil {
+			t.Fatal(err)
+		}
+		if indexesDuringLoad != 0 {
+			t.Errorf("expected the indexes to be dropped during the load, got %d", indexesDuringLoad)
+		}
+		if after := indexes(); after != before {
+			t.Errorf("expected the indexes to be recreated\ngot  %s\nwant %s", after, before)
+		}
+
+		// a failed load (duplicated id) is rolled back with the indexes
+		err = db.AddMigration("1.1.0", "seed duplicated", func(migration *Migration) {
+			migration.BulkLoad("seed duplicated", []string{"events_id_idx"}, func(ctx context.Context, db *Database, migration *Migration, args ...interface{}) error {
+				_, err := db.Execute("INSERT INTO events VALUES (1, 'duplicated', now())")
+				return err
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = db.Migrate(&MigrationConfig{Table: "history_bulk_load"}); err == nil {
+			t.Fatal("expected the recreation of the unique index to fail")
+		}
+		if after := indexes(); after != before {
+			t.Errorf("expected the indexes to be restored by the rollback\ngot  %s\nwant %s", after, before)
+		}
+		if count, err := db.QueryForInt("SELECT count(*) FROM events"); err != nil || count != 10000 {
+			t.Errorf("expected the failed load to be rolled back, got %d, %v", count, err)
+		}
+	})
+}
+
+func TestMigrationCommands(t *testing.T) {
+	db := &Database{}
+	err := db.AddMigration("1.0.0", "create users", func(migration *Migration) {
+		migration.ExecSql("CREATE TABLE users (id INT, tenant TEXT)")
+		migration.ExecFn("seed users", func(db *Database, migration *Migration, args ...interface{}) error {
+			return nil
+		}, "t1", 10)
+		migration.ExecSql("INSERT INTO users VALUES ($1, $2)", 1, "t1")
+		migration.EnsureExtension("pgcrypto")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	migration := db.migrations[0]
+
+	commands := migration.Commands()
+	if len(commands) != 4 {
+		t.Fatalf("expected 4 commands, got %d", len(commands))
+	}
+	want := []MigrationCommandInfo{
+		{Kind: CommandKindSql, SQL: "CREATE TABLE users (id INT, tenant TEXT)"},
+		{Kind: CommandKindFn, Caller: commands[1].Caller, Args: 2},
+		{Kind: CommandKindSql, SQL: "INSERT INTO users VALUES ($1, $2)", Args: 2},
+		{Kind: CommandKindSql, SQL: `CREATE EXTENSION IF NOT EXISTS "pgcrypto"`},
+	}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("got  %+v\nwant %+v", commands, want)
+	}
+	if !strings.Contains(commands[1].Caller, "migration_test.go:") {
+		t.Errorf("unexpected caller %s", commands[1].Caller)
+	}
+
+	// the view does not change the migration, nor prepares it again
+	commands[0].SQL = "DROP TABLE users"
+	if again := migration.Commands(); len(again) != 4 || again[0].SQL != want[0].SQL {
+		t.Errorf("unexpected commands %+v", again)
+	}
+
+	checksum := migration.Checksum()
+	if checksum == "" || checksum != migration.Info.Checksum {
+		t.Errorf("unexpected checksum %s", checksum)
+	}
+}
+
+func TestMigrationRenameInvalid(t *testing.T) {
+	db, log := openFakeDatabase(t)
+
+	for _, name := range []string{"", "legacy_history"} {
+		if err := db.MigrationRename(&MigrationConfig{Table: "legacy_history"}, name); err == nil {
+			t.Errorf("expected error for new name %q", name)
+		}
+	}
+	if got := log.String(); got != "" {
+		t.Errorf("unexpected statements %s", got)
+	}
+}
+
+func TestMigrationRename(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		addMigrations := func() {
+			for _, version := range []string{"1.0.0", "1.1.0"} {
+				err := db.AddMigration(version, "create table "+version, func(migration *Migration) {
+					migration.ExecSql("CREATE TABLE " + QuoteIdentifier("rename_"+version) + " (id INT)")
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		addMigrations()
+		if err := db.Migrate(&MigrationConfig{Table: "legacy_history"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Execute("CREATE TABLE taken_history (id INT)"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.MigrationRename(&MigrationConfig{Table: "legacy_history"}, "taken_history"); err == nil {
+			t.Error("expected error for an existing table")
+		}
+		if err := db.MigrationRename(&MigrationConfig{Table: "missing_history"}, "new_history"); err == nil {
+			t.Error("expected error for a missing history table")
+		}
+
+		if err := db.MigrationRename(&MigrationConfig{Table: "legacy_history"}, "new_history"); err != nil {
+			t.Fatal(err)
+		}
+		if exists, err := db.TableExists("public", "legacy_history"); err != nil || exists {
+			t.Errorf("expected the old table to be gone, got %v, %v", exists, err)
+		}
+		for _, index := range []string{"new_history_pkey", "new_history_s_idx"} {
+			if exists, err := db.IndexExists("public", index); err != nil || !exists {
+				t.Errorf("expected index %s, got %v, %v", index, exists, err)
+			}
+		}
+
+		// the renamed history is up to date
+		addMigrations()
+		if err := db.Migrate(&MigrationConfig{Table: "new_history"}); err != nil {
+			t.Fatal(err)
+		}
+		if count, err := db.QueryForInt("SELECT count(*) FROM new_history WHERE success"); err != nil || count != 2 {
+			t.Errorf("expected the rows to be preserved, got %d, %v", count, err)
+		}
+	})
+}
+
+func TestAddMigrationsManifest(t *testing.T) {
+	checksum := func(sql string) string {
+		migration := &Migration{Info: &MigrationInfo{}}
+		migration.ExecSql(sql)
+		return migration.Checksum()
+	}
+
+	dir := fstest.MapFS{
+		"db/manifest.json": {Data: []byte(`{"migrations": [
+			{"file": "v1.0.0_create_users.sql"},
+			{"file": "sql/emails.sql", "version": "1.1.0", "description": "add email", "checksum": "` + checksum("ALTER TABLE users ADD email TEXT") + `"},
+			{"file": "v1.2.0_seed.sql", "description": "seed admin user"}
+		]}`)},
+		"db/v1.0.0_create_users.sql": {Data: []byte("CREATE TABLE users (id INT)")},
+		"db/sql/emails.sql":          {Data: []byte("ALTER TABLE users ADD email TEXT")},
+		"db/v1.2.0_seed.sql":         {Data: []byte("INSERT INTO users VALUES (1, 'admin@example.com')")},
+	}
+
+	db := &Database{}
+	if err := db.AddMigrationsManifest(dir, "db/manifest.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, migration := range db.migrations {
+		got = append(got, migration.Info.Version+" "+migration.Info.Description+": "+migration.Commands()[0].SQL)
+	}
+	want := []string{
+		"1.0.0 create users: CREATE TABLE users (id INT)",
+		"1.1.0 add email: ALTER TABLE users ADD email TEXT",
+		"1.2.0 seed admin user: INSERT INTO users VALUES (1, 'admin@example.com')",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %v\nwant %v", got, want)
+	}
+
+	// edited file
+	dir["db/sql/emails.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE users ADD email VARCHAR(100)")}
+	db = &Database{}
+	err := db.AddMigrationsManifest(dir, "db/manifest.json")
+	if err == nil || !strings.Contains(err.Error(), "migration v1.1.0 (db/sql/emails.sql) was modified") {
+		t.Errorf("expected checksum error, got %v", err)
+	}
+	if len(db.migrations) != 0 {
+		t.Errorf("expected no migration registered, got %d", len(db.migrations))
+	}
+
+	// out of the order of the versions
+	dir["db/manifest.json"] = &fstest.MapFile{Data: []byte(`{"migrations": [
+		{"file": "v1.2.0_seed.sql"},
+		{"file": "v1.0.0_create_users.sql"}
+	]}`)}
+	db = &Database{}
+	err = db.AddMigrationsManifest(dir, "db/manifest.json")
+	if err == nil || !strings.Contains(err.Error(), "v1.0.0 (db/v1.0.0_create_users.sql) is listed after v1.2.0 (db/v1.2.0_seed.sql)") {
+		t.Errorf("expected order error, got %v", err)
+	}
+	if len(db.migrations) != 0 {
+		t.Errorf("expected no migration registered, got %d", len(db.migrations))
+	}
+
+	// version already registered, after a valid entry
+	dir["db/manifest.json"] = &fstest.MapFile{Data: []byte(`{"migrations": [
+		{"file": "v1.0.0_create_users.sql"},
+		{"file": "v1.2.0_seed.sql"}
+	]}`)}
+	db = &Database{}
+	if err = db.AddMigration("1.2.0", "other seed", func(migration *Migration) {}); err != nil {
+		t.Fatal(err)
+	}
+	err = db.AddMigrationsManifest(dir, "db/manifest.json")
+	if err == nil || !strings.Contains(err.Error(), "found more than one migration with version 1.2.0") {
+		t.Errorf("expected duplicated version error, got %v", err)
+	}
+	if len(db.migrations) != 1 {
+		t.Errorf("expected only the previous migration registered, got %d", len(db.migrations))
+	}
+
+	for name, manifest := range map[string]string{
+		"invalid json": `{"migrations": [`,
+		"no file":      `{"migrations": [{"version": "1.0.0"}]}`,
+		"missing file": `{"migrations": [{"file": "v2.0.0_missing.sql"}]}`,
+	} {
+		dir["db/manifest.json"] = &fstest.MapFile{Data: []byte(manifest)}
+		if err = (&Database{}).AddMigrationsManifest(dir, "db/manifest.json"); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if err = (&Database{}).AddMigrationsManifest(dir, "db/missing.json"); err == nil {
+		t.Error("expected error for a missing manifest")
+	}
+}
+
+func TestMigrationTagsSelected(t *testing.T) {
+	tests := []struct {
+		tags    []string
+		include []string
+		exclude []string
+		want    bool
+	}{
+		{nil, nil, nil, true},
+		{nil, nil, []string{"analytics"}, true},
+		{[]string{"analytics"}, nil, nil, false},
+		{[]string{"analytics"}, []string{"analytics"}, nil, true},
+		{[]string{"analytics"}, []string{"billing"}, nil, false},
+		{[]string{"analytics", "heavy"}, []string{"analytics"}, nil, true},
+		{[]string{"analytics", "heavy"}, []string{"analytics"}, []string{"heavy"}, false},
+		{[]string{"analytics"}, []string{"analytics"}, []string{"analytics"}, false},
+	}
+	for _, tt := range tests {
+		migration := &Migration{Info: &MigrationInfo{Version: "1.0.0"}}
+		migration.Tag(tt.tags...)
+		h := &migrationHistory{includeTags: tt.include, excludeTags: tt.exclude}
+		if got := h.selected(migration); got != tt.want {
+			t.Errorf("tags %v, include %v, exclude %v: got %v, want %v", tt.tags, tt.include, tt.exclude, got, tt.want)
+		}
+	}
+}
+
+func TestMigrateTags(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		addMigrations := func() {
+			migrations := []struct {
+				version string
+				table   string
+				tags    []string
+			}{
+				{"1.0.0", "tags_core", nil},
+				{"1.1.0", "tags_analytics", []string{"analytics"}},
+				{"1.2.0", "tags_heavy", []string{"analytics", "heavy"}},
+				{"1.3.0", "tags_core_2", nil},
+			}
+			for _, m := range migrations {
+				table, tags := m.table, m.tags
+				err := db.AddMigration(m.version, "create "+table, func(migration *Migration) {
+					migration.Tag(tags...)
+					migration.ExecSql("CREATE TABLE " + table + " (id INT)")
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		tables := func() []string {
+			var names []string
+			for _, table := range []string{"tags_core", "tags_analytics", "tags_heavy", "tags_core_2"} {
+				if exists, err := db.TableExists("public", table); err != nil {
+					t.Fatal(err)
+				} else if exists {
+					names = append(names, table)
+				}
+			}
+			return names
+		}
+
+		addMigrations()
+		if err := db.Migrate(&MigrationConfig{Table: "history_tags"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := tables(); !reflect.DeepEqual(got, []string{"tags_core", "tags_core_2"}) {
+			t.Errorf("expected only untagged migrations, got %v", got)
+		}
+
+		addMigrations()
+		err := db.Migrate(&MigrationConfig{
+			Table: "history_tags", IncludeTags: []string{"analytics"}, ExcludeTags: []string{"heavy"}, OutOfOrder: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tables(); !reflect.DeepEqual(got, []string{"tags_core", "tags_analytics", "tags_core_2"}) {
+			t.Errorf("expected the analytics migration, got %v", got)
+		}
+	})
+}
+
+func TestMigrationHistoryCacheConcurrent(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	h := &migrationHistory{db: db, dbSchema: db, dbLock: db, schemaName: "public", tableName: "history"}
+	log.result(h.appliedMigrationsSql(), []string{"installed_rank", "version", "description", "checksum", "success"},
+		[]sqldriver.Value{int64(1), "1.0.0", "create users", "a", true},
+		[]sqldriver.Value{int64(2), "1.1.0", "add email", "b", false},
+	)
+
+	// the apply loop records the migrations (evicting the failed rows of its version) while the history is read
+	var wg sync.WaitGroup
+	errs := make(chan error, 30)
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			info := &MigrationInfo{Version: "1.2.0", Description: "add phone", Checksum: "c"}
+			if err := h.addAppliedMigration(info, 10, true); err != nil {
+				errs <- err
+			} else if info.InstalledRank != 3 {
+				errs <- fmt.Errorf("unexpected recorded installed rank %d", info.InstalledRank)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if rank, err := h.calculateInstalledRank(); err != nil {
+				errs <- err
+			} else if rank != 3 {
+				errs <- fmt.Errorf("unexpected installed rank %d", rank)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			applied, err := h.getAppliedMigrations()
+			if err != nil {
+				errs <- err
+				return
 			}
+			// the result is a copy
+			applied[0] = nil
 		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	applied, err := h.getAppliedMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || applied[0] == nil || applied[1].Version != "1.1.0" {
+		t.Fatalf("expected the rows to be cached once, got %v", applied)
+	}
+
+	h.evictCache("1.1.0")
+	log.result(h.appliedMigrationsSql(), []string{"installed_rank", "version", "description", "checksum", "success"},
+		[]sqldriver.Value{int64(3), "1.1.0", "add email", "b", true},
+	)
+	if applied, err = h.getAppliedMigrations(); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || applied[1].InstalledRank != 3 || applied[1].State != MigrationSuccess {
+		t.Errorf("expected the failed row to be replaced, got %+v", applied[1])
+	}
+}
+
+func TestMigrationRetryDeadlock(t *testing.T) {
+	deadlock := &MigrationError{Version: "1.0.0", Cause: &pq.Error{Code: "40P01"}}
+	tests := []struct {
+		retries  int
+		failures []error
+		wantErr  bool
+		calls    int
+	}{
+		{0, []error{deadlock}, true, 1},
+		{2, []error{deadlock}, false, 2},
+		{2, []error{deadlock, &pq.Error{Code: "40001"}}, false, 3},
+		{1, []error{deadlock, deadlock}, true, 2},
+		{2, []error{&pq.Error{Code: "42P07"}}, true, 1},
+	}
+	for i, tt := range tests {
+		logger := &recordingLogger{}
+		h := &migrationHistory{logger: logger, deadlockRetries: tt.retries}
+		calls := 0
+		var retrying []bool
+		err := h.retryDeadlock(func() error {
+			calls++
+			retrying = append(retrying, h.retrying)
+			if calls <= len(tt.failures) {
+				return tt.failures[calls-1]
+			}
+			return nil
+		})
+		if (err != nil) != tt.wantErr || calls != tt.calls {
+			t.Errorf("%d: unexpected error %v after %d calls", i, err, calls)
+		}
+		if retrying[calls-1] != (calls <= tt.retries) || h.retrying {
+			t.Errorf("%d: unexpected retrying state %v", i, retrying)
+		}
+		if len(logger.warnings) != calls-1 {
+			t.Errorf("%d: unexpected warnings %v", i, logger.warnings)
+		}
+	}
+}
 
-		err = db.AddMigrations(migrationsFs)
+func TestMigrateDeadlockRetries(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		attempts := 0
+		err := db.AddMigration("1.0.0", "deadlock", func(migration *Migration) {
+			migration.ExecSql("CREATE TABLE deadlock_retry (id INT)")
+			migration.ExecFn("deadlock", func(db *Database, migration *Migration, args ...interface{}) error {
+				attempts++
+				if attempts == 1 {
+					return &pq.Error{Code: "40P01", Message: "deadlock detected"}
+				}
+				return nil
+			})
+		})
 		if err != nil {
 			t.Fatal(err)
 		}
+		if err = db.Migrate(&MigrationConfig{Table: "history_deadlock", DeadlockRetries: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if attempts != 2 {
+			t.Errorf("expected 2 attempts, got %d", attempts)
+		}
 
-		err = db.Migrate(nil)
+		var failures int
+		if err = db.QueryRowOld("SELECT count(*) FROM history_deadlock WHERE NOT success").Scan(&failures); err != nil || failures != 0 {
+			t.Errorf("expected no failure recorded, got %d, %v", failures, err)
+		}
+		if exists, err := db.TableExists("public", "deadlock_retry"); err != nil || !exists {
+			t.Errorf("expected table created, got %v, %v", exists, err)
+		}
+	})
+}
+
+func TestMigrationCheckTableColumns(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	query := "SELECT column_name FROM information_schema.columns\nWHERE table_schema = $1\nAND table_name = $2"
+
+	var rows [][]sqldriver.Value
+	for _, column := range historyColumns {
+		rows = append(rows, []sqldriver.Value{column})
+	}
+	log.result(query, []string{"column_name"}, rows...)
+
+	h := &migrationHistory{schemaName: "public", tableName: "history"}
+	if err := h.checkTableColumns(db); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	h.extraColumns = []ColumnDef{{Name: "git_sha", Type: "TEXT"}}
+	if err := h.checkTableColumns(db); err == nil || !strings.Contains(err.Error(), "missing the columns git_sha") {
+		t.Errorf("expected missing extra column, got %v", err)
+	}
+
+	log.result(query, []string{"column_name"}, rows[1:]...)
+	h.extraColumns = nil
+	if err := h.checkTableColumns(db); err == nil || !strings.Contains(err.Error(), "installed_rank") {
+		t.Errorf("expected missing installed_rank, got %v", err)
+	}
+}
+
+func TestMigrateCreateTableSQL(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		createTableSql := func(schema, table string) (string, string) {
+			return "CREATE TABLE " + schema + "." + table + " (" +
+					"installed_rank INT NOT NULL PRIMARY KEY, version VARCHAR(50), description VARCHAR(200) NOT NULL, " +
+					"checksum CHARACTER(32), installed_on TIMESTAMP NOT NULL DEFAULT now(), " +
+					"execution_time INTEGER NOT NULL, success BOOLEAN NOT NULL" +
+					") WITH (fillfactor = 70)",
+				"CREATE INDEX " + table + "_s_idx ON " + schema + "." + table + " (success)"
+		}
+
+		if err := db.AddMigration("1.0.0", "create table", func(migration *Migration) {
+			migration.ExecSql("CREATE TABLE create_table_sql (id INT)")
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Migrate(&MigrationConfig{Table: "history_custom", CreateTableSQL: createTableSql}); err != nil {
+			t.Fatal(err)
+		}
+
+		var options []string
+		if err := db.QueryRowOld("SELECT reloptions FROM pg_class WHERE relname = 'history_custom'").Scan(ScanArray(&options)); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(options, []string{"fillfactor=70"}) {
+			t.Errorf("unexpected options %v", options)
+		}
+
+		// the required columns are validated
+		if err := db.AddMigration("1.0.0", "create table", func(migration *Migration) {}); err != nil {
+			t.Fatal(err)
+		}
+		err := db.Migrate(&MigrationConfig{Table: "history_invalid", CreateTableSQL: func(schema, table string) (string, string) {
+			return "CREATE TABLE " + schema + "." + table + " (installed_rank INT NOT NULL PRIMARY KEY)", ""
+		}})
+		if err == nil || !strings.Contains(err.Error(), "missing the columns") {
+			t.Errorf("expected missing columns error, got %v", err)
+		}
+		if exists, err := db.TableExists("public", "history_invalid"); err != nil || exists {
+			t.Errorf("expected table rolled back, got %v, %v", exists, err)
+		}
+	})
+}
+
+func TestNewMigrationFile(t *testing.T) {
+	dir := t.TempDir()
+
+	path, err := NewMigrationFile(dir, "1.2.0", " Add  orders status ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "v1.2.0_Add_orders_status.sql" {
+		t.Errorf("unexpected file %s", path)
+	}
+
+	db := &Database{}
+	if err = db.AddMigrations(os.DirFS(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.migrations) != 1 || db.migrations[0].Info.Version != "1.2.0" || db.migrations[0].Info.Description != "Add orders status" {
+		t.Fatalf("unexpected migrations %v", db.migrations)
+	}
+	if commands := db.migrations[0].Commands(); len(commands) != 1 || commands[0].SQL != "-- Migration 1.2.0: Add orders status\n\n" {
+		t.Errorf("unexpected commands %v", commands)
+	}
+
+	for _, invalid := range []struct{ version, description string }{
+		{"1.2.x", "invalid version"},
+		{"1.3.0", " "},
+		{"1.3.0", "../escape"},
+		{"1.2.0", "same version"},
+	} {
+		if _, err = NewMigrationFile(dir, invalid.version, invalid.description); err == nil {
+			t.Errorf("NewMigrationFile(%s, %q) expected error", invalid.version, invalid.description)
+		}
+	}
+	if _, err = NewMigrationFile(filepath.Join(dir, "missing"), "1.3.0", "missing dir"); err == nil {
+		t.Error("expected error for a missing directory")
+	}
+}
+
+func TestCheckInstalledOn(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	h := &migrationHistory{db: db, dbSchema: db, logger: &recordingLogger{}, schemaName: "public", tableName: "history"}
+	typeSql := "SELECT data_type FROM information_schema.columns\nWHERE table_schema = $1\nAND table_name = $2\n" +
+		"AND column_name = 'installed_on'"
+	log.result(typeSql, []string{"data_type"}, []sqldriver.Value{"timestamp without time zone"})
+
+	if err := h.checkInstalledOn(); err != nil {
+		t.Fatal(err)
+	}
+	if got := log.String(); got != typeSql {
+		t.Errorf("expected only the check without UpgradeInstalledOn, got %s", got)
+	}
+
+	// converted in its own transaction, holding no other lock of the history table
+	log.statements = nil
+	h.installedOnTz = true
+	if err := h.checkInstalledOn(); err != nil {
+		t.Fatal(err)
+	}
+	want := typeSql + ";BEGIN;LOCK TABLE \"public\".\"history\" IN ACCESS EXCLUSIVE MODE;" + typeSql +
+		";ALTER TABLE \"public\".\"history\" ALTER COLUMN installed_on TYPE TIMESTAMPTZ USING installed_on AT TIME ZONE 'UTC';COMMIT"
+	if got := log.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestMigrateInstalledOnTimezone(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		testDb := openTestDatabase(t, c)
+		testDb.Close()
+
+		// session in a non-UTC time zone
+		config := *testDb.config
+		config.Params = map[string][]string{"TimeZone": {"America/Sao_Paulo"}}
+		db, err := Open(&config)
 		if err != nil {
 			t.Fatal(err)
 		}
+		defer db.Close()
 
-		// dt.Test(t, db, []byte("SELECT 1"))
+		addMigration := func(version string) {
+			if err := db.AddMigration(version, "create "+version, func(migration *Migration) {
+				migration.ExecSql("SELECT 1")
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dataType := func(table string) string {
+			types, err := QueryColumn[string](db, "SELECT data_type FROM information_schema.columns "+
+				"WHERE table_name = $1 AND column_name = 'installed_on'", table)
+			if err != nil || len(types) != 1 {
+				t.Fatalf("unexpected column types %v, %v", types, err)
+			}
+			return types[0]
+		}
+		recent := func(table, expression string) bool {
+			ok, err := db.QueryForBoolean("SELECT bool_and(abs(extract(epoch FROM now() - " + expression + ")) < 60) FROM " + table)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return ok
+		}
+
+		addMigration("1.0.0")
+		if err = db.Migrate(&MigrationConfig{Table: "history_tz"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := dataType("history_tz"); got != "timestamp with time zone" {
+			t.Errorf("expected TIMESTAMPTZ, got %s", got)
+		}
+		if !recent("history_tz", "installed_on") {
+			t.Error("expected installed_on to be the current instant")
+		}
+
+		// history table created by an older version
+		if _, err = db.Execute("CREATE TABLE history_legacy (installed_rank INT NOT NULL PRIMARY KEY, version VARCHAR(50), " +
+			"description VARCHAR(200) NOT NULL, checksum CHARACTER(32), installed_on TIMESTAMP NOT NULL DEFAULT now(), " +
+			"execution_time INTEGER NOT NULL, success BOOLEAN NOT NULL)"); err != nil {
+			t.Fatal(err)
+		}
+		addMigration("1.0.0")
+		if err = db.Migrate(&MigrationConfig{Table: "history_legacy"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := dataType("history_legacy"); got != "timestamp without time zone" {
+			t.Errorf("expected the column unchanged, got %s", got)
+		}
+		if !recent("history_legacy", "(installed_on AT TIME ZONE 'UTC')") {
+			t.Error("expected installed_on recorded in UTC")
+		}
+
+		addMigration("1.0.0")
+		addMigration("1.1.0")
+		if err = db.Migrate(&MigrationConfig{Table: "history_legacy", UpgradeInstalledOn: true}); err != nil {
+			t.Fatal(err)
+		}
+		if got := dataType("history_legacy"); got != "timestamp with time zone" {
+			t.Errorf("expected TIMESTAMPTZ, got %s", got)
+		}
+		if !recent("history_legacy", "installed_on") {
+			t.Error("expected the converted values to be the same instants")
+		}
+
+		// concurrent upgrades convert the column once
+		if _, err = db.Execute("CREATE TABLE history_legacy_concurrent (installed_rank INT NOT NULL PRIMARY KEY, " +
+			"version VARCHAR(50), description VARCHAR(200) NOT NULL, checksum CHARACTER(32), " +
+			"installed_on TIMESTAMP NOT NULL DEFAULT now(), execution_time INTEGER NOT NULL, success BOOLEAN NOT NULL)"); err != nil {
+			t.Fatal(err)
+		}
+		db.migrations = nil
+		addMigration("1.0.0")
+		var wg sync.WaitGroup
+		errs := make([]error, 4)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = db.Migrate(&MigrationConfig{Table: "history_legacy_concurrent", UpgradeInstalledOn: true})
+			}(i)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		if got := dataType("history_legacy_concurrent"); got != "timestamp with time zone" {
+			t.Errorf("expected TIMESTAMPTZ, got %s", got)
+		}
+		if !recent("history_legacy_concurrent", "installed_on") {
+			t.Error("expected installed_on to be the current instant")
+		}
+	})
+}
+
+func TestApplyMigrationRepeatables(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		addMigrations := func(versions ...string) {
+			db.migrations = nil
+			for _, version := range versions {
+				table := "apply_r_" + strings.ReplaceAll(version, ".", "_")
+				if err := db.AddMigration(version, "create "+table, func(migration *Migration) {
+					migration.ExecSql("CREATE TABLE " + table + " (id INT)")
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, view := range []string{"apply_r_view_a", "apply_r_view_b"} {
+				view := view
+				if err := db.AddMigration("R", "refresh "+view, func(migration *Migration) {
+					migration.ExecSql("CREATE OR REPLACE VIEW " + view + " AS SELECT 1 AS id")
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		config := &MigrationConfig{Table: "history_apply_r"}
+
+		addMigrations("1.0.0")
+		if err := db.Migrate(config); err != nil {
+			t.Fatal(err)
+		}
+
+		addMigrations("1.0.0", "1.1.0")
+		if err := db.ApplyMigration(config, "1.1.0"); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.ApplyMigration(config, "1.1.0"); err == nil || !strings.Contains(err.Error(), "already applied") {
+			t.Errorf("expected already applied error, got %v", err)
+		}
+		// a repeatable migration is not compared with the other repeatable migrations applied
+		if err := db.ApplyMigration(config, "R"); err != nil {
+			t.Errorf("expected the repeatable migration applied again, got %v", err)
+		}
 	})
 }
@@ -0,0 +1,101 @@
+package pg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MigrationVerify verifies that the local migrations match the applied migrations, without applying anything.
+//
+// All checksum and description mismatches, as well as applied migrations not resolved locally (see
+// MigrationConfig.OnMissingLocal), are returned in a single error. Pending migrations are only logged.
+func (d *Database) MigrationVerify(config *MigrationConfig) error {
+	history, release, err := d.newMigrationHistory(config)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return history.Verify()
+}
+
+// Verify compares the local migrations with the applied migrations
+func (h *migrationHistory) Verify() error {
+	migrations, err := h.prepareMigrations()
+	if err != nil {
+		return err
+	}
+
+	var appliedMigrations []*MigrationInfo
+
+	if exists, err := h.schemaExists(h.schemaName); err != nil {
+		return err
+	} else if exists {
+		dbSchema, err := h.newSchemaConnection(h.searchPath)
+		if err != nil {
+			return err
+		}
+		defer dbSchema.Close()
+		h.dbSchema = dbSchema
+
+		if tableExists, err := h.tableExists(); err != nil {
+			return err
+		} else if tableExists {
+			if appliedMigrations, err = h.getAppliedMigrations(); err != nil {
+				return err
+			}
+		}
+	}
+
+	appliedByVersion := map[string]*MigrationInfo{}
+	for _, info := range appliedMigrations {
+		if info.Version != "R" {
+			appliedByVersion[info.Version] = info
+		}
+	}
+
+	var errs []error
+	var pending []string
+	for _, migration := range migrations {
+		resolved := migration.Info
+		if migration.Repeat {
+			continue
+		}
+
+		applied := appliedByVersion[resolved.Version]
+		delete(appliedByVersion, resolved.Version)
+
+		if applied == nil || applied.State != MigrationSuccess {
+			pending = append(pending, resolved.Identifier())
+			continue
+		}
+
+		if !migration.checksumMatches(applied.Checksum) {
+			errs = append(errs, errors.New(mismatchMessage("checksum", resolved.Identifier(), applied.Checksum, resolved.Checksum)))
+		}
+		if applied.Description != resolved.Description {
+			errs = append(errs, errors.New(mismatchMessage("description", resolved.Identifier(), applied.Description, resolved.Description)))
+		}
+	}
+
+	for _, info := range appliedMigrations {
+		if notResolved := appliedByVersion[info.Version]; notResolved == info {
+			if err := h.checkMissingLocal([]*MigrationInfo{info}, true); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	for _, identifier := range pending {
+		h.logger.Info("Pending migration: %s", identifier)
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(append([]error{errors.New(fmt.Sprintf(
+			"Validation of schema %s failed with %d error(s)", h.schemaName, len(errs),
+		))}, errs...)...)
+	}
+
+	h.logger.Info("Successfully validated schema %s (%d pending migrations)", h.schemaName, len(pending))
+	return nil
+}
@@ -0,0 +1,63 @@
+package pg
+
+import (
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// SemverComparator compares two semantic versions, without the "v" prefix (default MigrationConfig.VersionComparator).
+// The result will be 0 if a == b, -1 if a < b, or +1 if a > b.
+func SemverComparator(a, b string) int {
+	return semver.Compare("v"+a, "v"+b)
+}
+
+// SemverValidator checks whether the version is a valid semantic version, without the "v" prefix (default
+// MigrationConfig.VersionValidator)
+func SemverValidator(version string) bool {
+	return semver.IsValid("v" + version)
+}
+
+// NumericComparator compares versions made only of digits, like timestamps (20240115123000) or sequences (001, 002).
+// The result will be 0 if a == b, -1 if a < b, or +1 if a > b.
+func NumericComparator(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// NumericValidator checks whether the version is made only of digits
+func NumericValidator(version string) bool {
+	if version == "" {
+		return false
+	}
+	for _, c := range version {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// compare compares two versions using the configured comparator. An empty version is lower than any other.
+func (h *migrationHistory) compare(a, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == "" {
+		return -1
+	}
+	if b == "" {
+		return 1
+	}
+	if h.versionComparator == nil {
+		return SemverComparator(a, b)
+	}
+	return h.versionComparator(a, b)
+}
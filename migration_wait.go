@@ -0,0 +1,54 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// waitForIdleInterval interval between the checks of WaitForIdle
+const waitForIdleInterval = 200 * time.Millisecond
+
+// WaitForIdle Schedule a wait, in this migration, until no other session holds a lock on the table, so the next
+// blocking DDL (e.g. ALTER TABLE) does not queue behind long-running queries, blocking everything behind it.
+//
+// Sessions holding locks on the table are checked every 200ms, ignoring the session of the migration itself. When
+// the table is still in use after maxWait, the migration fails.
+//
+//	m.WaitForIdle("public.orders", 30*time.Second)
+//	m.ExecSql("ALTER TABLE orders ADD COLUMN notes TEXT")
+func (m *Migration) WaitForIdle(table string, maxWait time.Duration) {
+	m.execFn("wait for idle "+table, func(ctx context.Context, db *Database, migration *Migration, args ...interface{}) error {
+		return waitForIdle(ctx, db, table, maxWait)
+	})
+}
+
+// waitForIdle polls pg_locks until no other session holds a lock on the table. pg_locks is not part of the statistics
+// snapshot, so it reflects the current state even inside the transaction of the migration.
+func waitForIdle(ctx context.Context, db *Database, table string, maxWait time.Duration) error {
+	deadline := time.Now().Add(maxWait)
+	for {
+		var sessions int64
+		err := db.QueryRowCtx(ctx,
+			"SELECT count(DISTINCT pid) FROM pg_catalog.pg_locks WHERE relation = $1::regclass AND pid <> pg_backend_pid()",
+			table,
+		).Scan(&sessions)
+		if err != nil {
+			return errors.New("unable to check the sessions using table " + table + " (cause: " + err.Error() + ")")
+		}
+		if sessions == 0 {
+			return nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return errors.New(fmt.Sprintf("table %s still in use by %d sessions after %s", table, sessions, maxWait))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(waitForIdleInterval):
+		}
+	}
+}
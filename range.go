@@ -0,0 +1,232 @@
+package pg
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RangeBound are the Go types supported as bounds of a Range: int4range/int8range (int32, int64, int), numrange
+// (float64, or string to keep the exact decimal), tsrange/tstzrange (time.Time) and daterange (time.Time, scan only).
+type RangeBound interface {
+	~int | ~int32 | ~int64 | ~float64 | ~string | time.Time
+}
+
+// Range binds and scans PostgreSQL range types. An unbounded side is represented by LowerInf/UpperInf and the empty
+// range by Empty. Ex.
+//
+//	period := pg.Range[time.Time]{Lower: start, Upper: end, LowerInc: true}
+//	db.ExecCtx(ctx, "INSERT INTO bookings (period) VALUES ($1)", period)
+//
+//	var period pg.Range[time.Time]
+//	db.QueryRowCtx(ctx, "SELECT period FROM bookings WHERE id = $1", id).Scan(&period)
+//
+// Discrete ranges (int4range, int8range, daterange) are returned by PostgreSQL in the canonical form "[lower,upper)".
+// Range does not accept NULL, use *Range[T] for nullable columns.
+type Range[T RangeBound] struct {
+	Lower    T
+	Upper    T
+	LowerInc bool // lower bound is inclusive "["
+	UpperInc bool // upper bound is inclusive "]"
+	LowerInf bool // no lower bound, Lower is ignored
+	UpperInf bool // no upper bound, Upper is ignored
+	Empty    bool // the empty range, all the other fields are ignored
+}
+
+// Value implements the driver Valuer interface.
+func (r Range[T]) Value() (driver.Value, error) {
+	if r.Empty {
+		return "empty", nil
+	}
+
+	var s strings.Builder
+	if r.LowerInc && !r.LowerInf {
+		s.WriteByte('[')
+	} else {
+		s.WriteByte('(')
+	}
+	if !r.LowerInf {
+		text, err := formatTextValue(reflect.ValueOf(r.Lower))
+		if err != nil {
+			return nil, err
+		}
+		s.WriteString(quoteTextValue(text))
+	}
+	s.WriteByte(',')
+	if !r.UpperInf {
+		text, err := formatTextValue(reflect.ValueOf(r.Upper))
+		if err != nil {
+			return nil, err
+		}
+		s.WriteString(quoteTextValue(text))
+	}
+	if r.UpperInc && !r.UpperInf {
+		s.WriteByte(']')
+	} else {
+		s.WriteByte(')')
+	}
+	return s.String(), nil
+}
+
+// Scan implements the Scanner interface.
+func (r *Range[T]) Scan(src any) error {
+	var literal string
+	switch v := src.(type) {
+	case nil:
+		return errors.New(fmt.Sprintf("cannot scan NULL into %T, use a pointer", r))
+	case string:
+		literal = v
+	case []byte:
+		literal = string(v)
+	default:
+		return errors.New(fmt.Sprintf("unsupported type %T for range %T", src, r))
+	}
+
+	if strings.EqualFold(strings.TrimSpace(literal), "empty") {
+		*r = Range[T]{Empty: true}
+		return nil
+	}
+
+	literal = strings.TrimSpace(literal)
+	if len(literal) < 2 || (literal[0] != '[' && literal[0] != '(') ||
+		(literal[len(literal)-1] != ']' && literal[len(literal)-1] != ')') {
+		return errors.New(fmt.Sprintf("invalid range literal %q", literal))
+	}
+	elements, err := splitTextElements(literal[1 : len(literal)-1])
+	if err != nil || len(elements) != 2 {
+		return errors.New(fmt.Sprintf("invalid range literal %q", literal))
+	}
+
+	scanned := Range[T]{
+		LowerInc: literal[0] == '[',
+		UpperInc: literal[len(literal)-1] == ']',
+		LowerInf: elements[0].null,
+		UpperInf: elements[1].null,
+	}
+	if !scanned.LowerInf {
+		if err = parseTextValue(reflect.ValueOf(&scanned.Lower).Elem(), elements[0].text); err != nil {
+			return err
+		}
+	}
+	if !scanned.UpperInf {
+		if err = parseTextValue(reflect.ValueOf(&scanned.Upper).Elem(), elements[1].text); err != nil {
+			return err
+		}
+	}
+	*r = scanned
+	return nil
+}
+
+// textElement is an element of a range or composite literal, null for an unquoted empty element
+type textElement struct {
+	text string
+	null bool
+}
+
+// splitTextElements splits the comma separated elements of a range or composite literal (without the enclosing
+// brackets or parentheses), handling double quoted elements with backslash or doubled quote escapes.
+func splitTextElements(inner string) ([]textElement, error) {
+	var elements []textElement
+	var s strings.Builder
+	quoted, inQuotes := false, false
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		switch {
+		case c == '\\' && i+1 < len(inner):
+			i++
+			s.WriteByte(inner[i])
+		case c == '"':
+			if inQuotes && i+1 < len(inner) && inner[i+1] == '"' {
+				i++
+				s.WriteByte('"')
+			} else {
+				inQuotes = !inQuotes
+				quoted = true
+			}
+		case c == ',' && !inQuotes:
+			elements = append(elements, textElement{text: s.String(), null: !quoted && s.Len() == 0})
+			s.Reset()
+			quoted = false
+		default:
+			s.WriteByte(c)
+		}
+	}
+	if inQuotes {
+		return nil, errors.New("unterminated quoted element")
+	}
+	return append(elements, textElement{text: s.String(), null: !quoted && s.Len() == 0}), nil
+}
+
+// quoteTextValue double quotes the element of a range or composite literal
+func quoteTextValue(text string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text) + `"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// formatTextValue formats the value in the PostgreSQL text representation
+func formatTextValue(v reflect.Value) (string, error) {
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Format(timestamptzLayout), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedDataType, v.Type())
+}
+
+// parseTextValue parses the PostgreSQL text representation into dest
+func parseTextValue(dest reflect.Value, text string) error {
+	if dest.Type() == timeType {
+		t, err := pq.ParseTimestamp(time.UTC, text)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid timestamp %q (cause: %s)", text, err.Error()))
+		}
+		dest.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	var err error
+	switch dest.Kind() {
+	case reflect.String:
+		dest.SetString(text)
+	case reflect.Bool:
+		dest.SetBool(text == "t" || text == "true")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(text, 10, dest.Type().Bits()); err == nil {
+			dest.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		if n, err = strconv.ParseUint(text, 10, dest.Type().Bits()); err == nil {
+			dest.SetUint(n)
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = strconv.ParseFloat(text, dest.Type().Bits()); err == nil {
+			dest.SetFloat(f)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedDataType, dest.Type())
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("invalid value %q for %s (cause: %s)", text, dest.Type(), err.Error()))
+	}
+	return nil
+}
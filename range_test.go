@@ -0,0 +1,86 @@
+package pg
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dhui/dktest"
+)
+
+func TestRange(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(36 * time.Hour)
+
+	value, err := Range[time.Time]{Lower: start, Upper: end, LowerInc: true}.Value()
+	if err != nil || value != `["2024-01-01 10:00:00Z","2024-01-02 22:00:00Z")` {
+		t.Errorf("Value() = %v, %v", value, err)
+	}
+	if value, err = (Range[int64]{Upper: 10, UpperInc: true, LowerInf: true}).Value(); err != nil || value != `(,"10"]` {
+		t.Errorf("Value() = %v, %v", value, err)
+	}
+	if value, err = (Range[int64]{Empty: true}).Value(); err != nil || value != "empty" {
+		t.Errorf("Value() = %v, %v", value, err)
+	}
+
+	var period Range[time.Time]
+	if err = period.Scan([]byte(`["2024-01-01 10:00:00+00","2024-01-02 22:00:00+00")`)); err != nil {
+		t.Fatal(err)
+	}
+	if !period.Lower.Equal(start) || !period.Upper.Equal(end) || !period.LowerInc || period.UpperInc {
+		t.Errorf("Scan() = %+v", period)
+	}
+
+	var ids Range[int32]
+	if err = ids.Scan("[1,)"); err != nil || !reflect.DeepEqual(ids, Range[int32]{Lower: 1, LowerInc: true, UpperInf: true}) {
+		t.Errorf("Scan() = %+v, %v", ids, err)
+	}
+	var text Range[string]
+	if err = text.Scan(`("a,b","c\"d"]`); err != nil || !reflect.DeepEqual(text, Range[string]{Lower: "a,b", Upper: `c"d`, UpperInc: true}) {
+		t.Errorf("Scan() = %+v, %v", text, err)
+	}
+	if err = ids.Scan("empty"); err != nil || !ids.Empty {
+		t.Errorf("Scan(empty) = %+v, %v", ids, err)
+	}
+	for _, invalid := range []interface{}{nil, 10, "1,2", "[1,2,3)", "[a,2)"} {
+		if err = ids.Scan(invalid); err == nil {
+			t.Errorf("expected error for %v", invalid)
+		}
+	}
+}
+
+func TestRangeRoundTrip(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		start := time.Date(2024, 1, 1, 10, 0, 0, 500000000, time.UTC)
+		period := Range[time.Time]{Lower: start, Upper: start.Add(time.Hour), LowerInc: true}
+		unbounded := Range[time.Time]{Lower: start, LowerInc: true, UpperInf: true}
+
+		var gotPeriod, gotUnbounded Range[time.Time]
+		var ids, empty Range[int64]
+		var null *Range[int64]
+		err := db.QueryRowOld("SELECT $1::tstzrange, $2::tstzrange, int8range(1, 10, '[]'), 'empty'::int8range, NULL::int8range",
+			period, unbounded,
+		).Scan(&gotPeriod, &gotUnbounded, &ids, &empty, &null)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !gotPeriod.Lower.Equal(period.Lower) || !gotPeriod.Upper.Equal(period.Upper) || !gotPeriod.LowerInc || gotPeriod.UpperInc {
+			t.Errorf("unexpected tstzrange %+v", gotPeriod)
+		}
+		if !gotUnbounded.Lower.Equal(start) || !gotUnbounded.UpperInf {
+			t.Errorf("unexpected unbounded tstzrange %+v", gotUnbounded)
+		}
+		if !reflect.DeepEqual(ids, Range[int64]{Lower: 1, Upper: 11, LowerInc: true}) || !empty.Empty || null != nil {
+			t.Errorf("unexpected int8range %+v %+v %v", ids, empty, null)
+		}
+
+		var contains bool
+		if err = db.QueryRowOld("SELECT $1::tstzrange @> $2::timestamptz", period, start.Add(time.Minute)).Scan(&contains); err != nil || !contains {
+			t.Errorf("contains = %v, %v", contains, err)
+		}
+	})
+}
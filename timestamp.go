@@ -0,0 +1,45 @@
+package pg
+
+import (
+	"database/sql/driver"
+	"time"
+)
+
+const (
+	timestamptzLayout = "2006-01-02 15:04:05.999999Z07:00"
+	timestampLayout   = "2006-01-02 15:04:05.999999"
+)
+
+// Timestamptz binds the time to a timestamptz (timestamp with time zone) column, as an absolute instant. The value
+// is sent with its UTC offset, so the result does not depend on the TimeZone of the session.
+//
+// The value is sent as text, typed by PostgreSQL from the context of the parameter (e.g. the column of an INSERT or
+// UPDATE). Where the type cannot be inferred, as in SELECT $1 or in a comparison with text, cast the parameter
+// ($1::timestamptz). The zero time.Time is bound as NULL, as an unset time.
+func Timestamptz(t time.Time) driver.Valuer {
+	return &timeValuer{time: t, layout: timestamptzLayout}
+}
+
+// Timestamp binds the time to a timestamp (timestamp without time zone) column, as the wall clock of its location.
+// Ex. 2024-07-01 10:30 in Europe/Lisbon is stored as 2024-07-01 10:30:00, use Timestamp(t.UTC()) to store UTC.
+//
+// Binding a time.Time directly sends its UTC offset, which PostgreSQL discards for timestamp columns, Timestamp makes
+// that conversion explicit. As Timestamptz, the value is typed from the context (cast with $1::timestamp where it
+// cannot be inferred) and the zero time.Time is bound as NULL.
+func Timestamp(t time.Time) driver.Valuer {
+	return &timeValuer{time: t, layout: timestampLayout}
+}
+
+type timeValuer struct {
+	time   time.Time
+	layout string
+}
+
+// Value implements the driver Valuer interface.
+func (v *timeValuer) Value() (driver.Value, error) {
+	if v.time.IsZero() {
+		// unset time
+		return nil, nil
+	}
+	return v.time.Format(v.layout), nil
+}
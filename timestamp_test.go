@@ -0,0 +1,88 @@
+package pg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dhui/dktest"
+)
+
+func TestTimestampValuers(t *testing.T) {
+	lisbon, err := time.LoadLocation("Europe/Lisbon")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+
+	tests := []struct {
+		name  string
+		time  time.Time
+		tz    string
+		plain string
+	}{
+		// last instant before DST starts (01:00 jumps to 02:00)
+		{"before dst", time.Date(2024, 3, 31, 0, 59, 59, 0, lisbon), "2024-03-31 00:59:59Z", "2024-03-31 00:59:59"},
+		{"after dst", time.Date(2024, 3, 31, 2, 0, 0, 0, lisbon), "2024-03-31 02:00:00+01:00", "2024-03-31 02:00:00"},
+		// DST ends at 02:00, back to 01:00
+		{"before dst end", time.Date(2024, 10, 27, 0, 30, 0, 0, lisbon), "2024-10-27 00:30:00+01:00", "2024-10-27 00:30:00"},
+		{"after dst end", time.Date(2024, 10, 27, 2, 30, 0, 0, lisbon), "2024-10-27 02:30:00Z", "2024-10-27 02:30:00"},
+		{"utc midnight", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "2024-01-01 00:00:00Z", "2024-01-01 00:00:00"},
+		{"fraction", time.Date(2024, 1, 1, 23, 59, 59, 123456789, time.UTC), "2024-01-01 23:59:59.123456Z", "2024-01-01 23:59:59.123456"},
+	}
+
+	for _, tt := range tests {
+		if got, _ := Timestamptz(tt.time).Value(); got != tt.tz {
+			t.Errorf("%s: Timestamptz() = %v, want %s", tt.name, got, tt.tz)
+		}
+		if got, _ := Timestamp(tt.time).Value(); got != tt.plain {
+			t.Errorf("%s: Timestamp() = %v, want %s", tt.name, got, tt.plain)
+		}
+	}
+
+	if got, _ := Timestamptz(time.Time{}).Value(); got != nil {
+		t.Errorf("expected NULL for zero time, got %v", got)
+	}
+	if got, _ := Timestamp(time.Time{}).Value(); got != nil {
+		t.Errorf("expected NULL for zero time, got %v", got)
+	}
+}
+
+func TestTimestampBinding(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		conn, err := db.Conn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.CloseConn()
+
+		// the session time zone must not change the stored instant
+		if _, err = conn.Execute("SET TIME ZONE 'America/Sao_Paulo'"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.Execute("CREATE TABLE time_binding (tz TIMESTAMPTZ, plain TIMESTAMP)"); err != nil {
+			t.Fatal(err)
+		}
+
+		instant := time.Date(2024, 10, 27, 0, 30, 0, 0, time.FixedZone("+01:00", 3600))
+		_, err = conn.InsertInto("public", "time_binding", map[string]interface{}{
+			"tz":    Timestamptz(instant),
+			"plain": Timestamp(instant.UTC()),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var tz, plain string
+		err = conn.QueryRowOld(
+			"SELECT to_char(tz AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI'), to_char(plain, 'YYYY-MM-DD HH24:MI') FROM time_binding",
+		).Scan(&tz, &plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tz != "2024-10-26 23:30" || plain != "2024-10-26 23:30" {
+			t.Errorf("unexpected values tz=%s plain=%s", tz, plain)
+		}
+	})
+}
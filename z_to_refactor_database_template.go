@@ -3,11 +3,17 @@ package pg
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"reflect"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
 )
 
 var ErrOptimisticLock = errors.New("optimistic locking conflict occurs")
@@ -38,87 +44,117 @@ func (r *RowWraper) Err() error {
 
 // SelectRowWhere Executa um SELECT FROM WHERE
 func (d *Database) SelectRowWhere(table string, fields map[string]interface{}, condition map[string]interface{}) error {
+	return d.SelectRowWhereContext(context.Background(), table, fields, condition)
+}
 
+// SelectRowWhereContext Executa um SELECT FROM WHERE. The context cancels the prepare and the query.
+func (d *Database) SelectRowWhereContext(
+	ctx context.Context, table string, fields map[string]interface{}, condition map[string]interface{},
+) error {
+	columns := sortedKeys(fields)
 	var dest []any
-	query := "SELECT "
-	for key, ref := range fields {
-		query += QuoteIdentifier(key) + ", "
-		dest = append(dest, ref)
+	for _, column := range columns {
+		dest = append(dest, fields[column])
 	}
-	query = query[:len(query)-2] + " FROM " + QuoteIdentifier(table) + " WHERE "
 
-	var i = 1
-	var args []any
-	for key, value := range condition {
-		query += QuoteIdentifier(key) + " = $" + (strconv.Itoa(i)) + " AND "
-		args = append(args, value)
-		i++
+	query, args := d.BuildSelect(table, columns, condition)
+	return d.QueryRowCtx(ctx, query, args...).Scan(dest...)
+}
+
+// BuildSelect returns the SQL and args of a SELECT columns FROM WHERE condition, without executing it. An empty
+// condition selects all the rows.
+func (d *Database) BuildSelect(table string, columns []string, condition map[string]interface{}) (string, []interface{}) {
+	query := "SELECT "
+	for _, column := range columns {
+		query += QuoteIdentifier(column) + ", "
 	}
-	query = query[:len(query)-5]
+	query = query[:len(query)-2] + " FROM " + QuoteIdentifier(table)
 
-	return d.QueryRowOld(query, args...).Scan(dest...)
+	var args []interface{}
+	query += whereEquals(condition, &args)
+	return query, args
 }
 
 // InsertInto Executa um Insert Into
 func (d *Database) InsertInto(schema, table string, values map[string]interface{}) (sql.Result, error) {
+	query, args := d.BuildInsert(schema, table, values)
+	return d.Execute(query, args...)
+}
 
-	var i = 1
-	var args []any
+// BuildInsert returns the SQL and args of an INSERT INTO, without executing it. The columns are sorted by name.
+func (d *Database) BuildInsert(schema, table string, values map[string]interface{}) (string, []interface{}) {
+	var args []interface{}
 
 	query := "INSERT INTO " + QuoteIdentifier(schema) + "." + QuoteIdentifier(table) + " ("
 	sqlValues := ") VALUES ("
-	for key, value := range values {
+	for _, key := range sortedKeys(values) {
+		args = append(args, bindValue(values[key]))
 		query += QuoteIdentifier(key) + ", "
-		sqlValues += "$" + (strconv.Itoa(i)) + ", "
-		args = append(args, value)
-		i++
+		sqlValues += "$" + strconv.Itoa(len(args)) + ", "
+	}
+	for _, column := range d.missingTimestamps(values, true) {
+		query += QuoteIdentifier(column) + ", "
+		sqlValues += "now(), "
 	}
 	query = query[:len(query)-2] + sqlValues[:len(sqlValues)-2] + ")"
 
-	return d.Execute(query, args...)
+	return query, args
 }
 
-// DeleteWhere Executa um DELETE FROM WHERE
+// DeleteWhere Executa um DELETE FROM WHERE. The condition is required, use TRUNCATE to delete all rows.
 func (d *Database) DeleteWhere(table string, condition map[string]interface{}) (sql.Result, error) {
-
-	var i = 1
-	var args = []interface{}{}
-
-	query := "DELETE FROM " + QuoteIdentifier(table) + " WHERE "
-	for key, value := range condition {
-		query += QuoteIdentifier(key) + " = $" + (strconv.Itoa(i)) + " AND "
-		args = append(args, value)
-		i++
+	if len(condition) == 0 {
+		return nil, errRequiresCondition("DeleteWhere", table)
 	}
-	query = query[:len(query)-5]
-
+	query, args := d.BuildDelete(table, condition)
 	return d.Execute(query, args...)
 }
 
-// Update Executa uma query UPDATE SET values WHERE condition
+// BuildDelete returns the SQL and args of a DELETE FROM WHERE, without executing it. Panics when the condition is
+// empty, as the statement would delete all rows (see DeleteWhere).
+func (d *Database) BuildDelete(table string, condition map[string]interface{}) (string, []interface{}) {
+	if len(condition) == 0 {
+		panic(errRequiresCondition("BuildDelete", table))
+	}
+	var args = []interface{}{}
+	query := "DELETE FROM " + QuoteIdentifier(table) + whereEquals(condition, &args)
+	return query, args
+}
+
+// Update Executa uma query UPDATE SET values WHERE condition. The condition is required, use Execute to update all
+// rows.
 func (d *Database) Update(
 	schema, table string, values map[string]interface{}, condition map[string]interface{},
 ) (sql.Result, error) {
+	if len(condition) == 0 {
+		return nil, errRequiresCondition("Update", schema+"."+table)
+	}
+	query, args := d.BuildUpdate(schema, table, values, condition)
+	return d.Execute(query, args...)
+}
 
-	var i = 1
-	var args []any
+// BuildUpdate returns the SQL and args of an UPDATE SET values WHERE condition, without executing it. The values
+// args come first, followed by the condition args. Panics when the condition is empty, as the statement would update
+// all rows (see Update).
+func (d *Database) BuildUpdate(
+	schema, table string, values map[string]interface{}, condition map[string]interface{},
+) (string, []interface{}) {
+	if len(condition) == 0 {
+		panic(errRequiresCondition("BuildUpdate", schema+"."+table))
+	}
+	var args []interface{}
 
 	query := "UPDATE " + QuoteIdentifier(schema) + "." + QuoteIdentifier(table) + " SET "
-	for key, value := range values {
-		query += QuoteIdentifier(key) + " = $" + (strconv.Itoa(i)) + ", "
-		args = append(args, value)
-		i++
+	for _, key := range sortedKeys(values) {
+		args = append(args, bindValue(values[key]))
+		query += QuoteIdentifier(key) + " = $" + strconv.Itoa(len(args)) + ", "
 	}
-	query = query[:len(query)-2] + " WHERE "
-
-	for key, value := range condition {
-		query += QuoteIdentifier(key) + " = $" + (strconv.Itoa(i)) + " AND "
-		args = append(args, value)
-		i++
+	for _, column := range d.missingTimestamps(values, false) {
+		query += QuoteIdentifier(column) + " = now(), "
 	}
-	query = query[:len(query)-5] // remove " AND "
+	query = query[:len(query)-2] + whereEquals(condition, &args)
 
-	return d.Execute(query, args...)
+	return query, args
 }
 
 // UpdateOptimisticLock Executa uma query UPDATE SET values WHERE condition
@@ -142,34 +178,149 @@ func (d *Database) UpdateOptimisticLock(
 
 // Upsert Executa uma query INSERT INTO ON CONFLICT UPDATE SET
 func (d *Database) Upsert(table string, values map[string]interface{}, conflictField string) (sql.Result, error) {
+	query, args := d.BuildUpsert(table, values, conflictField)
+	return d.Execute(query, args...)
+}
 
-	// @TODO: ordenar keys para que o prepare statement nao seja comprometido
-
-	var i = 1
+// BuildUpsert returns the SQL and args of an INSERT INTO ON CONFLICT UPDATE SET, without executing it
+func (d *Database) BuildUpsert(table string, values map[string]interface{}, conflictField string) (string, []interface{}) {
 	var args = []interface{}{}
 
-	sql := "INSERT INTO " + QuoteIdentifier(table) + " ("
+	query := "INSERT INTO " + QuoteIdentifier(table) + " ("
 	sqlValues := ") VALUES ("
 	sqlUpdate := ") ON CONFLICT (" + QuoteIdentifier(conflictField) + ") DO UPDATE SET "
-	for key, value := range values {
-		sql += QuoteIdentifier(key) + ", "
-		sqlValues += "$" + (strconv.Itoa(i)) + ", "
-		args = append(args, value)
+	for _, key := range sortedKeys(values) {
+		args = append(args, bindValue(values[key]))
+		placeholder := "$" + strconv.Itoa(len(args))
+		query += QuoteIdentifier(key) + ", "
+		sqlValues += placeholder + ", "
 		if key != conflictField {
-			sqlUpdate += QuoteIdentifier(key) + " = $" + (strconv.Itoa(i)) + ", "
+			sqlUpdate += QuoteIdentifier(key) + " = " + placeholder + ", "
+		}
+	}
+	query = query[:len(query)-2] + sqlValues[:len(sqlValues)-2] + sqlUpdate[:len(sqlUpdate)-2]
+
+	return query, args
+}
+
+// whereEquals builds the WHERE clause matching all the columns of the condition, sorted by name, appending the values
+// to args. Returns "" when the condition is empty.
+func whereEquals(condition map[string]interface{}, args *[]interface{}) string {
+	if len(condition) == 0 {
+		return ""
+	}
+	var where []string
+	for _, key := range sortedKeys(condition) {
+		*args = append(*args, bindValue(condition[key]))
+		where = append(where, QuoteIdentifier(key)+" = $"+strconv.Itoa(len(*args)))
+	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
+// errRequiresCondition the error of the statements that refuse an empty condition, which would affect all rows
+func errRequiresCondition(operation, table string) error {
+	return errors.New(operation + " requires a condition, refusing to affect all rows of " + table)
+}
+
+// sortedKeys the keys of the map, sorted, so the same columns always produce the same statement (see Prepare)
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// UpsertConditional executes an INSERT INTO ON CONFLICT DO UPDATE SET WHERE, for conditional upserts (e.g.
+// last-writer-wins). The conflicting row is only updated when updateWhere is true.
+//
+// The update uses the proposed values (excluded.column). In updateWhere, reference the proposed row as excluded and
+// the existing row by the table name, with placeholders numbered from $1 for whereArgs, like
+// "excluded.updated_at > events.updated_at AND events.source = $1".
+func (d *Database) UpsertConditional(
+	schema, table string, values map[string]interface{}, conflictCols []string, updateWhere string, whereArgs ...interface{},
+) (sql.Result, error) {
+	query, args := buildUpsertConditional(schema, table, values, conflictCols, updateWhere, whereArgs...)
+	return d.Execute(query, args...)
+}
+
+func buildUpsertConditional(
+	schema, table string, values map[string]interface{}, conflictCols []string, updateWhere string, whereArgs ...interface{},
+) (string, []interface{}) {
+	keys := sortedKeys(values)
+
+	isConflictCol := map[string]bool{}
+	var conflict []string
+	for _, col := range conflictCols {
+		isConflictCol[col] = true
+		conflict = append(conflict, QuoteIdentifier(col))
+	}
+
+	var args []interface{}
+	var columns, placeholders, updates []string
+	for i, key := range keys {
+		columns = append(columns, QuoteIdentifier(key))
+		placeholders = append(placeholders, "$"+strconv.Itoa(i+1))
+		args = append(args, bindValue(values[key]))
+		if !isConflictCol[key] {
+			updates = append(updates, QuoteIdentifier(key)+" = excluded."+QuoteIdentifier(key))
+		}
+	}
+
+	query := "INSERT INTO " + QuoteIdentifier(schema) + "." + QuoteIdentifier(table) +
+		" (" + strings.Join(columns, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")" +
+		" ON CONFLICT (" + strings.Join(conflict, ", ") + ")"
+
+	if len(updates) == 0 {
+		return query + " DO NOTHING", args
+	}
+
+	query += " DO UPDATE SET " + strings.Join(updates, ", ")
+
+	if updateWhere != "" {
+		query += " WHERE " + renumberPlaceholders(updateWhere, len(args))
+		for _, arg := range whereArgs {
+			args = append(args, bindValue(arg))
 		}
-		i++
 	}
-	sql = sql[:len(sql)-2] + sqlValues[:len(sqlValues)-2] + sqlUpdate[:len(sqlUpdate)-2]
 
-	return d.Execute(sql, args...)
+	return query, args
+}
+
+// bindValue wraps slices (except []byte, which is BYTEA) with pq.Array, so they can be used with array columns
+func bindValue(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if _, isValuer := value.(driver.Valuer); isValuer {
+		return value
+	}
+
+	t := reflect.TypeOf(value)
+	if (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() != reflect.Uint8 {
+		return pq.Array(value)
+	}
+	return value
 }
 
 // Query executes a prepared query statement with the given arguments
 // and returns the query results as a *Rows.
+//
+// When the server terminated the connection (e.g. restart or failover), the query is prepared and executed again,
+// once, on a new connection. Transactions and connections obtained with Conn are not retried.
 func (d *Database) Query(query string, args ...interface{}) (*sql.Rows, error) {
 	d.debugQuery(query, args...)
 
+	rows, err := d.query(query, args...)
+	if d.retryConnectionLost(err) {
+		rows, err = d.query(query, args...)
+	}
+	d.metrics.query(err)
+	return rows, err
+}
+
+func (d *Database) query(query string, args ...interface{}) (*sql.Rows, error) {
 	statement, err := d.Prepare(query)
 	if err != nil {
 		return nil, err
@@ -185,7 +336,9 @@ func (d *Database) QueryRow(query string, args ...interface{}) (row *sql.Row, er
 
 	var statement *sql.Stmt
 
-	if statement, err = d.Prepare(query); err != nil {
+	statement, err = d.Prepare(query)
+	d.metrics.query(err)
+	if err != nil {
 		return
 	}
 
@@ -194,19 +347,31 @@ func (d *Database) QueryRow(query string, args ...interface{}) (row *sql.Row, er
 	return statement.QueryRow(args...), nil
 }
 
+// QueryRowOld executes a query that is expected to return at most one row.
+//
+// Deprecated: use QueryRowCtx, which allows cancelling the query.
 func (d *Database) QueryRowOld(query string, args ...interface{}) *RowWraper {
+	return d.QueryRowCtx(context.Background(), query, args...)
+}
+
+// QueryRowCtx executes a query that is expected to return at most one row. The context cancels the prepare and the
+// query, errors are deferred until Scan.
+func (d *Database) QueryRowCtx(ctx context.Context, query string, args ...interface{}) *RowWraper {
 	d.debugQuery(query, args...)
 
-	statement, err := d.Prepare(query)
+	statement, err := d.PrepareContext(ctx, query)
+	d.metrics.query(err)
 	if err != nil {
 		return &RowWraper{err: err}
 	}
 
 	defer statement.Close()
 
-	return &RowWraper{row: statement.QueryRow(args...)}
+	return &RowWraper{row: statement.QueryRowContext(ctx, args...)}
 }
 
+// QueryForBoolean gets the result of a query that returns a boolean value. IMPORTANT! Unlike QueryForInt, when the
+// query returns no rows, sql.ErrNoRows is returned. See QueryForBooleanFound.
 func (d *Database) QueryForBoolean(query string, args ...interface{}) (bool, error) {
 
 	d.debugQuery(query, args...)
@@ -214,6 +379,7 @@ func (d *Database) QueryForBoolean(query string, args ...interface{}) (bool, err
 	statement, err := d.Prepare(query)
 
 	if err != nil {
+		d.metrics.query(err)
 		return false, err
 	}
 
@@ -221,11 +387,12 @@ func (d *Database) QueryForBoolean(query string, args ...interface{}) (bool, err
 
 	var result bool
 	err = statement.QueryRow(args...).Scan(&result)
+	d.metrics.query(err)
 	return result, err
 }
 
 // QueryForInt Obtém o resultado de uma query que busca por um valor. IMPORTANTE! Quando a query nao retornar registros
-// esse método ira retornar 0 como resposta
+// esse método ira retornar 0 como resposta. To distinguish "not found" from zero, see QueryForIntFound.
 func (d *Database) QueryForInt(query string, args ...interface{}) (int64, error) {
 
 	d.debugQuery(query, args...)
@@ -233,6 +400,7 @@ func (d *Database) QueryForInt(query string, args ...interface{}) (int64, error)
 	statement, err := d.Prepare(query)
 
 	if err != nil {
+		d.metrics.query(err)
 		return 0, err
 	}
 
@@ -240,22 +408,67 @@ func (d *Database) QueryForInt(query string, args ...interface{}) (int64, error)
 
 	var result int64
 	err = statement.QueryRow(args...).Scan(&result)
+	d.metrics.query(err)
 	if err == sql.ErrNoRows {
 		return 0, nil
 	}
 	return result, err
 }
 
+// QueryForIntFound gets the result of a query that returns an integer value. found is false when the query returns
+// no rows.
+func (d *Database) QueryForIntFound(query string, args ...interface{}) (result int64, found bool, err error) {
+	found, err = d.queryForValue(&result, query, args...)
+	return
+}
+
+// QueryForBooleanFound gets the result of a query that returns a boolean value. found is false when the query
+// returns no rows.
+func (d *Database) QueryForBooleanFound(query string, args ...interface{}) (result bool, found bool, err error) {
+	found, err = d.queryForValue(&result, query, args...)
+	return
+}
+
+func (d *Database) queryForValue(dest any, query string, args ...interface{}) (bool, error) {
+	d.debugQuery(query, args...)
+
+	statement, err := d.Prepare(query)
+	if err != nil {
+		d.metrics.query(err)
+		return false, err
+	}
+
+	defer statement.Close()
+
+	err = statement.QueryRow(args...).Scan(dest)
+	d.metrics.query(err)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (d *Database) Prepare(query string) (*sql.Stmt, error) {
+	return d.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext creates a prepared statement for later queries or executions. The context is used for the
+// preparation of the statement, not for its execution.
+func (d *Database) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
 	var statement *sql.Stmt
 	var err error
 
+	if d.closed {
+		return nil, ErrConnClosed
+	}
+
 	if d.tx != nil {
-		statement, err = d.tx.Prepare(query)
+		statement, err = d.tx.PrepareContext(ctx, query)
 	} else if d.conn != nil {
-		statement, err = d.conn.PrepareContext(context.Background(), query)
+		statement, err = d.conn.PrepareContext(ctx, query)
+		err = connClosedErr(err)
 	} else {
-		statement, err = d.db.Prepare(query)
+		statement, err = d.db.PrepareContext(ctx, query)
 	}
 	return statement, err
 }
@@ -263,14 +476,52 @@ func (d *Database) Prepare(query string) (*sql.Stmt, error) {
 // Execute executes a query without returning any rows.
 // The args are for any placeholder parameters in the query.
 func (d *Database) Execute(query string, args ...interface{}) (sql.Result, error) {
+	return d.ExecuteContext(context.Background(), query, args...)
+}
+
+// ExecuteContext executes a query without returning any rows.
+// The args are for any placeholder parameters in the query.
+//
+// When the server terminated the connection (e.g. restart or failover), the query is executed again, once, on a new
+// connection. Transactions and connections obtained with Conn are not retried.
+func (d *Database) ExecuteContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if d.readOnly {
+		return nil, ErrReadOnly
+	}
+
 	d.debugQuery(query, args...)
 
+	result, err := d.exec(ctx, query, args...)
+	if d.retryConnectionLost(err) {
+		result, err = d.exec(ctx, query, args...)
+	}
+	d.metrics.exec(err)
+	return result, err
+}
+
+// ExecuteRows executes a query without returning any rows and returns the number of rows affected by it.
+//
+// PostgreSQL does not support sql.Result.LastInsertId, use INSERT ... RETURNING with QueryRow instead.
+func (d *Database) ExecuteRows(query string, args ...interface{}) (int64, error) {
+	result, err := d.Execute(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (d *Database) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if d.closed {
+		return nil, ErrConnClosed
+	}
+
 	if d.tx != nil {
-		return d.tx.Exec(query, args...)
+		return d.tx.ExecContext(ctx, query, args...)
 	} else if d.conn != nil {
-		return d.conn.ExecContext(context.Background(), query, args...)
+		result, err := d.conn.ExecContext(ctx, query, args...)
+		return result, connClosedErr(err)
 	} else {
-		return d.db.Exec(query, args...)
+		return d.db.ExecContext(ctx, query, args...)
 	}
 }
 
@@ -315,6 +566,9 @@ func (d *Database) Savepoint(savepoint string, callback func() error) error {
 }
 
 // Transaction Executes this callback within a transaction
+//
+// Only the operations using the db received by the callback are part of the transaction. Helpers that resolve their
+// own Database (e.g. through GetInstance) must receive it, directly or via db.WithContext.
 func (d *Database) Transaction(callback func(db *Database) error) error {
 
 	db, err := d.Begin()
@@ -352,7 +606,7 @@ func (d *Database) debugQuery(query string, args ...interface{}) {
 	if !d.config.DebugSql {
 		return
 	}
-	msg := "\n    " + strings.ReplaceAll(query, "\n", "\n    ")
+	msg := "\n    " + strings.ReplaceAll(truncateSql(query, d.maxSqlLog), "\n", "\n    ")
 	if len(args) > 0 {
 		msg += "\n"
 		for i, arg := range args {
@@ -362,3 +616,15 @@ func (d *Database) debugQuery(query string, args ...interface{}) {
 	}
 	d.logger.Info(msg)
 }
+
+// truncateSql limits the length (in bytes) of the SQL logged in debug messages, without splitting a multibyte
+// character. Zero does not truncate.
+func truncateSql(sql string, maxLen int) string {
+	if maxLen <= 0 || len(sql) <= maxLen {
+		return sql
+	}
+	for maxLen > 0 && !utf8.RuneStart(sql[maxLen]) {
+		maxLen--
+	}
+	return sql[:maxLen] + "... (truncated)"
+}
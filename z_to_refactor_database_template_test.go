@@ -0,0 +1,282 @@
+package pg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dhui/dktest"
+	"github.com/lib/pq"
+)
+
+func Test_bindValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  interface{}
+	}{
+		{nil, nil},
+		{"text", "text"},
+		{[]byte("bytea"), []byte("bytea")},
+		{[]string{"a", "b"}, pq.Array([]string{"a", "b"})},
+		{[]int{1, 2}, pq.Array([]int{1, 2})},
+		{pq.Array([]int64{1}), pq.Array([]int64{1})},
+	}
+
+	for _, tt := range tests {
+		if got := bindValue(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("bindValue(%#v) = %#v, want %#v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestArrayBinding(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		if _, err := db.Execute("CREATE TABLE array_binding (id INT PRIMARY KEY, tags TEXT[], scores INT[])"); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := db.InsertInto("public", "array_binding", map[string]interface{}{
+			"id":     1,
+			"tags":   []string{"a", "b"},
+			"scores": []int{1, 2},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.Update("public", "array_binding", map[string]interface{}{"tags": []string{"c"}}, map[string]interface{}{"id": 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var tags []string
+		var scores []int64
+		err = db.QueryRowOld("SELECT tags, scores FROM array_binding WHERE id = 1").Scan(pq.Array(&tags), pq.Array(&scores))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(tags, []string{"c"}) || !reflect.DeepEqual(scores, []int64{1, 2}) {
+			t.Errorf("unexpected values tags=%v scores=%v", tags, scores)
+		}
+	})
+}
+
+func Test_buildUpsertConditional(t *testing.T) {
+	query, args := buildUpsertConditional("public", "events", map[string]interface{}{
+		"id":         "e1",
+		"payload":    "data",
+		"updated_at": 10,
+	}, []string{"id"}, "excluded.updated_at > events.updated_at AND events.source = $1", "api")
+
+	want := `INSERT INTO "public"."events" ("id", "payload", "updated_at") VALUES ($1, $2, $3)` +
+		` ON CONFLICT ("id") DO UPDATE SET "payload" = excluded."payload", "updated_at" = excluded."updated_at"` +
+		` WHERE excluded.updated_at > events.updated_at AND events.source = $4`
+	if query != want {
+		t.Errorf("got  %s\nwant %s", query, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"e1", "data", 10, "api"}) {
+		t.Errorf("unexpected args %v", args)
+	}
+}
+
+func TestUpsertConditional(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		if _, err := db.Execute("CREATE TABLE events (id TEXT PRIMARY KEY, payload TEXT, updated_at INT)"); err != nil {
+			t.Fatal(err)
+		}
+
+		upsert := func(payload string, updatedAt int) {
+			_, err := db.UpsertConditional("public", "events", map[string]interface{}{
+				"id":         "e1",
+				"payload":    payload,
+				"updated_at": updatedAt,
+			}, []string{"id"}, "excluded.updated_at > events.updated_at")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		upsert("first", 1)
+		upsert("third", 3)
+		upsert("stale", 2)
+
+		var payload string
+		if err := db.QueryRowOld("SELECT payload FROM events WHERE id = 'e1'").Scan(&payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload != "third" {
+			t.Errorf("expected stale update to be skipped, got %s", payload)
+		}
+	})
+}
+
+func TestBuilders(t *testing.T) {
+	db := &Database{}
+	values := map[string]interface{}{"name": "alice", "tags": []string{"a"}, "age": 30}
+	condition := map[string]interface{}{"tenant": "t1", "id": 7}
+
+	tests := []struct {
+		name     string
+		build    func() (string, []interface{})
+		wantSql  string
+		wantArgs []interface{}
+	}{
+		{
+			"select",
+			func() (string, []interface{}) { return db.BuildSelect("users", []string{"name", "age"}, condition) },
+			`SELECT "name", "age" FROM "users" WHERE "id" = $1 AND "tenant" = $2`,
+			[]interface{}{7, "t1"},
+		},
+		{
+			"select all",
+			func() (string, []interface{}) { return db.BuildSelect("users", []string{"name"}, nil) },
+			`SELECT "name" FROM "users"`,
+			nil,
+		},
+		{
+			"select array condition",
+			func() (string, []interface{}) {
+				return db.BuildSelect("users", []string{"name"}, map[string]interface{}{"tags": []string{"a", "b"}})
+			},
+			`SELECT "name" FROM "users" WHERE "tags" = $1`,
+			[]interface{}{pq.Array([]string{"a", "b"})},
+		},
+		{
+			"insert",
+			func() (string, []interface{}) { return db.BuildInsert("public", "users", values) },
+			`INSERT INTO "public"."users" ("age", "name", "tags") VALUES ($1, $2, $3)`,
+			[]interface{}{30, "alice", pq.Array([]string{"a"})},
+		},
+		{
+			"update",
+			func() (string, []interface{}) { return db.BuildUpdate("public", "users", values, condition) },
+			`UPDATE "public"."users" SET "age" = $1, "name" = $2, "tags" = $3 WHERE "id" = $4 AND "tenant" = $5`,
+			[]interface{}{30, "alice", pq.Array([]string{"a"}), 7, "t1"},
+		},
+		{
+			"delete",
+			func() (string, []interface{}) { return db.BuildDelete("users", condition) },
+			`DELETE FROM "users" WHERE "id" = $1 AND "tenant" = $2`,
+			[]interface{}{7, "t1"},
+		},
+		{
+			"upsert",
+			func() (string, []interface{}) { return db.BuildUpsert("users", values, "name") },
+			`INSERT INTO "users" ("age", "name", "tags") VALUES ($1, $2, $3) ON CONFLICT ("name") DO UPDATE SET "age" = $1, "tags" = $3`,
+			[]interface{}{30, "alice", pq.Array([]string{"a"})},
+		},
+	}
+
+	for _, tt := range tests {
+		query, args := tt.build()
+		if query != tt.wantSql {
+			t.Errorf("%s: got  %s\nwant %s", tt.name, query, tt.wantSql)
+		}
+		if !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("%s: got args %#v, want %#v", tt.name, args, tt.wantArgs)
+		}
+	}
+}
+
+func TestEmptyCondition(t *testing.T) {
+	db := &Database{}
+	values := map[string]interface{}{"name": "alice"}
+
+	if _, err := db.DeleteWhere("users", nil); err == nil {
+		t.Error("DeleteWhere: expected error for an empty condition")
+	}
+	if _, err := db.Update("public", "users", values, map[string]interface{}{}); err == nil {
+		t.Error("Update: expected error for an empty condition")
+	}
+
+	tests := map[string]func(){
+		"BuildDelete": func() { db.BuildDelete("users", nil) },
+		"BuildUpdate": func() { db.BuildUpdate("public", "users", values, nil) },
+	}
+	for name, build := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected panic for an empty condition", name)
+				}
+			}()
+			build()
+		}()
+	}
+}
+
+func TestExecuteRows(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		if _, err := db.Execute("CREATE TABLE execute_rows (id INT); INSERT INTO execute_rows SELECT generate_series(1, 5)"); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			query string
+			want  int64
+		}{
+			{"UPDATE execute_rows SET id = id * 10 WHERE id <= 3", 3},
+			{"UPDATE execute_rows SET id = 0 WHERE id = 99", 0},
+			{"DELETE FROM execute_rows WHERE id >= 10", 3},
+			{"DELETE FROM execute_rows", 2},
+		}
+		for _, tt := range tests {
+			if got, err := db.ExecuteRows(tt.query); err != nil || got != tt.want {
+				t.Errorf("ExecuteRows(%s) = %d, %v, want %d", tt.query, got, err, tt.want)
+			}
+		}
+
+		if _, err := db.ExecuteRows("DELETE FROM missing_table"); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestTransactionDeferred(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		_, err := db.Execute(`CREATE TABLE deferred_parent (id INT PRIMARY KEY, child_id INT);
+			CREATE TABLE deferred_child (id INT PRIMARY KEY, parent_id INT NOT NULL
+				REFERENCES deferred_parent (id) DEFERRABLE INITIALLY IMMEDIATE);
+			ALTER TABLE deferred_parent ADD FOREIGN KEY (child_id) REFERENCES deferred_child (id)
+				DEFERRABLE INITIALLY IMMEDIATE`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		load := func(db *Database) error {
+			// the child references a parent not inserted yet
+			if _, err := db.Execute("INSERT INTO deferred_child VALUES (1, 1)"); err != nil {
+				return err
+			}
+			_, err := db.Execute("INSERT INTO deferred_parent VALUES (1, 1)")
+			return err
+		}
+
+		if err = db.Transaction(load); err == nil {
+			t.Fatal("expected foreign key violation without deferred constraints")
+		}
+		if err = db.TransactionDeferred(load); err != nil {
+			t.Fatalf("expected constraints to be checked on commit, got %v", err)
+		}
+
+		// still violated at commit
+		err = db.TransactionDeferred(func(db *Database) error {
+			_, err := db.Execute("INSERT INTO deferred_child VALUES (2, 99)")
+			return err
+		})
+		if err == nil {
+			t.Error("expected foreign key violation on commit")
+		}
+	})
+}
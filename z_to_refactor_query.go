@@ -1,6 +1,7 @@
 package pg
 
 import (
+	"context"
 	"database/sql"
 	"strings"
 )
@@ -49,6 +50,14 @@ func (q *Query) With(db *Database) *Query {
 	}
 }
 
+// WithContext uses the Database carried by ctx (see Database.WithContext), when present
+func (q *Query) WithContext(ctx context.Context) *Query {
+	if db, ok := FromContext(ctx); ok {
+		return q.With(db)
+	}
+	return q
+}
+
 func (q *Query) Retry(retries int) *Query {
 	return &Query{
 		retries: retries,
@@ -1,9 +1,14 @@
 package pg
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
 )
 
 var ErrUnsupportedDataType = errors.New("unsupported data type")
@@ -33,9 +38,31 @@ type Table[T any] struct {
 	schema     string
 	table      string
 	identifier string // "schema"."table"
+	columns    []tableColumn
+	primaryKey []tableColumn
+	indexes    []TableIndex
 	db         *Database
 }
 
+// tableColumn a column mapped from a field of the model. The column name is taken from the `pg` tag (defaults to the
+// snake_case field name), fields tagged `pg:"-"` are ignored and `pg:"name,pk"` marks a primary key column.
+//
+//	type Member struct {
+//		TenantId string `pg:"tenant_id,pk"`
+//		Id       int64  `pg:"id,pk"`
+//		Name     string
+//	}
+type tableColumn struct {
+	name  string
+	index []int
+	pk    bool
+}
+
+// TableIndexer can be implemented by the model to declare the indexes of the table
+type TableIndexer interface {
+	Indexes() []TableIndex
+}
+
 func (t *Table[T]) model() *T {
 	return new(T)
 }
@@ -45,10 +72,50 @@ func (t *Table[T]) Using(db *Database) *Table[T] {
 		schema:     t.schema,
 		table:      t.table,
 		identifier: t.identifier,
+		columns:    t.columns,
+		primaryKey: t.primaryKey,
+		indexes:    t.indexes,
 		db:         db,
 	}
 }
 
+// UsingContext uses the Database carried by ctx (see Database.WithContext), when present
+func (t *Table[T]) UsingContext(ctx context.Context) *Table[T] {
+	if db, ok := FromContext(ctx); ok {
+		return t.Using(db)
+	}
+	return t
+}
+
+// Index declares an index of the table, created by EnsureIndexes
+func (t *Table[T]) Index(index TableIndex) *Table[T] {
+	t.indexes = append(t.indexes, index)
+	return t
+}
+
+// EnsureIndexes creates the declared indexes (see Index and TableIndexer) that do not exist yet
+func (t *Table[T]) EnsureIndexes() error {
+	db, err := t.getDb()
+	if err != nil {
+		return err
+	}
+
+	for _, query := range t.createIndexesSql() {
+		if _, err = db.Execute(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Table[T]) createIndexesSql() []string {
+	var queries []string
+	for _, index := range t.indexes {
+		queries = append(queries, buildCreateIndex(t.identifier, index.Name, index.Unique, index.Columns, index.Where))
+	}
+	return queries
+}
+
 func (t *Table[T]) getDb() (*Database, error) {
 	if t.db == nil {
 		return GetInstance()
@@ -56,6 +123,157 @@ func (t *Table[T]) getDb() (*Database, error) {
 	return t.db, nil
 }
 
+// GetByID selects the row by its primary key. Composite keys receive one arg per primary key column, in the order
+// of the fields of the model, or a single T (or *T) whose primary key fields are used. Returns sql.ErrNoRows when the
+// row does not exist.
+func (t *Table[T]) GetByID(args ...interface{}) (*T, error) {
+	if len(args) == 1 {
+		if model, isModel := args[0].(T); isModel {
+			args = t.primaryKeyValues(&model)
+		} else if model, isModel := args[0].(*T); isModel && model != nil {
+			args = t.primaryKeyValues(model)
+		}
+	}
+	if err := t.checkPrimaryKey(len(args)); err != nil {
+		return nil, err
+	}
+
+	db, err := t.getDb()
+	if err != nil {
+		return nil, err
+	}
+
+	model := new(T)
+	value := reflect.ValueOf(model).Elem()
+
+	var columns []string
+	var dest []interface{}
+	for _, column := range t.columns {
+		columns = append(columns, QuoteIdentifier(column.name))
+		dest = append(dest, value.FieldByIndex(column.index).Addr().Interface())
+	}
+
+	query := "SELECT " + strings.Join(columns, ", ") + " FROM " + t.identifier + t.wherePrimaryKey(0)
+	if err = db.QueryRowOld(query, args...).Scan(dest...); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// Update updates the row of the model, setting all columns that are not part of the primary key and using all primary
+// key columns in the WHERE
+func (t *Table[T]) Update(model *T) (sql.Result, error) {
+	if err := t.checkPrimaryKey(len(t.primaryKey)); err != nil {
+		return nil, err
+	}
+
+	db, err := t.getDb()
+	if err != nil {
+		return nil, err
+	}
+
+	value := reflect.ValueOf(model).Elem()
+
+	var set []string
+	var args []interface{}
+	for _, column := range t.columns {
+		if !column.pk {
+			args = append(args, bindValue(value.FieldByIndex(column.index).Interface()))
+			set = append(set, QuoteIdentifier(column.name)+" = $"+strconv.Itoa(len(args)))
+		}
+	}
+	if len(set) == 0 {
+		return nil, errors.New("table " + t.identifier + " has no column to update")
+	}
+
+	query := "UPDATE " + t.identifier + " SET " + strings.Join(set, ", ") + t.wherePrimaryKey(len(args))
+	return db.Execute(query, append(args, t.primaryKeyValues(model)...)...)
+}
+
+// Delete deletes the row of the model, using all primary key columns in the WHERE
+func (t *Table[T]) Delete(model *T) (sql.Result, error) {
+	if err := t.checkPrimaryKey(len(t.primaryKey)); err != nil {
+		return nil, err
+	}
+
+	db, err := t.getDb()
+	if err != nil {
+		return nil, err
+	}
+	return db.Execute("DELETE FROM "+t.identifier+t.wherePrimaryKey(0), t.primaryKeyValues(model)...)
+}
+
+// checkPrimaryKey validates the number of args received for the primary key of the table
+func (t *Table[T]) checkPrimaryKey(count int) error {
+	if len(t.primaryKey) == 0 {
+		return errors.New("table " + t.identifier + " has no primary key, tag the fields with `pg:\"column,pk\"`")
+	}
+	if count != len(t.primaryKey) {
+		return errors.New(fmt.Sprintf(
+			"table %s has a primary key of %d columns, received %d args", t.identifier, len(t.primaryKey), count,
+		))
+	}
+	return nil
+}
+
+// wherePrimaryKey the WHERE of the primary key columns, the placeholders start after offset
+func (t *Table[T]) wherePrimaryKey(offset int) string {
+	var where []string
+	for i, column := range t.primaryKey {
+		where = append(where, QuoteIdentifier(column.name)+" = $"+strconv.Itoa(offset+i+1))
+	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
+func (t *Table[T]) primaryKeyValues(model *T) []interface{} {
+	value := reflect.ValueOf(model).Elem()
+	var values []interface{}
+	for _, column := range t.primaryKey {
+		values = append(values, value.FieldByIndex(column.index).Interface())
+	}
+	return values
+}
+
+// structColumns maps the exported fields of the struct to columns, see tableColumn
+func structColumns(modelType reflect.Type) []tableColumn {
+	var columns []tableColumn
+	for i := 0; i < modelType.NumField(); i++ {
+		field := modelType.Field(i)
+		tag := field.Tag.Get("pg")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		options := strings.Split(tag, ",")
+		column := tableColumn{name: options[0], index: field.Index}
+		if column.name == "" {
+			column.name = snakeCase(field.Name)
+		}
+		for _, option := range options[1:] {
+			if option == "pk" {
+				column.pk = true
+			}
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// snakeCase converts a field name to the default column name, e.g. TenantId to tenant_id
+func snakeCase(name string) string {
+	var result []rune
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				result = append(result, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		result = append(result, r)
+	}
+	return string(result)
+}
+
 //func (t *Table[T]) Insert(values ...T) (bool, error) {
 //	if db, err := t.getDb(); err != nil {
 //		return false, err
@@ -100,6 +318,20 @@ func NewTable[T any](schema, name string, model T) (*Table[T], error) {
 		identifier: QuoteIdentifier(schema) + "." + QuoteIdentifier(name),
 	}
 
+	// the columns are only mapped when T is the struct itself (not a pointer)
+	if reflect.TypeOf((*T)(nil)).Elem() == modelType {
+		t.columns = structColumns(modelType)
+		for _, column := range t.columns {
+			if column.pk {
+				t.primaryKey = append(t.primaryKey, column)
+			}
+		}
+	}
+
+	if indexer, ok := any(model).(TableIndexer); ok {
+		t.indexes = indexer.Indexes()
+	}
+
 	return t, nil
 }
 
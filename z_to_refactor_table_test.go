@@ -1,7 +1,146 @@
 package pg
 
-import "testing"
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dhui/dktest"
+)
 
 func Test_teste(t *testing.T) {
 	teste()
 }
+
+type indexedModel struct {
+	Email     string
+	DeletedAt string
+}
+
+func (m indexedModel) Indexes() []TableIndex {
+	return []TableIndex{
+		{Name: "users_email_idx", Columns: []string{"lower(email)"}, Unique: true, Where: "deleted_at IS NULL"},
+	}
+}
+
+func TestTableIndexes(t *testing.T) {
+	table, err := NewTable("auth", "users", indexedModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.Index(TableIndex{Name: "users_created_idx", Columns: []string{"created_at", "id"}})
+
+	queries := table.createIndexesSql()
+	want := []string{
+		`CREATE UNIQUE INDEX IF NOT EXISTS "users_email_idx" ON "auth"."users" ((lower(email))) WHERE deleted_at IS NULL`,
+		`CREATE INDEX IF NOT EXISTS "users_created_idx" ON "auth"."users" ("created_at", "id")`,
+	}
+	if len(queries) != len(want) {
+		t.Fatalf("unexpected queries %v", queries)
+	}
+	for i := range want {
+		if queries[i] != want[i] {
+			t.Errorf("got  %s\nwant %s", queries[i], want[i])
+		}
+	}
+}
+
+type memberModel struct {
+	TenantId string `pg:"tenant_id,pk"`
+	Id       int64  `pg:"id,pk"`
+	FullName string
+	Internal string `pg:"-"`
+}
+
+func TestTableCompositeKey(t *testing.T) {
+	db, log := openFakeDatabase(t)
+	log.result(`SELECT "tenant_id", "id", "full_name" FROM "public"."members" WHERE "tenant_id" = $1 AND "id" = $2`,
+		[]string{"tenant_id", "id", "full_name"}, []driver.Value{"t1", int64(7), "Alice"})
+
+	members, err := NewTable("public", "members", memberModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	members = members.Using(db)
+
+	member, err := members.GetByID("t1", int64(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *member != (memberModel{TenantId: "t1", Id: 7, FullName: "Alice"}) {
+		t.Errorf("unexpected member %+v", member)
+	}
+	if _, err = members.GetByID(memberModel{TenantId: "t1", Id: 7}); err != nil {
+		t.Errorf("GetByID(struct): unexpected error %v", err)
+	}
+	if _, err = members.GetByID("t1"); err == nil || !strings.Contains(err.Error(), "2 columns, received 1 args") {
+		t.Errorf("expected arg count error, got %v", err)
+	}
+
+	member.FullName = "Alice Cooper"
+	if _, err = members.Update(member); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = members.Delete(member); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := NewTable("public", "users", UserModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = users.Using(db).GetByID("u1"); err == nil || !strings.Contains(err.Error(), "has no primary key") {
+		t.Errorf("expected no primary key error, got %v", err)
+	}
+
+	want := `SELECT "tenant_id", "id", "full_name" FROM "public"."members" WHERE "tenant_id" = $1 AND "id" = $2;` +
+		`SELECT "tenant_id", "id", "full_name" FROM "public"."members" WHERE "tenant_id" = $1 AND "id" = $2;` +
+		`UPDATE "public"."members" SET "full_name" = $1 WHERE "tenant_id" = $2 AND "id" = $3;` +
+		`DELETE FROM "public"."members" WHERE "tenant_id" = $1 AND "id" = $2`
+	if got := log.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestTableCompositeKeyDatabase(t *testing.T) {
+	parallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		db := openTestDatabase(t, c)
+		defer db.Close()
+
+		_, err := db.Execute(`CREATE TABLE members (tenant_id TEXT, id BIGINT, full_name TEXT, PRIMARY KEY (tenant_id, id));
+			INSERT INTO members VALUES ('t1', 1, 'Alice'), ('t2', 1, 'Bob')`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		members, err := NewTable("public", "members", memberModel{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		members = members.Using(db)
+
+		member, err := members.GetByID("t2", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		member.FullName = "Bob Dylan"
+		if result, err := members.Update(member); err != nil {
+			t.Fatal(err)
+		} else if rows, _ := result.RowsAffected(); rows != 1 {
+			t.Errorf("expected a single row updated, got %d", rows)
+		}
+
+		if member, err = members.GetByID("t1", 1); err != nil || member.FullName != "Alice" {
+			t.Errorf("unexpected member %+v, %v", member, err)
+		}
+
+		if _, err = members.Delete(&memberModel{TenantId: "t1", Id: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = members.GetByID("t1", 1); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected sql.ErrNoRows, got %v", err)
+		}
+	})
+}